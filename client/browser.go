@@ -28,6 +28,7 @@ type GameBrowserMenu struct {
 type GameServerRow struct {
 	info *protocol.GameServerInfo
 	ConnectButton widget.Clickable	
+	SpectateButton widget.Clickable
 
 }
 
@@ -126,13 +127,18 @@ func drawServerRow(gtx layout.Context, th *material.Theme, server *GameServerRow
                     }),
                     layout.Rigid(func(gtx layout.Context) layout.Dimensions {
                         return material.Body1(th,
-                            fmt.Sprintf("%d", server.info.PlayerCount)).Layout(gtx)
+                            fmt.Sprintf("%d (%d watching)", server.info.PlayerCount, server.info.SpectatorCount)).Layout(gtx)
                     }),
                     layout.Rigid(layout.Spacer{Width: unit.Dp(16)}.Layout),
                     layout.Rigid(func(gtx layout.Context) layout.Dimensions {
                         btn := material.Button(th, &server.ConnectButton, "Connect")
                         return btn.Layout(gtx)
                     }),
+                    layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+                    layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+                        btn := material.Button(th, &server.SpectateButton, "Spectate")
+                        return btn.Layout(gtx)
+                    }),
                 )
             })
 			call := macro.Stop()
@@ -156,6 +162,9 @@ func drawBrowserMenu(gtx layout.Context, th *material.Theme, menu *Menu){
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 			return drawSpawnServerMenu(gtx, th, menu)
 		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return drawReplayMenu(gtx, th, menu)
+		}),
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 			return drawHeader(gtx, th, menu)
 		}),
@@ -5,8 +5,11 @@ import (
 	"image"
 	"image/color"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"gioui.org/app"
 	"gioui.org/io/event"
@@ -21,6 +24,7 @@ import (
 	"gioui.org/widget/material"
 
 	"github.com/tomasstrnad1997/mines/mines"
+	"github.com/tomasstrnad1997/mines/mines/solver"
 	"github.com/tomasstrnad1997/mines/protocol"
 )
 
@@ -38,6 +42,7 @@ const (
 	BrowserMenu
     GameStartMenu
     GameScreen
+    ReplayScreen
 )
 
 var (
@@ -48,19 +53,43 @@ type Menu struct {
     ipEditor widget.Editor
     connectButton widget.Clickable
     connecting bool
+    // Connected to the game server only to watch the game
+    spectating bool
     gameEndResult protocol.GameEndType
+    gameOutcome *mines.GameOutcome
     
     widthEditor widget.Editor
     heightEditor widget.Editor
     minesEditor widget.Editor
+    firstMoveSafe widget.Bool
+    noGuess widget.Bool
+    questionMarks widget.Bool
+    gameMode widget.Enum
+    topology widget.Enum
+    turnTimeEditor widget.Editor
+    livesEditor widget.Editor
+    livesPerPlayer widget.Bool
+    timeLimitEditor widget.Editor
+    shapeEditor widget.Editor
+    boardFileEditor widget.Editor
+    infinite widget.Bool
+    allowUndo widget.Bool
     startButton widget.Clickable
 
     restartButton widget.Clickable
     newGameButton widget.Clickable
+    hintButton widget.Clickable
+    undoButton widget.Clickable
+    // Move the viewport of infinite boards
+    panLeft widget.Clickable
+    panRight widget.Clickable
+    panUp widget.Clickable
+    panDown widget.Clickable
 
     state AppState
 
 	browser *GameBrowserMenu
+	viewer *ReplayViewer
 
 }
 
@@ -68,6 +97,9 @@ type Cell struct {
 	isMine     bool
 	isRevealed bool
 	isFlagged  bool
+	isQuestioned bool
+	// Not part of the board shape and never drawn
+	isDisabled bool
 	neighborMines int
     x int
     y int
@@ -76,21 +108,49 @@ type Cell struct {
 
 type GameManager struct {
     grid [][]Cell
-    cellColorGrid [][]int
+    cellColorGrid [][]uint32
+    // Cells of infinite boards by chunk. Only the chunks in the viewport are kept.
+    chunks map[mines.ChunkPos]*[mines.ChunkSize * mines.ChunkSize]Cell
+    chunkColors map[mines.Position]uint32
+    // Top left cell of the viewport of infinite boards
+    viewport image.Point
     params mines.GameParams
+    hint *solver.Hint
+    versusProgress []mines.VersusPlayerProgress
+    turnInfo *mines.TurnBasedInfoUpdate
+    flagsInfo *mines.FlagsInfoUpdate
+    livesInfo *mines.LivesInfoUpdate
+    // Time since the first move as sent by the server
+    elapsed time.Duration
+    lastClick cellClick
     gameController *protocol.ConnectionController
 	matchmakingController *protocol.ConnectionController
+    // Shows a replay, there is no server to play on or subscribe to chunks of
+    readOnly bool
 }
 
 const (
     cellSpacing int = 2 
+    doubleClickDuration = 300 * time.Millisecond
+    // Cells of an infinite board shown at once
+    viewportWidth = 30
+    viewportHeight = 20
+    // Cells the viewport moves by with one press of a pan button
+    panStep = mines.ChunkSize / 2
 )
 
+type cellClick struct {
+	cell *Cell
+	time time.Duration
+}
+
 type pressedMouseButton byte
 const (
     NoButton pressedMouseButton = iota
     PrimaryButton
     SecondaryButton
+    // Middle button or a double click
+    ChordButton
 )
 
 func (manager *GameManager) HandleGamemodeUpdateInfo(info mines.GamemodeUpdateInfo) error {
@@ -104,6 +164,36 @@ func (manager *GameManager) HandleGamemodeUpdateInfo(info mines.GamemodeUpdateIn
 		if err := manager.ApplyCoopUpdateInfo(i); err != nil {
 			return err
 		}
+	case mines.ModeVersus:
+		i, ok := info.(*mines.VersusInfoUpdate)
+		if !ok {
+			return fmt.Errorf("Failed to cast to VersusInfoUpdate")
+		}
+		manager.versusProgress = i.Players
+	case mines.ModeTurnBased:
+		i, ok := info.(*mines.TurnBasedInfoUpdate)
+		if !ok {
+			return fmt.Errorf("Failed to cast to TurnBasedInfoUpdate")
+		}
+		manager.turnInfo = i
+	case mines.ModeFlags:
+		i, ok := info.(*mines.FlagsInfoUpdate)
+		if !ok {
+			return fmt.Errorf("Failed to cast to FlagsInfoUpdate")
+		}
+		for _, cellInfo := range i.MarksChange {
+			manager.setCellColor(cellInfo.X, cellInfo.Y, cellInfo.PlayerId)
+		}
+		manager.flagsInfo = i
+	case mines.ModeLives:
+		i, ok := info.(*mines.LivesInfoUpdate)
+		if !ok {
+			return fmt.Errorf("Failed to cast to LivesInfoUpdate")
+		}
+		for _, cellInfo := range i.ExplodedMines {
+			manager.setCellColor(cellInfo.X, cellInfo.Y, cellInfo.PlayerId)
+		}
+		manager.livesInfo = i
 	default:
 		return fmt.Errorf("Unknown GameId: %d", gameModeId)
 	}
@@ -112,19 +202,175 @@ func (manager *GameManager) HandleGamemodeUpdateInfo(info mines.GamemodeUpdateIn
 
 func (manager *GameManager) ApplyCoopUpdateInfo(info *mines.CoopInfoUpdate) error {
 	for _, cellInfo := range info.MarksChange {
-		manager.cellColorGrid[cellInfo.X][cellInfo.Y] = cellInfo.PlayerId
+		manager.setCellColor(cellInfo.X, cellInfo.Y, cellInfo.PlayerId)
 	}
 	return nil
 }
 
+// Returns the cell at (x, y) of either kind of board. Cells of infinite boards
+// outside of the kept chunks are nil.
+func (manager *GameManager) cellAt(x, y int) *Cell {
+    if !manager.params.Infinite {
+        return &manager.grid[x][y]
+    }
+    chunk := mines.ChunkOf(x, y)
+    cells, ok := manager.chunks[chunk]
+    if !ok {
+        return nil
+    }
+    origin := chunk.Origin()
+    return &cells[(x-origin.X)+(y-origin.Y)*mines.ChunkSize]
+}
+
+func (manager *GameManager) setCellColor(x, y int, playerId uint32) {
+    if manager.params.Infinite {
+        manager.chunkColors[mines.Position{X: x, Y: y}] = playerId
+        return
+    }
+    manager.cellColorGrid[x][y] = playerId
+}
+
+func (manager *GameManager) cellColor(x, y int) uint32 {
+    if manager.params.Infinite {
+        return manager.chunkColors[mines.Position{X: x, Y: y}]
+    }
+    return manager.cellColorGrid[x][y]
+}
+
+// Chunks of an infinite board overlapping the viewport
+func (manager *GameManager) visibleChunks() []mines.ChunkPos {
+    first := mines.ChunkOf(manager.viewport.X, manager.viewport.Y)
+    last := mines.ChunkOf(manager.viewport.X+viewportWidth-1, manager.viewport.Y+viewportHeight-1)
+    var chunks []mines.ChunkPos
+    for y := first.Y; y <= last.Y; y++ {
+        for x := first.X; x <= last.X; x++ {
+            chunks = append(chunks, mines.ChunkPos{X: x, Y: y})
+        }
+    }
+    return chunks
+}
+
+// Subscribes to the chunks that came into the viewport and unsubscribes from
+// the ones that left it. Cells of chunks that left are forgotten, the server
+// sends them again on the next subscription.
+func (manager *GameManager) updateSubscriptions() error {
+    boardMutex.Lock()
+    visible := make(map[mines.ChunkPos]bool)
+    var subscribe, unsubscribe []mines.ChunkPos
+    for _, chunk := range manager.visibleChunks() {
+        visible[chunk] = true
+        if _, ok := manager.chunks[chunk]; !ok {
+            subscribe = append(subscribe, chunk)
+            cells := &[mines.ChunkSize * mines.ChunkSize]Cell{}
+            origin := chunk.Origin()
+            for i := range cells {
+                cells[i].x = origin.X + i%mines.ChunkSize
+                cells[i].y = origin.Y + i/mines.ChunkSize
+            }
+            manager.chunks[chunk] = cells
+        }
+    }
+    for chunk := range manager.chunks {
+        if !visible[chunk] {
+            unsubscribe = append(unsubscribe, chunk)
+            delete(manager.chunks, chunk)
+        }
+    }
+    boardMutex.Unlock()
+    if manager.readOnly {
+        return nil
+    }
+    if len(unsubscribe) > 0 {
+        encoded, err := protocol.EncodeUnsubscribeChunks(unsubscribe)
+        if err != nil {
+            return err
+        }
+        if err = manager.gameController.SendMessage(encoded); err != nil {
+            return err
+        }
+    }
+    if len(subscribe) > 0 {
+        encoded, err := protocol.EncodeSubscribeChunks(subscribe)
+        if err != nil {
+            return err
+        }
+        if err = manager.gameController.SendMessage(encoded); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (manager *GameManager) pan(dx, dy int) {
+    if !manager.params.Infinite {
+        return
+    }
+    manager.viewport.X += dx
+    manager.viewport.Y += dy
+    if err := manager.updateSubscriptions(); err != nil {
+        println("Failed to update chunk subscriptions:", err.Error())
+    }
+}
+
+// Applies an update of a cell of either kind of board
+func (manager *GameManager) applyCellUpdate(cell mines.UpdatedCell) {
+    if hint := manager.hint; hint != nil && hint.X == cell.X && hint.Y == cell.Y {
+        manager.hint = nil
+    }
+    c := manager.cellAt(cell.X, cell.Y)
+    if c == nil {
+        return
+    }
+    if (cell.Value & 0xF0) == 0{
+        c.neighborMines = int(cell.Value)
+        c.isRevealed = true
+        c.isQuestioned = false
+        return
+    }
+    switch cell.Value {
+    case mines.Unflag:
+        // Also sent for revealed cells hidden again by an undo
+        if c.isRevealed {
+            c.isRevealed = false
+            c.isMine = false
+            c.neighborMines = 0
+            manager.setCellColor(cell.X, cell.Y, 0)
+        }
+        c.isFlagged = false
+        c.isQuestioned = false
+    case mines.ShowFlag:
+        c.isFlagged = true
+        c.isQuestioned = false
+    case mines.ShowQuestion:
+        c.isFlagged = false
+        c.isQuestioned = true
+    case mines.ShowMine:
+        c.isMine = true
+        c.isRevealed = true
+        c.isQuestioned = false
+    }
+}
+
 func createCell(cell_size int, manager *GameManager, cell *Cell, ops *op.Ops, q input.Source, th *material.Theme, gtx layout.Context ) {
+    if cell.isDisabled {
+        return
+    }
     size := image.Point{X:cell_size, Y:cell_size }
     r := image.Rectangle{Max: size}
-    offset := image.Point{X: (cellSpacing+cell_size)*cell.x, Y: (cellSpacing+cell_size)*cell.y}
+    // Cells of infinite boards are placed relative to the viewport
+    x, y := cell.x, cell.y
+    if manager.params.Infinite {
+        x, y = x-manager.viewport.X, y-manager.viewport.Y
+    }
+    offset := image.Point{X: (cellSpacing+cell_size)*x, Y: (cellSpacing+cell_size)*y}
+    // Hexagonal boards are drawn as rows of bricks, odd rows shifted by half a cell
+    if manager.params.Topology == mines.TopologyHex && cell.y%2 == 1 {
+        offset.X += (cellSpacing+cell_size)/2
+    }
     defer op.Offset(offset).Push(ops).Pop()
     defer clip.Rect(r).Push(ops).Pop()
     event.Op(ops, cell)
-    err := handleCellPressed(ReadCellPresses(cell, q), cell, manager)
+    err := handleCellPressed(ReadCellPresses(cell, q, &manager.lastClick), cell, manager)
     if err != nil {
         println("Failed to send button press:", err.Error())
     }
@@ -135,11 +381,15 @@ func createCell(cell_size int, manager *GameManager, cell *Cell, ops *op.Ops, q
 	overlayColor := getOverlayColor(cell, manager)
     paint.ColorOp{Color: overlayColor}.Add(ops)
     paint.PaintOp{}.Add(ops)
+	if hint := manager.hint; hint != nil && hint.X == cell.x && hint.Y == cell.y {
+		paint.ColorOp{Color: getHintColor(hint)}.Add(ops)
+		paint.PaintOp{}.Add(ops)
+	}
     drawMark(mark, ops, th, gtx)
 }
 
 func getOverlayColor(cell *Cell, manager *GameManager) color.NRGBA{
-	playerId := manager.cellColorGrid[cell.x][cell.y]
+	playerId := manager.cellColor(cell.x, cell.y)
 	switch playerId{
 	case 1:
 		return color.NRGBA{R: 0xAA, G: 0x00, B: 0x00, A: 0x40} 
@@ -151,7 +401,20 @@ func getOverlayColor(cell *Cell, manager *GameManager) color.NRGBA{
 
 }
 
+func getHintColor(hint *solver.Hint) color.NRGBA{
+	if hint.Type == mines.Flag {
+		return color.NRGBA{R: 0xFF, G: 0x80, B: 0x00, A: 0x80}
+	}
+	if hint.Probability > 0 {
+		return color.NRGBA{R: 0xFF, G: 0xFF, B: 0x00, A: 0x80}
+	}
+	return color.NRGBA{R: 0x00, G: 0xFF, B: 0x80, A: 0x80}
+}
+
 func handleCellPressed(buttonPressed pressedMouseButton, cell *Cell, manager *GameManager) error {
+    if manager.readOnly {
+        return nil
+    }
     var mType mines.MoveType
     switch buttonPressed {
     case NoButton:
@@ -160,6 +423,8 @@ func handleCellPressed(buttonPressed pressedMouseButton, cell *Cell, manager *Ga
         mType = mines.Reveal
     case SecondaryButton:
         mType = mines.Flag
+    case ChordButton:
+        mType = mines.Chord
     default:
         return fmt.Errorf("Unknown button pressed")
     }
@@ -175,7 +440,7 @@ func handleCellPressed(buttonPressed pressedMouseButton, cell *Cell, manager *Ga
     return nil
 }
 
-func ReadCellPresses(cell *Cell, q input.Source) pressedMouseButton {
+func ReadCellPresses(cell *Cell, q input.Source, lastClick *cellClick) pressedMouseButton {
 	for {
 		ev, ok := q.Event(pointer.Filter{
 			Target: cell,
@@ -187,9 +452,16 @@ func ReadCellPresses(cell *Cell, q input.Source) pressedMouseButton {
         if x, ok := ev.(pointer.Event); ok {
             if x.Kind == pointer.Press {
                 if x.Buttons.Contain(pointer.ButtonPrimary) {
+                    if lastClick.cell == cell && x.Time-lastClick.time < doubleClickDuration {
+                        *lastClick = cellClick{}
+                        return ChordButton
+                    }
+                    *lastClick = cellClick{cell: cell, time: x.Time}
                     return PrimaryButton
                 }else if x.Buttons.Contain(pointer.ButtonSecondary) {
                     return SecondaryButton
+                }else if x.Buttons.Contain(pointer.ButtonTertiary) {
+                    return ChordButton
                 }
             }
         }
@@ -212,6 +484,9 @@ func getCellColorAndMark(cell *Cell) (c color.NRGBA, mark string) {
     if cell.isFlagged{
         c = color.NRGBA{R: 0xAA, G: 0x00, B: 0x00, A: 0xFF} 
     }
+    if cell.isQuestioned{
+        mark = "?"
+    }
     return c, mark
 }
 
@@ -245,6 +520,48 @@ func drawConfigMenu(gtx layout.Context, th *material.Theme, menu *Menu) layout.D
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 				return material.Editor(th, &menu.minesEditor, "Number of Mines").Layout(gtx)
 			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Spacer{Height: unit.Dp(8)}.Layout(gtx) // Add spacing
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.CheckBox(th, &menu.firstMoveSafe, "First move safe").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.CheckBox(th, &menu.noGuess, "No guessing").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.CheckBox(th, &menu.questionMarks, "Question marks").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return drawGameModeSelection(gtx, th, menu)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return drawTopologySelection(gtx, th, menu)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Editor(th, &menu.turnTimeEditor, "Turn time (s)").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Editor(th, &menu.livesEditor, "Lives").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.CheckBox(th, &menu.livesPerPlayer, "Lives per player").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Editor(th, &menu.timeLimitEditor, "Time limit (s)").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Editor(th, &menu.shapeEditor, "Shape rows (# cell, . hole)").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Editor(th, &menu.boardFileEditor, "Board file").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.CheckBox(th, &menu.infinite, "Infinite board (mines per chunk)").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.CheckBox(th, &menu.allowUndo, "Allow undo").Layout(gtx)
+			}),
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 				return layout.Spacer{Height: unit.Dp(16)}.Layout(gtx) // Add spacing
 			}),
@@ -255,10 +572,40 @@ func drawConfigMenu(gtx layout.Context, th *material.Theme, menu *Menu) layout.D
 	})
 }
 
+var menuGameModes = []mines.GameModeId{mines.ModeCoop, mines.ModeVersus, mines.ModeTurnBased, mines.ModeFlags, mines.ModeLives, mines.ModeTimeAttack}
+
+func drawGameModeSelection(gtx layout.Context, th *material.Theme, menu *Menu) layout.Dimensions {
+    children := make([]layout.FlexChild, len(menuGameModes))
+    for i, mode := range menuGameModes {
+        children[i] = layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+            return material.RadioButton(th, &menu.gameMode, strconv.Itoa(int(mode)), mines.GameModeNames[mode]).Layout(gtx)
+        })
+    }
+    return layout.Flex{Axis: layout.Horizontal}.Layout(gtx, children...)
+}
+
+var menuTopologies = []mines.TopologyId{mines.TopologySquare, mines.TopologyHex, mines.TopologyTorus, mines.TopologyKnight}
+
+func drawTopologySelection(gtx layout.Context, th *material.Theme, menu *Menu) layout.Dimensions {
+    children := make([]layout.FlexChild, len(menuTopologies))
+    for i, topology := range menuTopologies {
+        children[i] = layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+            return material.RadioButton(th, &menu.topology, strconv.Itoa(int(topology)), mines.TopologyNames[topology]).Layout(gtx)
+        })
+    }
+    return layout.Flex{Axis: layout.Horizontal}.Layout(gtx, children...)
+}
+
 func drawBoard(manager *GameManager, ops *op.Ops, q input.Source, th *material.Theme, gtx layout.Context) layout.Dimensions{
+    if manager.params.Infinite {
+        return drawViewport(manager, ops, q, th, gtx)
+    }
     cellSize := int(gtx.Metric.PxPerDp * 25)
     totalWidth := manager.params.Width*cellSize + (manager.params.Width-1)*cellSpacing
     totalHeight := manager.params.Height*cellSize + (manager.params.Height-1)*cellSpacing
+    if manager.params.Topology == mines.TopologyHex {
+        totalWidth += (cellSpacing+cellSize)/2
+    }
     offset := image.Point{X: 10, Y: 10}
     defer op.Offset(offset).Push(ops).Pop()
     boardMutex.Lock()
@@ -273,7 +620,45 @@ func drawBoard(manager *GameManager, ops *op.Ops, q input.Source, th *material.T
     }   
 }
 
-func drawGameScreen(manager *GameManager, ops *op.Ops, q input.Source, th *material.Theme, gtx layout.Context) layout.Dimensions{
+// Draws the part of an infinite board in the viewport
+func drawViewport(manager *GameManager, ops *op.Ops, q input.Source, th *material.Theme, gtx layout.Context) layout.Dimensions{
+    cellSize := int(gtx.Metric.PxPerDp * 25)
+    totalWidth := viewportWidth*cellSize + (viewportWidth-1)*cellSpacing
+    totalHeight := viewportHeight*cellSize + (viewportHeight-1)*cellSpacing
+    offset := image.Point{X: 10, Y: 10}
+    defer op.Offset(offset).Push(ops).Pop()
+    boardMutex.Lock()
+    for row := range viewportHeight {
+        for col := range viewportWidth {
+            if cell := manager.cellAt(manager.viewport.X+col, manager.viewport.Y+row); cell != nil {
+                createCell(cellSize, manager, cell, ops, q, th, gtx)
+            }
+        }
+    }
+    boardMutex.Unlock()
+    return layout.Dimensions{
+        Size: image.Point{X: totalWidth + offset.X*2, Y: totalHeight + offset.Y*2},
+    }
+}
+
+func drawPanButtons(gtx layout.Context, th *material.Theme, menu *Menu) layout.Dimensions {
+    return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+        layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+            return material.Button(th, &menu.panLeft, "←").Layout(gtx)
+        }),
+        layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+            return material.Button(th, &menu.panUp, "↑").Layout(gtx)
+        }),
+        layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+            return material.Button(th, &menu.panDown, "↓").Layout(gtx)
+        }),
+        layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+            return material.Button(th, &menu.panRight, "→").Layout(gtx)
+        }),
+    )
+}
+
+func drawGameScreen(manager *GameManager, menu *Menu, ops *op.Ops, q input.Source, th *material.Theme, gtx layout.Context) layout.Dimensions{
 	return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return layout.Flex{
 			Axis:    layout.Vertical,
@@ -282,11 +667,153 @@ func drawGameScreen(manager *GameManager, ops *op.Ops, q input.Source, th *mater
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
                 return drawBoard(manager, ops, q, th, gtx)
 			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if manager.params.Infinite {
+					return drawPanButtons(gtx, th, menu)
+				}
+				if menu.spectating {
+					return layout.Dimensions{}
+				}
+				return material.Button(th, &menu.hintButton, "Hint").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if !manager.params.AllowUndo || menu.spectating {
+					return layout.Dimensions{}
+				}
+				return material.Button(th, &menu.undoButton, "Undo").Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Label(th, unit.Sp(16), clockText(manager.elapsed, manager.params.TimeLimit)).Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Label(th, unit.Sp(16), gamemodeStatusText(manager)).Layout(gtx)
+			}),
 		)
 	})
 }
 
-func drawEndGame(gtx layout.Context, th *material.Theme, menu *Menu) layout.Dimensions{
+func formatDuration(d time.Duration) string {
+    seconds := int(d.Seconds())
+    return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func clockText(elapsed time.Duration, limit time.Duration) string {
+    if limit > 0 {
+        return fmt.Sprintf("Time: %s / %s", formatDuration(elapsed), formatDuration(limit))
+    }
+    return "Time: " + formatDuration(elapsed)
+}
+
+func gamemodeStatusText(manager *GameManager) string {
+    if manager.turnInfo != nil {
+        return turnInfoText(manager.turnInfo)
+    }
+    if manager.flagsInfo != nil {
+        return flagsInfoText(manager.flagsInfo)
+    }
+    if manager.livesInfo != nil {
+        return livesInfoText(manager.livesInfo, manager.params.LivesPerPlayer)
+    }
+    return versusProgressText(manager.versusProgress)
+}
+
+func turnInfoText(info *mines.TurnBasedInfoUpdate) string {
+    txt := fmt.Sprintf("Turn of player %d", info.CurrentPlayer)
+    if info.TurnTimeLeft > 0 {
+        txt += fmt.Sprintf(" (%ds left)", int(info.TurnTimeLeft.Seconds()))
+    }
+    txt += "\n"
+    playerIds := make([]uint32, 0, len(info.PlayerScores))
+    for playerId := range info.PlayerScores {
+        playerIds = append(playerIds, playerId)
+    }
+    slices.Sort(playerIds)
+    for _, playerId := range playerIds {
+        txt += fmt.Sprintf("Player %d: %d points\n", playerId, info.PlayerScores[playerId])
+    }
+    return txt
+}
+
+func flagsInfoText(info *mines.FlagsInfoUpdate) string {
+    txt := fmt.Sprintf("Turn of player %d\n", info.CurrentPlayer)
+    if info.Winner != 0 {
+        txt = fmt.Sprintf("Player %d claimed most mines\n", info.Winner)
+    }
+    playerIds := make([]uint32, 0, len(info.ClaimedMines))
+    for playerId := range info.ClaimedMines {
+        playerIds = append(playerIds, playerId)
+    }
+    slices.Sort(playerIds)
+    for _, playerId := range playerIds {
+        txt += fmt.Sprintf("Player %d: %d mines\n", playerId, info.ClaimedMines[playerId])
+    }
+    return txt
+}
+
+func livesInfoText(info *mines.LivesInfoUpdate, perPlayer bool) string {
+    if !perPlayer {
+        return fmt.Sprintf("Lives left: %d", info.TeamLives)
+    }
+    playerIds := make([]uint32, 0, len(info.PlayerLives))
+    for playerId := range info.PlayerLives {
+        playerIds = append(playerIds, playerId)
+    }
+    slices.Sort(playerIds)
+    txt := ""
+    for _, playerId := range playerIds {
+        txt += fmt.Sprintf("Player %d: %d lives\n", playerId, info.PlayerLives[playerId])
+    }
+    return txt
+}
+
+func versusProgressText(progress []mines.VersusPlayerProgress) string {
+    txt := ""
+    for _, player := range progress {
+        txt += fmt.Sprintf("Player %d: %d%%", player.PlayerId, player.Progress)
+        if player.Eliminated {
+            txt += " (eliminated)"
+        }
+        txt += "\n"
+    }
+    return txt
+}
+
+func outcomeText(outcome *mines.GameOutcome) string {
+    if outcome == nil {
+        return ""
+    }
+    txt := ""
+    switch outcome.Reason {
+    case mines.EndMineBlown:
+        txt = fmt.Sprintf("Player %d blew a mine\n", outcome.PlayerId)
+    case mines.EndOutOfLives:
+        txt = fmt.Sprintf("Player %d lost the last life\n", outcome.PlayerId)
+    case mines.EndEliminated:
+        txt = "Every player blew a mine\n"
+    case mines.EndBoardCleared:
+        txt = fmt.Sprintf("Player %d cleared the board\n", outcome.PlayerId)
+    case mines.EndScoreReached:
+        txt = fmt.Sprintf("Player %d reached the winning score\n", outcome.PlayerId)
+    case mines.EndTimeUp:
+        txt = "Time ran out\n"
+    }
+    if outcome.Elapsed > 0 {
+        txt += "Time: " + formatDuration(outcome.Elapsed) + "\n"
+    }
+    if len(outcome.Winners) > 0 {
+        txt += "Winner:"
+        for _, playerId := range outcome.Winners {
+            txt += fmt.Sprintf(" player %d", playerId)
+        }
+        txt += "\n"
+    }
+    for _, placement := range outcome.Placements {
+        txt += fmt.Sprintf("%d. player %d\n", placement.Place, placement.PlayerId)
+    }
+    return txt
+}
+
+func drawEndGame(gtx layout.Context, th *material.Theme, menu *Menu, manager *GameManager) layout.Dimensions{
     var txt string
     switch menu.gameEndResult {
     case protocol.Aborted:
@@ -295,6 +822,8 @@ func drawEndGame(gtx layout.Context, th *material.Theme, menu *Menu) layout.Dime
         txt = "Game won"
     case protocol.Loss:
         txt = "Game lost"
+    case protocol.Watched:
+        txt = "Game over"
     default:
         return layout.Dimensions{}
     }
@@ -307,6 +836,9 @@ func drawEndGame(gtx layout.Context, th *material.Theme, menu *Menu) layout.Dime
         layout.Rigid(func(gtx layout.Context) layout.Dimensions {
             return material.Label(th, unit.Sp(100), txt).Layout(gtx)
         }),
+        layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+            return material.Label(th, unit.Sp(20), outcomeText(menu.gameOutcome)).Layout(gtx)
+        }),
         layout.Rigid(func(gtx layout.Context) layout.Dimensions {
             return layout.Spacer{Height: unit.Dp(16)}.Layout(gtx)
         }),
@@ -319,18 +851,36 @@ func drawEndGame(gtx layout.Context, th *material.Theme, menu *Menu) layout.Dime
         layout.Rigid(func(gtx layout.Context) layout.Dimensions {
             return material.Button(th, &menu.newGameButton, "New game").Layout(gtx)
         }),
+        layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+            // Takes back the move that ended the game
+            if !manager.params.AllowUndo || menu.gameEndResult == protocol.Aborted {
+                return layout.Dimensions{}
+            }
+            return layout.Inset{Top: unit.Dp(16)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+                return material.Button(th, &menu.undoButton, "Undo").Layout(gtx)
+            })
+        }),
     )
 })
 }
-func (manager *GameManager) connectToGameServer(w *app.Window, menu *Menu, host string, port uint16){
+// Connects to the game server to play or, when spectating, only to watch.
+// Spectators stay in the browser until the game starts.
+func (manager *GameManager) connectToGameServer(w *app.Window, menu *Menu, host string, port uint16, spectate bool){
 	fmt.Printf("Connecting to %s:%d\n", host, port)
     go func() {
         menu.connecting = true
+        menu.spectating = spectate
         err := manager.gameController.Connect(host, port)
         if err != nil {
             println(err.Error())
         }else{
-            menu.state = GameStartMenu
+            if spectate {
+                if err := manager.spectate(); err != nil {
+                    println("Failed to request spectating:", err.Error())
+                }
+            } else {
+                menu.state = GameStartMenu
+            }
             go func() {
                 err := manager.gameController.ReadServerResponse()
                 if err != nil {
@@ -346,7 +896,7 @@ func (manager *GameManager) connectToGameServer(w *app.Window, menu *Menu, host
 }
 
 func handleConnectButton(w *app.Window, menu *Menu, manager *GameManager){
-	manager.connectToGameServer(w, menu, menu.ipEditor.Text(), 42069)
+	manager.connectToGameServer(w, menu, menu.ipEditor.Text(), 42069, false)
 }
 
 func handleStartGameButton(menu *Menu, manager *GameManager){
@@ -355,7 +905,66 @@ func handleStartGameButton(menu *Menu, manager *GameManager){
     nMines, errm := strconv.Atoi(menu.minesEditor.Text())
     if errw != nil || errh != nil || errm != nil {
     }else {
-        encoded, err := protocol.EncodeGameStart(mines.GameParams{Width: width, Height: height, Mines: nMines, GameMode: mines.ModeCoop})
+        gameMode := mines.GameModeId(mines.ModeCoop)
+        if mode, err := strconv.Atoi(menu.gameMode.Value); err == nil {
+            gameMode = mines.GameModeId(mode)
+        }
+        topology := mines.TopologySquare
+        if id, err := strconv.Atoi(menu.topology.Value); err == nil {
+            topology = mines.TopologyId(id)
+        }
+        var turnTime time.Duration
+        if seconds, err := strconv.Atoi(menu.turnTimeEditor.Text()); err == nil && seconds > 0 {
+            turnTime = time.Duration(seconds) * time.Second
+        }
+        lives, _ := strconv.Atoi(menu.livesEditor.Text())
+        var timeLimit time.Duration
+        if seconds, err := strconv.Atoi(menu.timeLimitEditor.Text()); err == nil && seconds > 0 {
+            timeLimit = time.Duration(seconds) * time.Second
+        }
+        // A shape overrides the dimensions of the board
+        var mask *mines.Mask
+        if shape := strings.TrimSpace(menu.shapeEditor.Text()); shape != "" {
+            var err error
+            if mask, err = mines.ParseMask(strings.Fields(shape)); err != nil {
+                println("Invalid shape:", err.Error())
+                return
+            }
+            width, height = mask.Width, mask.Height
+        }
+        // A handcrafted board replaces the generated one
+        var boardLayout *mines.Board
+        if path := strings.TrimSpace(menu.boardFileEditor.Text()); path != "" {
+            data, err := os.ReadFile(path)
+            if err != nil {
+                println("Failed to read board file:", err.Error())
+                return
+            }
+            if boardLayout, err = mines.LoadBoard(data); err != nil {
+                println("Invalid board file:", err.Error())
+                return
+            }
+            width, height, nMines, mask = boardLayout.Width, boardLayout.Height, boardLayout.Mines, boardLayout.Mask
+        }
+        params := mines.GameParams{
+            Width: width,
+            Height: height,
+            Mines: nMines,
+            GameMode: gameMode,
+            FirstMoveSafe: menu.firstMoveSafe.Value,
+            NoGuess: menu.noGuess.Value,
+            QuestionMarks: menu.questionMarks.Value,
+            TurnTime: turnTime,
+            Lives: max(lives, 0),
+            LivesPerPlayer: menu.livesPerPlayer.Value,
+            TimeLimit: timeLimit,
+            Topology: topology,
+            Mask: mask,
+            Layout: boardLayout,
+            Infinite: menu.infinite.Value,
+            AllowUndo: menu.allowUndo.Value,
+        }
+        encoded, err := protocol.EncodeGameStart(params)
         if err != nil {
             println(err.Error())
         }else{
@@ -368,21 +977,39 @@ func handleStartGameButton(menu *Menu, manager *GameManager){
 }
 
 func initializeGrid(manager *GameManager) {
+    if manager.params.Infinite {
+        initializeViewport(manager)
+        return
+    }
     boardMutex.Lock()
-	manager.cellColorGrid = make([][]int, manager.params.Width)
+	manager.cellColorGrid = make([][]uint32, manager.params.Width)
     manager.grid = make([][]Cell, manager.params.Width)
     for i := range manager.params.Width {
         manager.grid[i] = make([]Cell, manager.params.Height)
-        manager.cellColorGrid[i] = make([]int, manager.params.Height)
+        manager.cellColorGrid[i] = make([]uint32, manager.params.Height)
         for j := range manager.params.Height {
             manager.grid[i][j].x = i
             manager.grid[i][j].y = j
+            manager.grid[i][j].isDisabled = manager.params.Mask != nil && !manager.params.Mask.Enabled(i, j)
         }
     }
     boardMutex.Unlock()
 
 }
 
+// Centres the viewport on the origin of an infinite board and subscribes to
+// its chunks
+func initializeViewport(manager *GameManager) {
+    boardMutex.Lock()
+    manager.chunks = make(map[mines.ChunkPos]*[mines.ChunkSize * mines.ChunkSize]Cell)
+    manager.chunkColors = make(map[mines.Position]uint32)
+    manager.viewport = image.Point{X: -viewportWidth / 2, Y: -viewportHeight / 2}
+    boardMutex.Unlock()
+    if err := manager.updateSubscriptions(); err != nil {
+        println("Failed to subscribe to chunks:", err.Error())
+    }
+}
+
 func RegisterMMHandlers(w *app.Window, manager *GameManager, menu *Menu, controller *protocol.ConnectionController){
     controller.RegisterHandler(protocol.SendGameServers, func(bytes []byte) error { 
 		infos, err := protocol.DecodeSendGameServers(bytes, nil)
@@ -408,11 +1035,12 @@ func RegisterMMHandlers(w *app.Window, manager *GameManager, menu *Menu, control
 
 func RegisterGUIHandlers(w *app.Window, manager *GameManager, menu *Menu, controller *protocol.ConnectionController){
     controller.RegisterHandler(protocol.GameEnd, func(bytes []byte) error { 
-        endType, err := protocol.DecodeGameEnd(bytes)
+        endType, outcome, err := protocol.DecodeGameEnd(bytes)
         if err != nil {
             return err
         }
         menu.gameEndResult = endType
+        menu.gameOutcome = outcome
         return nil
     })
     controller.RegisterHandler(protocol.GamemodeInfo, func(bytes []byte) error { 
@@ -425,6 +1053,37 @@ func RegisterGUIHandlers(w *app.Window, manager *GameManager, menu *Menu, contro
 		}
         return nil     
     })
+    controller.RegisterHandler(protocol.GameTime, func(bytes []byte) error {
+        elapsed, err := protocol.DecodeGameTime(bytes)
+        if err != nil{
+            return err
+        }
+        manager.elapsed = elapsed
+        w.Invalidate()
+        return nil
+    })
+    controller.RegisterHandler(protocol.HintResponse, func(bytes []byte) error {
+        hint, err := protocol.DecodeHint(bytes)
+        if err != nil{
+            return err
+        }
+        manager.hint = hint
+        w.Invalidate()
+        return nil
+    })
+    controller.RegisterHandler(protocol.MovesUndone, func(bytes []byte) error {
+        moves, err := protocol.DecodeMovesUndone(bytes)
+        if err != nil{
+            return err
+        }
+        println("Undid", moves, "moves")
+        // The game goes on when the undone moves ended it
+        menu.gameEndResult = 0
+        menu.gameOutcome = nil
+        manager.hint = nil
+        w.Invalidate()
+        return nil
+    })
     controller.RegisterHandler(protocol.TextMessage, func(bytes []byte) error { 
         msg, err := protocol.DecodeTextMessage(bytes)
         if err != nil{
@@ -439,9 +1098,16 @@ func RegisterGUIHandlers(w *app.Window, manager *GameManager, menu *Menu, contro
             return err
         }
         manager.params = *params
+        manager.hint = nil
+        manager.versusProgress = nil
+        manager.turnInfo = nil
+        manager.flagsInfo = nil
+        manager.livesInfo = nil
+        manager.elapsed = 0
         initializeGrid(manager)
         menu.state = GameScreen
         menu.gameEndResult = 0
+        menu.gameOutcome = nil
         w.Invalidate()
         return nil     
     })
@@ -451,30 +1117,32 @@ func RegisterGUIHandlers(w *app.Window, manager *GameManager, menu *Menu, contro
             return err
         }
         for _, cell := range updates {
-            c := &manager.grid[cell.X][cell.Y]
-            if (cell.Value & 0xF0) == 0{
-                c.neighborMines = int(cell.Value)
-                c.isRevealed = true
-                continue
-            }
-            switch cell.Value {
-            case mines.Unflag:
-                c.isFlagged = false
-            case mines.ShowFlag:
-                c.isFlagged = true
-            case mines.ShowMine:
-                c.isMine = true
-                c.isRevealed = true
-                
-            }
+            manager.applyCellUpdate(cell)
+        }
+        w.Invalidate()
+        return nil
+    })
+    controller.RegisterHandler(protocol.ChunkCellUpdate, func(bytes []byte) error {
+        _, updates, err := protocol.DecodeChunkCellUpdates(bytes)
+        if err != nil{
+            return err
+        }
+        // Updates of chunks that already left the viewport are dropped by cellAt
+        boardMutex.Lock()
+        for _, cell := range updates {
+            manager.applyCellUpdate(cell)
         }
+        boardMutex.Unlock()
         w.Invalidate()
         return nil
     })
 }
 
 func handleRestartButton(manager *GameManager) {
-    encoded, err := protocol.EncodeGameStart(manager.params)
+    params := manager.params
+    // Let the server pick a new seed so the restarted game has a fresh board
+    params.Seed = 0
+    encoded, err := protocol.EncodeGameStart(params)
     if err != nil {
         println(err.Error())
     }else{
@@ -485,12 +1153,42 @@ func handleRestartButton(manager *GameManager) {
     }
 }
 
+func handleHintButton(manager *GameManager) {
+	encoded, err := protocol.EncodeHintRequest()
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if err = manager.gameController.SendMessage(encoded); err != nil {
+		println(err.Error())
+	}
+}
+
+func handleUndoButton(manager *GameManager) {
+	encoded, err := protocol.EncodeUndoRequest(1)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if err = manager.gameController.SendMessage(encoded); err != nil {
+		println(err.Error())
+	}
+}
+
 func handleNewGameButton(menu *Menu) {
     menu.state = GameStartMenu
 }
 
-func handleBrowserConnectButton(w *app.Window, menu *Menu, manager *GameManager, server *GameServerRow){
-	manager.connectToGameServer(w, menu, server.info.Host, server.info.Port)
+func handleBrowserConnectButton(w *app.Window, menu *Menu, manager *GameManager, server *GameServerRow, spectate bool){
+	manager.connectToGameServer(w, menu, server.info.Host, server.info.Port, spectate)
+}
+
+func (manager *GameManager) spectate() error {
+	encoded, err := protocol.EncodeSpectateRequest()
+	if err != nil {
+		return err
+	}
+	return manager.gameController.SendMessage(encoded)
 }
 
 func (manager *GameManager) refreshServers() error {
@@ -530,10 +1228,36 @@ func handleMenuButtons(gtx layout.Context, w *app.Window, menu *Menu, manager *G
 	if menu.newGameButton.Clicked(gtx){
 		handleNewGameButton(menu)
 	}
+	if menu.hintButton.Clicked(gtx){
+		handleHintButton(manager)
+	}
+	if menu.undoButton.Clicked(gtx){
+		handleUndoButton(manager)
+	}
+	pan := manager.pan
+	if menu.state == ReplayScreen {
+		pan = menu.viewer.pan
+	}
+	if menu.panLeft.Clicked(gtx){
+		pan(-panStep, 0)
+	}
+	if menu.panRight.Clicked(gtx){
+		pan(panStep, 0)
+	}
+	if menu.panUp.Clicked(gtx){
+		pan(0, -panStep)
+	}
+	if menu.panDown.Clicked(gtx){
+		pan(0, panStep)
+	}
+	handleReplayButtons(gtx, w, menu, manager)
 
 	for _, server := range menu.browser.servers {
 		if server.ConnectButton.Clicked(gtx){
-			handleBrowserConnectButton(w, menu, manager, server)
+			handleBrowserConnectButton(w, menu, manager, server, false)
+		}
+		if server.SpectateButton.Clicked(gtx){
+			handleBrowserConnectButton(w, menu, manager, server, true)
 		}
 	}
 
@@ -553,6 +1277,7 @@ func mainLoop(w *app.Window, th *material.Theme, menu *Menu) error {
 		}
 		RegisterMMHandlers(w, manager, menu, manager.matchmakingController)
         RegisterGUIHandlers(w, manager, menu, manager.gameController)
+		RegisterReplayHandlers(w, menu, manager.matchmakingController)
 
 		manager.matchmakingController.AttemptReconnect = true
 		manager.matchmakingController.Connect("localhost", 42071)
@@ -569,8 +1294,10 @@ func mainLoop(w *app.Window, th *material.Theme, menu *Menu) error {
                 case GameStartMenu:
                     drawConfigMenu(gtx, th, menu)
                 case GameScreen:
-                    drawGameScreen(manager, &ops, windowEvent.Source, th, gtx)
-                    drawEndGame(gtx, th, menu)
+                    drawGameScreen(manager, menu, &ops, windowEvent.Source, th, gtx)
+                    drawEndGame(gtx, th, menu, manager)
+                case ReplayScreen:
+                    drawReplayScreen(menu.viewer, menu, &ops, windowEvent.Source, th, gtx)
                 }
                 windowEvent.Frame(gtx.Ops)
             case app.DestroyEvent:
@@ -593,6 +1320,7 @@ func RunClient() {
         menu := &Menu{
             state: ConnectMenu,
 			browser: browser,
+			viewer: &ReplayViewer{manager: &GameManager{readOnly: true}},
         }
         // menu.ipEditor.SetText("127.0.0.1")
         menu.ipEditor.SingleLine = true
@@ -602,6 +1330,13 @@ func RunClient() {
         menu.heightEditor.SingleLine = true
         menu.minesEditor.SetText("9")
         menu.minesEditor.SingleLine = true
+        menu.turnTimeEditor.SingleLine = true
+        menu.livesEditor.SingleLine = true
+        menu.timeLimitEditor.SingleLine = true
+        menu.boardFileEditor.SingleLine = true
+        menu.viewer.matchIdEditor.SingleLine = true
+        menu.gameMode.Value = strconv.Itoa(int(mines.ModeCoop))
+        menu.topology.Value = strconv.Itoa(int(mines.TopologySquare))
 
         err := mainLoop(w, th, menu)
         if err != nil {
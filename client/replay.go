@@ -0,0 +1,374 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gioui.org/app"
+	"gioui.org/io/input"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+
+	"github.com/tomasstrnad1997/mines/mines/replay"
+	"github.com/tomasstrnad1997/mines/protocol"
+)
+
+// ReplayViewer plays back matches stored by the matchmaking server
+type ReplayViewer struct {
+	matchIdEditor widget.Editor
+	watchButton   widget.Clickable
+	playButton    widget.Clickable
+	stepButton    widget.Clickable
+	restartButton widget.Clickable
+	boardButton   widget.Clickable
+	backButton    widget.Clickable
+	seekSlider    widget.Float
+
+	// Draws the replayed board. It has no server and ignores cell presses.
+	manager  *GameManager
+	playback *replay.Playback
+	// Player whose board is shown when every player has their own
+	playerId uint32
+	playing  bool
+	// When the replay would have started to be at its current time when playing
+	playStart time.Time
+}
+
+func drawReplayMenu(gtx layout.Context, th *material.Theme, menu *Menu) layout.Dimensions {
+	return layout.Inset{Top: unit.Dp(8), Left: unit.Dp(16), Right: unit.Dp(16), Bottom: unit.Dp(8)}.Layout(gtx,
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{
+				Alignment: layout.Middle,
+			}.Layout(gtx,
+				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+					return layout.Spacer{Width: unit.Dp(0)}.Layout(gtx)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return material.Editor(th, &menu.viewer.matchIdEditor, "Match id").Layout(gtx)
+				}),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(16)}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return material.Button(th, &menu.viewer.watchButton, "Watch replay").Layout(gtx)
+				}),
+			)
+		})
+}
+
+func drawReplayScreen(viewer *ReplayViewer, menu *Menu, ops *op.Ops, q input.Source, th *material.Theme, gtx layout.Context) layout.Dimensions {
+	if viewer.playback == nil {
+		return layout.Dimensions{}
+	}
+	viewer.advance(gtx)
+	manager := viewer.manager
+	game := viewer.playback.Game()
+	return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{
+			Axis:    layout.Vertical,
+			Spacing: layout.SpaceAround,
+		}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return drawBoard(manager, ops, q, th, gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if !manager.params.Infinite {
+					return layout.Dimensions{}
+				}
+				return drawPanButtons(gtx, th, menu)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Slider(th, &viewer.seekSlider).Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				playText := "Play"
+				if viewer.playing {
+					playText = "Pause"
+				}
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return material.Button(th, &viewer.playButton, playText).Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return material.Button(th, &viewer.stepButton, "Step").Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return material.Button(th, &viewer.restartButton, "Restart").Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						if !game.HasSeparateBoards() {
+							return layout.Dimensions{}
+						}
+						return material.Button(th, &viewer.boardButton, "Next board").Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return material.Button(th, &viewer.backButton, "Back").Layout(gtx)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				txt := fmt.Sprintf("Event %d / %d\n", viewer.playback.Position(), viewer.playback.Len())
+				txt += clockText(viewer.playback.Time(), manager.params.TimeLimit)
+				if game.HasSeparateBoards() {
+					txt += fmt.Sprintf("\nBoard of player %d", viewer.playerId)
+				}
+				return material.Label(th, unit.Sp(16), txt).Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Label(th, unit.Sp(16), gamemodeStatusText(manager)+outcomeText(game.Outcome())).Layout(gtx)
+			}),
+		)
+	})
+}
+
+// Shows a replay from its start
+func (viewer *ReplayViewer) load(playback *replay.Playback) error {
+	viewer.playback = playback
+	viewer.playing = false
+	viewer.playerId = 0
+	if players := playback.Replay().Players(); len(players) > 0 {
+		viewer.playerId = players[0]
+	}
+	return viewer.seek(0)
+}
+
+// Seeks to the given number of events and redraws the board. Gamemodes only
+// report their changes with the events, so the replay is played from the
+// start to collect them.
+func (viewer *ReplayViewer) seek(position int) error {
+	playback := viewer.playback
+	if err := playback.Seek(0); err != nil {
+		return err
+	}
+	manager := viewer.manager
+	manager.params = playback.Game().Params
+	manager.versusProgress = nil
+	manager.turnInfo = nil
+	manager.flagsInfo = nil
+	manager.livesInfo = nil
+	initializeGrid(manager)
+	for playback.Position() < position {
+		if err := viewer.applyStep(); err != nil {
+			return err
+		}
+	}
+	return viewer.refreshCells()
+}
+
+func (viewer *ReplayViewer) step() error {
+	if err := viewer.applyStep(); err != nil {
+		return err
+	}
+	return viewer.refreshCells()
+}
+
+func (viewer *ReplayViewer) applyStep() error {
+	info, err := viewer.playback.Step()
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return nil
+	}
+	return viewer.manager.HandleGamemodeUpdateInfo(info)
+}
+
+// Redraws the cells of the shown board from the replayed game
+func (viewer *ReplayViewer) refreshCells() error {
+	manager := viewer.manager
+	game := viewer.playback.Game()
+	boardMutex.Lock()
+	defer boardMutex.Unlock()
+	if manager.params.Infinite {
+		for chunk, cells := range manager.chunks {
+			for i := range cells {
+				cells[i] = Cell{x: cells[i].x, y: cells[i].y}
+			}
+			updates, err := game.ChunkCellUpdates(viewer.playerId, chunk)
+			if err != nil {
+				return err
+			}
+			for _, cell := range updates {
+				manager.applyCellUpdate(cell)
+			}
+		}
+		return nil
+	}
+	for _, column := range manager.grid {
+		for i := range column {
+			column[i] = Cell{x: column[i].x, y: column[i].y, isDisabled: column[i].isDisabled}
+		}
+	}
+	updates, err := game.GetPlayerChangedCellUpdates(viewer.playerId)
+	if err != nil {
+		return err
+	}
+	for _, cell := range updates {
+		manager.applyCellUpdate(cell)
+	}
+	return nil
+}
+
+func (viewer *ReplayViewer) pan(dx, dy int) {
+	viewer.manager.pan(dx, dy)
+	if err := viewer.refreshCells(); err != nil {
+		println("Failed to show replay:", err.Error())
+	}
+}
+
+// Shows the board of the player that joined after the shown one
+func (viewer *ReplayViewer) nextBoard() {
+	players := viewer.playback.Replay().Players()
+	if len(players) == 0 {
+		return
+	}
+	next := 0
+	for i, playerId := range players {
+		if playerId == viewer.playerId {
+			next = (i + 1) % len(players)
+		}
+	}
+	viewer.playerId = players[next]
+	if err := viewer.refreshCells(); err != nil {
+		println("Failed to show replay:", err.Error())
+	}
+}
+
+func (viewer *ReplayViewer) play() {
+	if viewer.playback.Done() {
+		return
+	}
+	viewer.playing = true
+	viewer.playStart = time.Now().Add(-viewer.playback.Time())
+}
+
+// Applies the events that happened by now when playing and schedules a frame
+// for the next one
+func (viewer *ReplayViewer) advance(gtx layout.Context) {
+	if !viewer.playing {
+		return
+	}
+	playback := viewer.playback
+	events := playback.Replay().Events
+	now := gtx.Now.Sub(viewer.playStart)
+	stepped := false
+	for !playback.Done() && events[playback.Position()].Time <= now {
+		if err := viewer.applyStep(); err != nil {
+			println("Failed to play replay:", err.Error())
+			viewer.playing = false
+			break
+		}
+		stepped = true
+	}
+	if stepped {
+		if err := viewer.refreshCells(); err != nil {
+			println("Failed to show replay:", err.Error())
+		}
+	}
+	if playback.Done() {
+		viewer.playing = false
+	}
+	if viewer.playing {
+		gtx.Execute(op.InvalidateCmd{At: viewer.playStart.Add(events[playback.Position()].Time)})
+	}
+	viewer.updateSlider()
+}
+
+func (viewer *ReplayViewer) updateSlider() {
+	if duration := viewer.playback.Replay().Duration(); duration > 0 {
+		viewer.seekSlider.Value = float32(viewer.playback.Time()) / float32(duration)
+	}
+}
+
+func (manager *GameManager) requestReplay(matchId uint32) error {
+	encoded, err := protocol.EncodeReplayRequest(matchId)
+	if err != nil {
+		return err
+	}
+	return manager.matchmakingController.SendMessage(encoded)
+}
+
+func handleReplayButtons(gtx layout.Context, w *app.Window, menu *Menu, manager *GameManager) {
+	viewer := menu.viewer
+	if viewer.watchButton.Clicked(gtx) {
+		matchId, err := strconv.ParseUint(strings.TrimSpace(viewer.matchIdEditor.Text()), 10, 32)
+		if err != nil {
+			println("Invalid match id:", err.Error())
+		} else if err := manager.requestReplay(uint32(matchId)); err != nil {
+			println("Failed to request replay:", err.Error())
+		}
+	}
+	if viewer.playback == nil {
+		return
+	}
+	var err error
+	if viewer.playButton.Clicked(gtx) {
+		if viewer.playing {
+			viewer.playing = false
+		} else {
+			viewer.play()
+		}
+		w.Invalidate()
+	}
+	if viewer.stepButton.Clicked(gtx) && !viewer.playback.Done() {
+		viewer.playing = false
+		err = viewer.step()
+		viewer.updateSlider()
+	}
+	if viewer.restartButton.Clicked(gtx) {
+		viewer.playing = false
+		err = viewer.seek(0)
+		viewer.updateSlider()
+	}
+	if viewer.seekSlider.Update(gtx) {
+		t := time.Duration(float64(viewer.seekSlider.Value) * float64(viewer.playback.Replay().Duration()))
+		err = viewer.seek(viewer.playback.Replay().EventsBy(t))
+		if viewer.playing {
+			viewer.playStart = gtx.Now.Add(-viewer.playback.Time())
+		}
+	}
+	if viewer.boardButton.Clicked(gtx) {
+		viewer.nextBoard()
+	}
+	if viewer.backButton.Clicked(gtx) {
+		viewer.playing = false
+		menu.state = ConnectMenu
+	}
+	if err != nil {
+		println("Failed to play replay:", err.Error())
+	}
+}
+
+func RegisterReplayHandlers(w *app.Window, menu *Menu, controller *protocol.ConnectionController) {
+	controller.RegisterHandler(protocol.ReplayResponse, func(bytes []byte) error {
+		matchId, data, err := protocol.DecodeReplayResponse(bytes)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			println(fmt.Sprintf("Match %d has no replay", matchId))
+			return nil
+		}
+		decoded, err := replay.Decode(data)
+		if err != nil {
+			return err
+		}
+		playback, err := replay.NewPlayback(decoded)
+		if err != nil {
+			return err
+		}
+		if err := menu.viewer.load(playback); err != nil {
+			return err
+		}
+		menu.state = ReplayScreen
+		w.Invalidate()
+		return nil
+	})
+}
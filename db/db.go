@@ -28,6 +28,40 @@ func InitializeTables(db *sql.DB) error {
 	return err
 }
 
+// Brings tables created by older versions of the schema up to date. Tables
+// that don't exist yet are left to InitializeTables.
+func MigrateTables(db *sql.DB) error {
+	columns, err := tableColumns(db, "matches")
+	if err != nil {
+		return err
+	}
+	// Replays were added after the first matches were stored
+	if len(columns) > 0 && !columns["replay"] {
+		if _, err := db.Exec("ALTER TABLE matches ADD COLUMN replay BLOB"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the names of the columns of the table, none when it doesn't exist
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
 func (store *SQLStore) InitializeTables() error {
 	err := InitializeTables(store.DB)
 	if err != nil {
@@ -49,6 +83,9 @@ func InitStore() (*SQLStore, error) {
 	if err = db.Ping(); err != nil {
 		return nil, err
 	}
+	if err = MigrateTables(db); err != nil {
+		return nil, fmt.Errorf("Failed to migrate tables: %v", err)
+	}
 	ctx := context.Background()
 
 	store := &SQLStore{Q: *store.New(db), ctx: ctx, DB: db}
@@ -72,6 +109,36 @@ func (s *SQLStore) FindPlayerByName(name string) (*players.Player, error) {
 	return plr, nil
 }
 
+// SaveMatch stores the replay of a finished match with the players that played
+// it and returns the id of the match
+func (s *SQLStore) SaveMatch(gamemode mines.GameModeId, players []uint32, replay []byte) (int64, error) {
+	tx, err := s.DB.BeginTx(s.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	q := s.Q.WithTx(tx)
+	match, err := q.CreateMatch(s.ctx, int64(gamemode))
+	if err != nil {
+		return 0, err
+	}
+	if err := q.SetMatchReplay(s.ctx, store.SetMatchReplayParams{Replay: replay, ID: match.ID}); err != nil {
+		return 0, err
+	}
+	for _, playerId := range players {
+		params := store.AddMatchPlayerParams{MatchID: match.ID, PlayerID: int64(playerId)}
+		if err := q.AddMatchPlayer(s.ctx, params); err != nil {
+			return 0, err
+		}
+	}
+	return match.ID, tx.Commit()
+}
+
+// Returns the replay of the match, nil when it has none
+func (s *SQLStore) FindMatchReplay(matchId int64) ([]byte, error) {
+	return s.Q.GetMatchReplay(s.ctx, matchId)
+}
+
 func (s *SQLStore) InsertGamemodes() error {
 	for id, name := range mines.GameModeNames {
 		params := store.InsertGamemodesParams{ID: int64(id), Name: name}
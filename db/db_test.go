@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/tomasstrnad1997/mines/db"
+	"github.com/tomasstrnad1997/mines/mines"
 )
 
 func createTempDB(t *testing.T) (string, error) {
@@ -57,3 +58,81 @@ func TestDBcreation(t *testing.T) {
 		t.Fatalf("Failed to store player in db: %v", err)
 	}
 }
+
+func TestSaveMatch(t *testing.T) {
+	filename, err := createTempDB(t)
+	if err != nil {
+		t.Fatalf("Failed to create temp db: %v", err)
+	}
+	os.Setenv("DB_PATH", filename)
+	store, err := db.InitStore()
+	if err != nil {
+		t.Fatalf("Failed to create Store: %v", err)
+	}
+	defer store.DB.Close()
+	if err := store.InsertGamemodes(); err != nil {
+		t.Fatalf("Failed to insert gamemodes: %v", err)
+	}
+	replay := []byte("replay")
+	matchId, err := store.SaveMatch(mines.ModeCoop, []uint32{1, 2}, replay)
+	if err != nil {
+		t.Fatalf("Failed to save match: %v", err)
+	}
+	stored, err := store.FindMatchReplay(matchId)
+	if err != nil {
+		t.Fatalf("Failed to find replay: %v", err)
+	}
+	if string(stored) != string(replay) {
+		t.Fatalf("Stored replay %q instead of %q", stored, replay)
+	}
+}
+
+func TestMigrateReplayColumn(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	tempFile.Close()
+	t.Cleanup(func() { os.Remove(tempFile.Name()) })
+	database, err := sql.Open("sqlite3", tempFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to open db file: %v", err)
+	}
+	// Matches as they were stored before replays
+	_, err = database.Exec(`CREATE TABLE matches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    gamemode_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE match_players (
+    match_id INTEGER NOT NULL,
+    player_id INTEGER NOT NULL,
+    PRIMARY KEY (match_id, player_id)
+);`)
+	database.Close()
+	if err != nil {
+		t.Fatalf("Failed to create old tables: %v", err)
+	}
+	os.Setenv("DB_PATH", tempFile.Name())
+	store, err := db.InitStore()
+	if err != nil {
+		t.Fatalf("Failed to create Store: %v", err)
+	}
+	defer store.DB.Close()
+	replay := []byte("replay")
+	matchId, err := store.SaveMatch(mines.ModeCoop, []uint32{1}, replay)
+	if err != nil {
+		t.Fatalf("Failed to save match: %v", err)
+	}
+	stored, err := store.FindMatchReplay(matchId)
+	if err != nil {
+		t.Fatalf("Failed to find replay: %v", err)
+	}
+	if string(stored) != string(replay) {
+		t.Fatalf("Stored replay %q instead of %q", stored, replay)
+	}
+	// Migrating again leaves the table alone
+	if err := db.MigrateTables(store.DB); err != nil {
+		t.Fatalf("Failed to migrate migrated tables: %v", err)
+	}
+}
@@ -3,3 +3,16 @@
 INSERT INTO matches (gamemode_id, created_at)
 VALUES (?, date('now'))
 RETURNING id, created_at;
+
+-- name: SetMatchReplay :exec
+UPDATE matches SET replay = ?
+WHERE id = ?;
+
+-- name: GetMatchReplay :one
+SELECT replay
+FROM matches
+WHERE id = ?;
+
+-- name: AddMatchPlayer :exec
+INSERT INTO match_players (match_id, player_id)
+VALUES (?, ?);
@@ -8,7 +8,8 @@ CREATE TABLE players (
 CREATE TABLE matches (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     gamemode_id INTEGER NOT NULL REFERENCES gamemodes(id),
-    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    replay BLOB
 );
 
 
@@ -10,6 +10,21 @@ import (
 	"time"
 )
 
+const addMatchPlayer = `-- name: AddMatchPlayer :exec
+INSERT INTO match_players (match_id, player_id)
+VALUES (?, ?)
+`
+
+type AddMatchPlayerParams struct {
+	MatchID  int64
+	PlayerID int64
+}
+
+func (q *Queries) AddMatchPlayer(ctx context.Context, arg AddMatchPlayerParams) error {
+	_, err := q.db.ExecContext(ctx, addMatchPlayer, arg.MatchID, arg.PlayerID)
+	return err
+}
+
 const createMatch = `-- name: CreateMatch :one
 INSERT INTO matches (gamemode_id, created_at)
 VALUES (?, date('now'))
@@ -27,3 +42,31 @@ func (q *Queries) CreateMatch(ctx context.Context, gamemodeID int64) (CreateMatc
 	err := row.Scan(&i.ID, &i.CreatedAt)
 	return i, err
 }
+
+const getMatchReplay = `-- name: GetMatchReplay :one
+SELECT replay
+FROM matches
+WHERE id = ?
+`
+
+func (q *Queries) GetMatchReplay(ctx context.Context, id int64) ([]byte, error) {
+	row := q.db.QueryRowContext(ctx, getMatchReplay, id)
+	var replay []byte
+	err := row.Scan(&replay)
+	return replay, err
+}
+
+const setMatchReplay = `-- name: SetMatchReplay :exec
+UPDATE matches SET replay = ?
+WHERE id = ?
+`
+
+type SetMatchReplayParams struct {
+	Replay []byte
+	ID     int64
+}
+
+func (q *Queries) SetMatchReplay(ctx context.Context, arg SetMatchReplayParams) error {
+	_, err := q.db.ExecContext(ctx, setMatchReplay, arg.Replay, arg.ID)
+	return err
+}
@@ -19,6 +19,7 @@ type Match struct {
 	ID         int64
 	GamemodeID int64
 	CreatedAt  time.Time
+	Replay     []byte
 }
 
 type MatchPlayer struct {
@@ -3,7 +3,9 @@ package gamelauncher
 import (
 	"fmt"
 	"net"
+	"sync"
 
+	"github.com/tomasstrnad1997/mines/mines/replay"
 	"github.com/tomasstrnad1997/mines/protocol"
 	"github.com/tomasstrnad1997/mines/server"
 )
@@ -18,6 +20,44 @@ type GameLauncher struct {
     listener net.Listener
     GameServers map[int] *server.Server
 	mmServers map[string]*matchmakingServer
+	// Servers waiting for their replays to be saved by request id
+	pendingReplays sync.Map
+	currentRequestId uint32
+	requestIdMux sync.Mutex
+}
+
+func (launcher *GameLauncher) getNextRequestId() uint32 {
+	launcher.requestIdMux.Lock()
+	defer launcher.requestIdMux.Unlock()
+	requestId := launcher.currentRequestId
+	launcher.currentRequestId++
+	return requestId
+}
+
+// Sends the replay of a match played on the server to be stored by one of the
+// matchmaking servers. The others are only tried when sending fails, they all
+// share the database and every copy would be saved as another match.
+func (launcher *GameLauncher) saveReplay(server *server.Server, r *replay.Replay) {
+	data := replay.Encode(r)
+	for _, mmServer := range launcher.mmServers {
+		if !mmServer.controller.Peer().Supports(protocol.FeatureReplays) {
+			continue
+		}
+		requestId := launcher.getNextRequestId()
+		message, err := protocol.EncodeSaveReplay(data, &requestId)
+		if err != nil {
+			println(fmt.Sprintf("Failed to encode replay: %v", err))
+			return
+		}
+		launcher.pendingReplays.Store(requestId, server)
+		if err := mmServer.controller.SendMessage(message); err != nil {
+			launcher.pendingReplays.Delete(requestId)
+			println(fmt.Sprintf("Failed to send replay: %v", err))
+			continue
+		}
+		return
+	}
+	println("Replay was not saved, no matchmaking server is reachable")
 }
 
 func (launcher *GameLauncher) SpawnNewGameServer(name string) (*server.Server, error){
@@ -25,6 +65,9 @@ func (launcher *GameLauncher) SpawnNewGameServer(name string) (*server.Server, e
 	if err != nil {
 		return nil, err
 	}
+	server.OnMatchEnd = func(r *replay.Replay) {
+		launcher.saveReplay(server, r)
+	}
 	launcher.GameServers[launcher.nextServerId] = server
 	launcher.nextServerId++
 	return server, nil
@@ -74,6 +117,19 @@ func (launcher *GameLauncher) RegisterHandlers(mmServer *matchmakingServer){
 		}
 		return nil
     })
+    mmServer.controller.RegisterHandler(protocol.ReplaySaved, func(bytes []byte) error { 
+		var requestId uint32
+		matchId, err := protocol.DecodeReplaySaved(bytes, &requestId)
+		if err != nil {
+			return err
+		}
+		value, ok := launcher.pendingReplays.LoadAndDelete(requestId)
+		if !ok {
+			return fmt.Errorf("Request id is not in pending requests")
+		}
+		value.(*server.Server).ReplaySaved(matchId)
+		return nil
+    })
 }
 
 func (launcher *GameLauncher) Loop(){
@@ -85,6 +141,7 @@ func (launcher *GameLauncher) Loop(){
             return
         }
 		controller := protocol.CreateConnectionController()
+		controller.MinVersion = protocol.OldestServedVersion
 		controller.SetConnection(conn)
 		mmServer := &matchmakingServer{controller: controller}
 		launcher.mmServers[controller.GetServerAddress()] = mmServer
@@ -27,6 +27,13 @@ func TestGameLaunchViaTCP(t *testing.T){
 		t.Fatalf("Cannot connect to game launcher: %v", err)
 	}
 	defer conn.Close()
+	handshake, err := protocol.EncodeHandshake(protocol.HandshakeInfo{Version: protocol.ProtocolVersion, Features: protocol.SupportedFeatures})
+	if err != nil {
+		t.Fatalf("Failed to encode handshake: %v", err)
+	}
+	if _, err = conn.Write(handshake); err != nil {
+		t.Fatalf("Failed to send handshake: %v", err)
+	}
 	for i := range(nServers) {
 		id := uint32(i)
 
@@ -1,12 +1,14 @@
 package matchmaking
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
 
 	"github.com/tomasstrnad1997/mines/db"
+	"github.com/tomasstrnad1997/mines/mines/replay"
 	"github.com/tomasstrnad1997/mines/players"
 	"github.com/tomasstrnad1997/mines/protocol"
 )
@@ -118,6 +120,22 @@ func (server *MatchmakingServer) RegisterPlayerHandlers(player *Player) {
 		}
 		return nil
 	})
+	player.controller.RegisterHandler(protocol.ReplayRequest, func(bytes []byte) error {
+		matchId, err := protocol.DecodeReplayRequest(bytes)
+		if err != nil {
+			return err
+		}
+		// Matches that are not found are answered with an empty replay
+		data, err := server.db.FindMatchReplay(int64(matchId))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		payload, err := protocol.EncodeReplayResponse(matchId, data)
+		if err != nil {
+			return err
+		}
+		return player.controller.SendMessage(payload)
+	})
 }
 
 func (server *MatchmakingServer) RegisterLauncherHandlers(launcher *GameLauncher) {
@@ -165,6 +183,30 @@ func (server *MatchmakingServer) RegisterLauncherHandlers(launcher *GameLauncher
 		player.controller.SendMessage(payload)
 		return nil
 	})
+	launcher.controller.RegisterHandler(protocol.SaveReplay, func(bytes []byte) error {
+		var requestId uint32
+		data, err := protocol.DecodeSaveReplay(bytes, &requestId)
+		if err != nil {
+			return err
+		}
+		decoded, err := replay.Decode(data)
+		if err != nil {
+			return err
+		}
+		params, err := decoded.Params()
+		if err != nil {
+			return err
+		}
+		matchId, err := server.db.SaveMatch(params.GameMode, decoded.Players(), data)
+		if err != nil {
+			return err
+		}
+		payload, err := protocol.EncodeReplaySaved(uint32(matchId), &requestId)
+		if err != nil {
+			return err
+		}
+		return launcher.controller.SendMessage(payload)
+	})
 }
 
 func (server *MatchmakingServer) chooseGameLauncher() (*GameLauncher, error) {
@@ -183,6 +225,7 @@ func (server *MatchmakingServer) Run() {
 			return
 		}
 		controller := protocol.CreateConnectionController()
+		controller.MinVersion = protocol.OldestServedVersion
 		controller.SetConnection(conn)
 		player := &Player{controller: controller}
 		server.RegisterPlayerHandlers(player)
@@ -192,6 +235,7 @@ func (server *MatchmakingServer) Run() {
 
 func (server *MatchmakingServer) ConnectToLauncher(host string, port uint16, reconnect bool) error {
 	controller := protocol.CreateConnectionController()
+	controller.MinVersion = protocol.OldestServedVersion
 	controller.AttemptReconnect = reconnect
 	if err := controller.Connect(host, port); err != nil {
 		return err
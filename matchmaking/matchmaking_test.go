@@ -127,6 +127,26 @@ func waitForResponse(conn net.Conn, t *testing.T) []byte {
 	return message
 }
 
+// Connects to a server and sends the handshake a controller would
+func dialWithHandshake(t *testing.T, address string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Cannot connect to %s: %v", address, err)
+	}
+	encoded, err := protocol.EncodeHandshake(protocol.HandshakeInfo{Version: protocol.ProtocolVersion, Features: protocol.SupportedFeatures})
+	if err != nil {
+		t.Fatalf("Failed to encode handshake: %v", err)
+	}
+	if _, err := conn.Write(encoded); err != nil {
+		t.Fatalf("Failed to send handshake: %v", err)
+	}
+	if _, err := protocol.DecodeHandshake(waitForResponse(conn, t)); err != nil {
+		t.Fatalf("Failed to decode handshake answer: %v", err)
+	}
+	return conn
+}
+
 func TestRegisterPlayer(t *testing.T) {
 	mmPort := uint16(42099)
 	mmOpts := MMserverOptions{port: mmPort, tempDB: true}
@@ -137,10 +157,7 @@ func TestRegisterPlayer(t *testing.T) {
 		t.Fatalf("Failed to encode register player data: %v", err)
 	}
 	// Connect to matchmaking server as a player
-	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", mmPort))
-	if err != nil {
-		t.Fatalf("Cannot connect to game launcher: %v", err)
-	}
+	conn := dialWithHandshake(t, fmt.Sprintf("localhost:%d", mmPort))
 	_, err = conn.Write(encoded)
 	if err != nil {
 		t.Fatalf("Failed to write to server: %v", err)
@@ -163,10 +180,7 @@ func TestGetServerList(t *testing.T) {
 	}
 
 	// Connect to matchmaking server as a player
-	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", mmPort))
-	if err != nil {
-		t.Fatalf("Cannot connect to game launcher: %v", err)
-	}
+	conn := dialWithHandshake(t, fmt.Sprintf("localhost:%d", mmPort))
 	defer conn.Close()
 	// Request a server spawn
 	payload, err := protocol.EncodeGetGameServers(nil)
@@ -202,10 +216,7 @@ func TestGameServerSpawn(t *testing.T) {
 	serverName := "Testing server"
 
 	// Connect to matchmaking server as a player
-	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", mmPort))
-	if err != nil {
-		t.Fatalf("Cannot connect to game launcher: %v", err)
-	}
+	conn := dialWithHandshake(t, fmt.Sprintf("localhost:%d", mmPort))
 	defer conn.Close()
 
 	// Request a server spawn
@@ -0,0 +1,226 @@
+package mines
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Characters of the text board format. Every row of the board is one line.
+const (
+	LayoutHidden   = '#'
+	LayoutMine     = 'O'
+	LayoutRevealed = '.'
+	LayoutDisabled = '-'
+)
+
+// Binary board format:
+// |magic "MB"|version|uint32 width|uint32 height|flags|mines|revealed|disabled|
+// Mines, revealed and disabled cells are bitsets in the order x + y*width. The
+// revealed and disabled bitsets are only present when their flag is set.
+var boardMagic = []byte("MB")
+
+const (
+	boardFormatVersion byte = 1
+	boardHeaderLength       = 12
+
+	boardHasRevealed byte = 0x01
+	boardHasMask     byte = 0x02
+)
+
+// FormatBoard writes the mine layout and revealed cells of the board in the
+// text format read by ParseBoard. Flags and question marks aren't kept.
+func FormatBoard(board *Board) string {
+	var builder strings.Builder
+	for y := range board.Height {
+		for x := range board.Width {
+			index := board.index(x, y)
+			switch {
+			case board.has(index, cellDisabled):
+				builder.WriteByte(LayoutDisabled)
+			case board.has(index, cellMine):
+				builder.WriteByte(LayoutMine)
+			case board.has(index, cellRevealed):
+				builder.WriteByte(LayoutRevealed)
+			default:
+				builder.WriteByte(LayoutHidden)
+			}
+		}
+		builder.WriteByte('\n')
+	}
+	return builder.String()
+}
+
+// ParseBoard reads a board from the text format. Empty lines and lines
+// starting with ';' are ignored.
+func ParseBoard(text string) (*Board, error) {
+	var rows []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		rows = append(rows, line)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("Board has no rows")
+	}
+	width, height := len(rows[0]), len(rows)
+	cells := make([]byte, 0, width*height)
+	for y, row := range rows {
+		if len(row) != width {
+			return nil, fmt.Errorf("Board row %d has length %d instead of %d", y, len(row), width)
+		}
+		for x, char := range []byte(row) {
+			switch char {
+			case LayoutHidden, LayoutMine, LayoutRevealed, LayoutDisabled:
+			default:
+				return nil, fmt.Errorf("Unknown board character %q at (%d, %d)", char, x, y)
+			}
+		}
+		cells = append(cells, row...)
+	}
+	return layoutBoard(width, height, func(x, y int) byte {
+		return cells[x+y*width]
+	})
+}
+
+// EncodeBoard writes the mine layout and revealed cells of the board in the
+// binary format read by DecodeBoard
+func EncodeBoard(board *Board) []byte {
+	size := len(board.cells)
+	mines := make([]bool, size)
+	revealed := make([]bool, size)
+	disabled := make([]bool, size)
+	var flags byte
+	for index := range board.cells {
+		mines[index] = board.has(index, cellMine)
+		revealed[index] = board.revealedSafe(index)
+		disabled[index] = board.has(index, cellDisabled)
+		if revealed[index] {
+			flags |= boardHasRevealed
+		}
+		if disabled[index] {
+			flags |= boardHasMask
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(boardMagic)
+	buf.WriteByte(boardFormatVersion)
+	binary.Write(&buf, binary.BigEndian, uint32(board.Width))
+	binary.Write(&buf, binary.BigEndian, uint32(board.Height))
+	buf.WriteByte(flags)
+	buf.Write(packBits(mines))
+	if flags&boardHasRevealed != 0 {
+		buf.Write(packBits(revealed))
+	}
+	if flags&boardHasMask != 0 {
+		buf.Write(packBits(disabled))
+	}
+	return buf.Bytes()
+}
+
+func DecodeBoard(data []byte) (*Board, error) {
+	if len(data) < boardHeaderLength || !bytes.HasPrefix(data, boardMagic) {
+		return nil, fmt.Errorf("Data is not a binary board")
+	}
+	if data[2] != boardFormatVersion {
+		return nil, fmt.Errorf("Unsupported board format version %d", data[2])
+	}
+	width := uint64(binary.BigEndian.Uint32(data[3:7]))
+	height := uint64(binary.BigEndian.Uint32(data[7:11]))
+	flags := data[11]
+	sets := uint64(1)
+	if flags&boardHasRevealed != 0 {
+		sets++
+	}
+	if flags&boardHasMask != 0 {
+		sets++
+	}
+	bitsets := (width*height + 7) / 8
+	if width == 0 || height == 0 || uint64(len(data)-boardHeaderLength) != sets*bitsets {
+		return nil, fmt.Errorf("Binary board of size (%d, %d) has %d bytes", width, height, len(data))
+	}
+	mines := data[boardHeaderLength : boardHeaderLength+bitsets]
+	next := boardHeaderLength + bitsets
+	var revealed, disabled []byte
+	if flags&boardHasRevealed != 0 {
+		revealed = data[next : next+bitsets]
+		next += bitsets
+	}
+	if flags&boardHasMask != 0 {
+		disabled = data[next : next+bitsets]
+	}
+	return layoutBoard(int(width), int(height), func(x, y int) byte {
+		index := x + y*int(width)
+		switch {
+		case disabled != nil && bitSet(disabled, index):
+			if bitSet(mines, index) || (revealed != nil && bitSet(revealed, index)) {
+				// Reported as an invalid character by layoutBoard
+				return 0
+			}
+			return LayoutDisabled
+		case bitSet(mines, index):
+			if revealed != nil && bitSet(revealed, index) {
+				return 0
+			}
+			return LayoutMine
+		case revealed != nil && bitSet(revealed, index):
+			return LayoutRevealed
+		default:
+			return LayoutHidden
+		}
+	})
+}
+
+// LoadBoard reads a board in either the binary or the text format
+func LoadBoard(data []byte) (*Board, error) {
+	if bytes.HasPrefix(data, boardMagic) {
+		return DecodeBoard(data)
+	}
+	return ParseBoard(string(data))
+}
+
+// Builds a board from the layout character of every cell
+func layoutBoard(width, height int, layout func(x, y int) byte) (*Board, error) {
+	var mask *Mask
+	for x := range width {
+		for y := range height {
+			if layout(x, y) != LayoutDisabled {
+				continue
+			}
+			if mask == nil {
+				var err error
+				if mask, err = NewMask(width, height); err != nil {
+					return nil, err
+				}
+			}
+			mask.SetEnabled(x, y, false)
+		}
+	}
+	board, err := createBoard(width, height, 0, 0, mask)
+	if err != nil {
+		return nil, err
+	}
+	for x := range width {
+		for y := range height {
+			index := board.index(x, y)
+			switch layout(x, y) {
+			case LayoutMine:
+				board.setMine(index, true)
+				board.Mines++
+			case LayoutRevealed:
+				board.reveal(index)
+				board.RevealedCells++
+			case LayoutHidden, LayoutDisabled:
+			default:
+				return nil, fmt.Errorf("Invalid cell at (%d, %d)", x, y)
+			}
+		}
+	}
+	if board.RevealedCells+board.Mines == board.PlayableCells() {
+		return nil, fmt.Errorf("Board has no cells left to reveal")
+	}
+	return board, nil
+}
@@ -0,0 +1,229 @@
+package mines
+
+// Flags of a cell stored in Board.cells
+const (
+	cellMine byte = 1 << iota
+	cellRevealed
+	cellFlagged
+	cellQuestioned
+	cellDisabled
+	// The cell is in Board.changed
+	cellTracked
+)
+
+func (board *Board) index(x, y int) int {
+	return x + y*board.Width
+}
+
+func (board *Board) has(index int, flags byte) bool {
+	return board.cells[index]&flags != 0
+}
+
+// Cell returns a copy of the cell at (x, y). Changes to the copy don't change
+// the board, SetCell and SetMine do.
+func (board *Board) Cell(x, y int) *Cell {
+	if board.Infinite() {
+		snapshot := board.chunkCell(x, y)
+		return &snapshot
+	}
+	snapshot := board.cellAt(board.index(x, y))
+	return &snapshot
+}
+
+func (board *Board) cellAt(index int) Cell {
+	flags := board.cells[index]
+	return Cell{
+		Mine:       flags&cellMine != 0,
+		Revealed:   flags&cellRevealed != 0,
+		Flagged:    flags&cellFlagged != 0,
+		X:          index % board.Width,
+		Y:          index / board.Width,
+		Questioned: flags&cellQuestioned != 0,
+		Disabled:   flags&cellDisabled != 0,
+	}
+}
+
+// Copies of the cells at the indices sharing one allocation
+func (board *Board) snapshot(indices []int) []*Cell {
+	snapshots := make([]Cell, len(indices))
+	cells := make([]*Cell, len(indices))
+	for i, index := range indices {
+		snapshots[i] = board.cellAt(index)
+		cells[i] = &snapshots[i]
+	}
+	return cells
+}
+
+// SetCell overwrites the cell at the position of the given one. Mines and
+// RevealedCells of the board are kept up to date. The shape of the board can't
+// be changed so disabled cells are left alone.
+func (board *Board) SetCell(cell *Cell) {
+	index := board.index(cell.X, cell.Y)
+	if board.has(index, cellDisabled) {
+		return
+	}
+	board.SetMine(cell.X, cell.Y, cell.Mine)
+	if board.revealedSafe(index) {
+		board.RevealedCells--
+	}
+	flags := board.cells[index] & (cellMine | cellTracked)
+	if cell.Revealed {
+		flags |= cellRevealed
+	}
+	if cell.Flagged {
+		flags |= cellFlagged
+	}
+	if cell.Questioned {
+		flags |= cellQuestioned
+	}
+	board.cells[index] = flags
+	if board.revealedSafe(index) {
+		board.RevealedCells++
+	}
+	if flags&(cellRevealed|cellFlagged|cellQuestioned) != 0 {
+		board.track(index)
+	}
+}
+
+// SetMine places or removes a mine keeping Mines and RevealedCells up to date
+func (board *Board) SetMine(x, y int, mine bool) {
+	index := board.index(x, y)
+	if board.has(index, cellDisabled) || board.has(index, cellMine) == mine {
+		return
+	}
+	wasRevealed := board.revealedSafe(index)
+	board.setMine(index, mine)
+	if mine {
+		board.Mines++
+	} else {
+		board.Mines--
+	}
+	if board.revealedSafe(index) != wasRevealed {
+		if wasRevealed {
+			board.RevealedCells--
+		} else {
+			board.RevealedCells++
+		}
+	}
+}
+
+func (board *Board) revealedSafe(index int) bool {
+	return board.cells[index]&(cellRevealed|cellMine) == cellRevealed
+}
+
+// Changes the mine of a cell without touching the mine count of the board
+func (board *Board) setMine(index int, mine bool) {
+	if board.has(index, cellMine) == mine {
+		return
+	}
+	board.record(index)
+	board.cells[index] ^= cellMine
+	if board.counts == nil || board.countsTopology != board.topology() {
+		board.counts = nil
+		return
+	}
+	for _, n := range board.neighbours(index, nil) {
+		if mine {
+			board.counts[n]++
+		} else {
+			board.counts[n]--
+		}
+	}
+}
+
+func (board *Board) reveal(index int) {
+	board.record(index)
+	board.cells[index] = board.cells[index]&^cellQuestioned | cellRevealed
+	board.track(index)
+}
+
+// Adds the cell to the changed cells unless it is there already. Cells stay
+// tracked when their flag gets removed, GetChangedCellUpdates skips them.
+func (board *Board) track(index int) {
+	if board.cells[index]&cellTracked == 0 {
+		board.cells[index] |= cellTracked
+		board.changed = append(board.changed, index)
+	}
+}
+
+// Mines among the neighbours of every cell. Counted on first use and again
+// whenever the topology of the board gets replaced.
+func (board *Board) mineCounts() []byte {
+	topology := board.topology()
+	if board.counts != nil && board.countsTopology == topology {
+		return board.counts
+	}
+	board.counts = make([]byte, len(board.cells))
+	board.countsTopology = topology
+	neighbours := make([]int, 0, 8)
+	for index, flags := range board.cells {
+		if flags&cellMine == 0 {
+			continue
+		}
+		neighbours = board.neighbours(index, neighbours)
+		for _, n := range neighbours {
+			board.counts[n]++
+		}
+	}
+	return board.counts
+}
+
+// Number shown by the cell which includes the cell itself
+func (board *Board) number(index int) int {
+	number := int(board.mineCounts()[index])
+	if board.has(index, cellMine) {
+		number++
+	}
+	return number
+}
+
+// Appends the enabled neighbours of the cell to buf[:0]. Square boards skip the
+// topology so huge boards don't allocate a slice per cell.
+func (board *Board) neighbours(index int, buf []int) []int {
+	buf = buf[:0]
+	x, y := index%board.Width, index/board.Width
+	topology := board.topology()
+	if _, square := topology.(squareTopology); square {
+		for ny := max(y-1, 0); ny <= min(y+1, board.Height-1); ny++ {
+			for nx := max(x-1, 0); nx <= min(x+1, board.Width-1); nx++ {
+				n := board.index(nx, ny)
+				if n != index && !board.has(n, cellDisabled) {
+					buf = append(buf, n)
+				}
+			}
+		}
+		return buf
+	}
+	for _, position := range topology.Neighbours(x, y, board.Width, board.Height) {
+		n := board.index(position.X, position.Y)
+		if !board.has(n, cellDisabled) {
+			buf = append(buf, n)
+		}
+	}
+	return buf
+}
+
+// Reveals the cell and floods the area of empty cells around it without
+// recursion. Returns revealed extended by the indices of the revealed cells.
+func (board *Board) cascade(index int, revealed []int) []int {
+	counts := board.mineCounts()
+	board.reveal(index)
+	stack := []int{index}
+	neighbours := make([]int, 0, 8)
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		revealed = append(revealed, current)
+		if counts[current] != 0 {
+			continue
+		}
+		neighbours = board.neighbours(current, neighbours)
+		for _, n := range neighbours {
+			if !board.has(n, cellRevealed|cellFlagged) {
+				board.reveal(n)
+				stack = append(stack, n)
+			}
+		}
+	}
+	return revealed
+}
@@ -0,0 +1,305 @@
+package mines
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+// Infinite boards are split into square chunks of ChunkSize cells. The mines of
+// a chunk are placed when a move first needs it, from the seed of the board and
+// the position of the chunk, so every chunk is the same no matter the order in
+// which the chunks get explored.
+const ChunkSize = 16
+
+// Fewest mines in a chunk of an infinite board. Sparser boards open areas of
+// empty cells too big to reveal in one move.
+const MinChunkMines = ChunkSize * ChunkSize / 8
+
+// Most mines in a chunk of an infinite board leaving room for a safe first move
+const MaxChunkMines = ChunkSize*ChunkSize - 9
+
+type ChunkPos struct {
+	X int
+	Y int
+}
+
+// ChunkOf returns the chunk holding the cell at (x, y)
+func ChunkOf(x, y int) ChunkPos {
+	return ChunkPos{floorDiv(x, ChunkSize), floorDiv(y, ChunkSize)}
+}
+
+// Position of the top left cell of the chunk
+func (chunk ChunkPos) Origin() Position {
+	return Position{chunk.X * ChunkSize, chunk.Y * ChunkSize}
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+// Cells of an infinite board. Chunks hold the same cell flags as Board.cells
+// indexed x + y*ChunkSize relative to the origin of the chunk.
+type chunkedBoard struct {
+	minesPerChunk int
+	seed          int64
+	chunks        map[ChunkPos][]byte
+}
+
+// CreateInfiniteBoard creates a board without borders with the given number of
+// mines in every chunk
+func CreateInfiniteBoard(minesPerChunk int, seed int64) (*Board, error) {
+	if minesPerChunk < MinChunkMines || minesPerChunk > MaxChunkMines {
+		return nil, fmt.Errorf("Infinite boards need %d to %d mines per chunk, got %d", MinChunkMines, MaxChunkMines, minesPerChunk)
+	}
+	chunks := &chunkedBoard{minesPerChunk: minesPerChunk, seed: seed, chunks: make(map[ChunkPos][]byte)}
+	return &Board{seed: seed, chunks: chunks}, nil
+}
+
+// Reports whether the board has no borders and is stored in chunks
+func (board *Board) Infinite() bool {
+	return board.chunks != nil
+}
+
+// Cells of infinite boards are limited to 32 bit coordinates, keeping a border
+// so their neighbours fit as well
+func validChunkedPosition(x, y int) bool {
+	return x > math.MinInt32 && x < math.MaxInt32 && y > math.MinInt32 && y < math.MaxInt32
+}
+
+// Returns the cells of the chunk placing its mines first if needed
+func (board *Board) chunk(chunk ChunkPos) []byte {
+	cells, ok := board.chunks.chunks[chunk]
+	if ok {
+		return cells
+	}
+	cells = make([]byte, ChunkSize*ChunkSize)
+	placeMines(cells, board.chunks.minesPerChunk, len(cells), rand.New(rand.NewSource(chunkSeed(board.chunks.seed, chunk))))
+	board.chunks.chunks[chunk] = cells
+	board.Mines += board.chunks.minesPerChunk
+	return cells
+}
+
+// Mixes the position of the chunk into the seed of the board (splitmix64) so
+// neighbouring chunks get unrelated layouts
+func chunkSeed(seed int64, chunk ChunkPos) int64 {
+	z := uint64(seed) + uint64(int64(chunk.X))*0x9e3779b97f4a7c15 + uint64(int64(chunk.Y))*0xc2b2ae3d27d4eb4f
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+// Flags of the cell at (x, y) in its chunk
+func (board *Board) chunkFlags(x, y int) *byte {
+	chunk := ChunkOf(x, y)
+	origin := chunk.Origin()
+	return &board.chunk(chunk)[(x-origin.X)+(y-origin.Y)*ChunkSize]
+}
+
+func (board *Board) chunkCell(x, y int) Cell {
+	flags := *board.chunkFlags(x, y)
+	return Cell{
+		Mine:       flags&cellMine != 0,
+		Revealed:   flags&cellRevealed != 0,
+		Flagged:    flags&cellFlagged != 0,
+		X:          x,
+		Y:          y,
+		Questioned: flags&cellQuestioned != 0,
+	}
+}
+
+func (board *Board) chunkSnapshot(positions []Position) []*Cell {
+	snapshots := make([]Cell, len(positions))
+	cells := make([]*Cell, len(positions))
+	for i, position := range positions {
+		snapshots[i] = board.chunkCell(position.X, position.Y)
+		cells[i] = &snapshots[i]
+	}
+	return cells
+}
+
+func chunkNeighbours(x, y int) []Position {
+	positions := make([]Position, 0, 8)
+	for ny := y - 1; ny <= y+1; ny++ {
+		for nx := x - 1; nx <= x+1; nx++ {
+			if nx != x || ny != y {
+				positions = append(positions, Position{nx, ny})
+			}
+		}
+	}
+	return positions
+}
+
+// Number shown by the cell which includes the cell itself
+func (board *Board) chunkNumber(x, y int) int {
+	number := 0
+	for ny := y - 1; ny <= y+1; ny++ {
+		for nx := x - 1; nx <= x+1; nx++ {
+			if *board.chunkFlags(nx, ny)&cellMine != 0 {
+				number++
+			}
+		}
+	}
+	return number
+}
+
+func (board *Board) revealChunkCell(x, y int) {
+	flags := board.chunkFlags(x, y)
+	*flags = *flags&^cellQuestioned | cellRevealed
+	if *flags&cellMine == 0 {
+		board.RevealedCells++
+	}
+}
+
+// Removes the mines from the cell and its neighbours. Chunks are unbounded so
+// the mines are dropped instead of moved elsewhere.
+func (board *Board) clearChunkSafeZone(x, y int) {
+	for ny := y - 1; ny <= y+1; ny++ {
+		for nx := x - 1; nx <= x+1; nx++ {
+			if flags := board.chunkFlags(nx, ny); *flags&cellMine != 0 {
+				*flags &^= cellMine
+				board.Mines--
+			}
+		}
+	}
+}
+
+// Same as cascade for infinite boards
+func (board *Board) chunkCascade(x, y int, revealed []Position) []Position {
+	board.revealChunkCell(x, y)
+	stack := []Position{{x, y}}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		revealed = append(revealed, current)
+		if board.chunkNumber(current.X, current.Y) != 0 {
+			continue
+		}
+		for _, n := range chunkNeighbours(current.X, current.Y) {
+			if *board.chunkFlags(n.X, n.Y)&(cellRevealed|cellFlagged) == 0 {
+				board.revealChunkCell(n.X, n.Y)
+				stack = append(stack, n)
+			}
+		}
+	}
+	return revealed
+}
+
+// Infinite boards are never cleared so revealing safe cells never wins
+func (board *Board) revealChunked(x, y int) (*MoveResult, error) {
+	flags := board.chunkFlags(x, y)
+	if *flags&(cellRevealed|cellFlagged) != 0 {
+		return &MoveResult{NoChange, nil}, nil
+	}
+	if board.safeFirstMove {
+		board.clearChunkSafeZone(x, y)
+		board.safeFirstMove = false
+	}
+	if *flags&cellMine != 0 {
+		board.revealChunkCell(x, y)
+		return &MoveResult{board.mineResult(), board.chunkSnapshot([]Position{{x, y}})}, nil
+	}
+	revealed := board.chunkCascade(x, y, nil)
+	return &MoveResult{CellRevealed, board.chunkSnapshot(revealed)}, nil
+}
+
+func (board *Board) flagChunked(x, y int) (*MoveResult, error) {
+	flags := board.chunkFlags(x, y)
+	if *flags&cellRevealed != 0 {
+		return &MoveResult{NoChange, nil}, nil
+	}
+	board.toggleMark(flags)
+	return &MoveResult{Flagged, board.chunkSnapshot([]Position{{x, y}})}, nil
+}
+
+func (board *Board) chordChunked(x, y int) (*MoveResult, error) {
+	if *board.chunkFlags(x, y)&(cellRevealed|cellMine) != cellRevealed {
+		return &MoveResult{NoChange, nil}, nil
+	}
+	neighbours := chunkNeighbours(x, y)
+	flagged := 0
+	for _, n := range neighbours {
+		if *board.chunkFlags(n.X, n.Y)&cellFlagged != 0 {
+			flagged++
+		}
+	}
+	if flagged != board.chunkNumber(x, y) {
+		return &MoveResult{NoChange, nil}, nil
+	}
+	var revealed []Position
+	mineBlown := false
+	for _, n := range neighbours {
+		flags := *board.chunkFlags(n.X, n.Y)
+		if flags&(cellRevealed|cellFlagged) != 0 {
+			continue
+		}
+		if flags&cellMine != 0 {
+			board.revealChunkCell(n.X, n.Y)
+			revealed = append(revealed, n)
+			mineBlown = true
+			continue
+		}
+		revealed = board.chunkCascade(n.X, n.Y, revealed)
+	}
+	if len(revealed) == 0 {
+		return &MoveResult{NoChange, nil}, nil
+	}
+	if mineBlown {
+		return &MoveResult{board.mineResult(), board.chunkSnapshot(revealed)}, nil
+	}
+	return &MoveResult{CellRevealed, board.chunkSnapshot(revealed)}, nil
+}
+
+// Positions of the revealed and marked cells of the chunk. Chunks nobody has
+// played on yet have none and don't get generated.
+func (board *Board) changedChunkCells(chunk ChunkPos) []Position {
+	cells, ok := board.chunks.chunks[chunk]
+	if !ok {
+		return nil
+	}
+	origin := chunk.Origin()
+	var changed []Position
+	for index, flags := range cells {
+		if flags&(cellRevealed|cellFlagged|cellQuestioned) != 0 {
+			changed = append(changed, Position{origin.X + index%ChunkSize, origin.Y + index/ChunkSize})
+		}
+	}
+	return changed
+}
+
+// ChunkCellUpdates returns the updates of every revealed or marked cell of the
+// chunk
+func (board *Board) ChunkCellUpdates(chunk ChunkPos) ([]UpdatedCell, error) {
+	if !board.Infinite() {
+		return nil, fmt.Errorf("Board is not infinite")
+	}
+	return board.CreateCellUpdates(board.chunkSnapshot(board.changedChunkCells(chunk)))
+}
+
+// Same as Board.ChunkCellUpdates for the board the player plays on
+func (game *Game) ChunkCellUpdates(playerId uint32, chunk ChunkPos) ([]UpdatedCell, error) {
+	return game.playerBoard(playerId).ChunkCellUpdates(chunk)
+}
+
+// GroupByChunk splits the updates by the chunk holding their cell
+func GroupByChunk(updates []UpdatedCell) map[ChunkPos][]UpdatedCell {
+	chunks := make(map[ChunkPos][]UpdatedCell)
+	for _, update := range updates {
+		chunk := ChunkOf(update.X, update.Y)
+		chunks[chunk] = append(chunks[chunk], update)
+	}
+	return chunks
+}
+
+func (chunks *chunkedBoard) clone() *chunkedBoard {
+	clone := *chunks
+	clone.chunks = make(map[ChunkPos][]byte, len(chunks.chunks))
+	for chunk, cells := range chunks.chunks {
+		clone.chunks[chunk] = slices.Clone(cells)
+	}
+	return &clone
+}
@@ -2,7 +2,7 @@ package mines
 
 type Classic struct{}
 
-func (c *Classic) Init(board *Board) {}
+func (c *Classic) Init(board *Board, params GameParams) {}
 
 func (c *Classic) Name() string {
 	return "Classic"
@@ -16,3 +16,21 @@ func (c *Classic) OnMove(b *Board, move Move, result *MoveResult) (GamemodeUpdat
 	return nil, nil
 }
 
+// Classic games have no state besides the board
+func (c *Classic) UndoMove() GamemodeUpdateInfo {
+	return nil
+}
+
+// Classic games have no state besides the board
+func (c *Classic) Snapshot() []byte {
+	return nil
+}
+
+func (c *Classic) Restore(data []byte) error {
+	return newSnapshotReader(data).Close()
+}
+
+func (c *Classic) GameOver(b *Board, move Move, result *MoveResult) *GameOutcome {
+	return mineOrWinOutcome(move, result)
+}
+
@@ -1,10 +1,30 @@
 package mines
 
-
+import (
+	"maps"
+	"slices"
+)
 
 type Coop struct{
-	boardPlayerMarks [][]uint32
+	// Player who marked the cell. Kept by position so infinite boards work too.
+	boardPlayerMarks map[Position]uint32
 	playerScores map[uint32] int
+	// State before every move, only kept in games that allow undo
+	allowUndo bool
+	history []coopUndo
+	// Taken from the score of a player for every mine they reveal. Only
+	// infinite boards survive a revealed mine, elsewhere it ends the game.
+	minePenalty int
+}
+
+// Points a revealed mine costs on an infinite board, where guessing would
+// otherwise be free
+const CoopMinePenalty = 10
+
+type coopUndo struct {
+	scores map[uint32]int
+	// Marks of the cells changed by the move as they were before it
+	marks []PlayerMarkChange
 }
 
 type PlayerMarkChange struct {
@@ -22,13 +42,17 @@ func (c *CoopInfoUpdate) GetGameModeId() GameModeId{
 	return ModeCoop
 }
 
-func (c *Coop) Init(board *Board) {
-    grid := make([][]uint32, board.Width)
-    for i := range grid {
-        grid[i] = make([]uint32, board.Height)
-    }
-	c.boardPlayerMarks = grid
+func (c *Coop) Init(board *Board, params GameParams) {
+	c.boardPlayerMarks = make(map[Position]uint32)
 	c.playerScores = make(map[uint32]int)
+	c.allowUndo = params.AllowUndo
+	c.history = nil
+	c.minePenalty = 0
+	// Infinite boards can't be cleared, the team plays on and scores keep adding up
+	if board.Infinite() {
+		board.AllowMineReveals()
+		c.minePenalty = CoopMinePenalty
+	}
 
 }
 
@@ -44,20 +68,88 @@ func (c *Coop) OnMove(b *Board, move Move, result *MoveResult) (GamemodeUpdateIn
 	if result.Result == NoChange {
 		return nil, nil
 	}
+	if c.allowUndo {
+		undo := coopUndo{scores: maps.Clone(c.playerScores)}
+		for _, res := range result.UpdatedCells {
+			undo.marks = append(undo.marks, PlayerMarkChange{res.X, res.Y, c.boardPlayerMarks[Position{res.X, res.Y}]})
+		}
+		c.history = append(c.history, undo)
+	}
 	var updates []PlayerMarkChange
 	for _, res := range result.UpdatedCells {
 		if res.Flagged || res.Revealed {
-			c.boardPlayerMarks[res.X][res.Y] = move.PlayerId
-			c.playerScores[move.PlayerId]++
+			c.boardPlayerMarks[Position{res.X, res.Y}] = move.PlayerId
+			// Mines blown by a reveal or a chord are marked and cost the penalty
+			if res.Revealed && res.Mine {
+				c.playerScores[move.PlayerId] -= c.minePenalty
+			} else {
+				c.playerScores[move.PlayerId]++
+			}
 			updates = append(updates, PlayerMarkChange{res.X, res.Y, move.PlayerId})
 		}
-		if !res.Flagged && !res.Revealed { // Unflag
-			c.boardPlayerMarks[res.X][res.Y] = 0
+		// Unflag. Question marks carry no score so only the removal of a flag counts.
+		if !res.Flagged && !res.Revealed && c.boardPlayerMarks[Position{res.X, res.Y}] != 0 {
+			delete(c.boardPlayerMarks, Position{res.X, res.Y})
 			c.playerScores[move.PlayerId]--
 			updates = append(updates, PlayerMarkChange{res.X, res.Y, 0})
 		}
 	}
-	info := &CoopInfoUpdate{MarksChange: updates, PlayerScores: c.playerScores}
+	info := &CoopInfoUpdate{MarksChange: updates, PlayerScores: maps.Clone(c.playerScores)}
 	return info, nil
 }
 
+func (c *Coop) UndoMove() GamemodeUpdateInfo {
+	if len(c.history) == 0 {
+		return nil
+	}
+	undo := c.history[len(c.history)-1]
+	c.history = c.history[:len(c.history)-1]
+	for _, mark := range undo.marks {
+		if mark.PlayerId == 0 {
+			delete(c.boardPlayerMarks, Position{mark.X, mark.Y})
+		} else {
+			c.boardPlayerMarks[Position{mark.X, mark.Y}] = mark.PlayerId
+		}
+	}
+	c.playerScores = undo.scores
+	return &CoopInfoUpdate{MarksChange: undo.marks, PlayerScores: maps.Clone(c.playerScores)}
+}
+
+// Undo history isn't kept, restored games start without moves to undo
+func (c *Coop) Snapshot() []byte {
+	w := &snapshotWriter{}
+	marks := slices.SortedFunc(maps.Keys(c.boardPlayerMarks), func(a, b Position) int {
+		if a.Y != b.Y {
+			return a.Y - b.Y
+		}
+		return a.X - b.X
+	})
+	w.int(len(marks))
+	for _, position := range marks {
+		w.int(position.X)
+		w.int(position.Y)
+		w.uint32(c.boardPlayerMarks[position])
+	}
+	w.scores(c.playerScores)
+	return w.data
+}
+
+func (c *Coop) Restore(data []byte) error {
+	r := newSnapshotReader(data)
+	count := r.Count(3)
+	for range count {
+		position := Position{r.Int(), r.Int()}
+		c.boardPlayerMarks[position] = r.Uint32()
+	}
+	c.playerScores = r.scores()
+	return r.Close()
+}
+
+// The team wins or loses together, the players are placed by their score
+func (c *Coop) GameOver(b *Board, move Move, result *MoveResult) *GameOutcome {
+	outcome := mineOrWinOutcome(move, result)
+	if outcome != nil {
+		outcome.Placements = placementsByScore(c.playerScores)
+	}
+	return outcome
+}
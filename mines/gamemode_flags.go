@@ -0,0 +1,147 @@
+package mines
+
+import "maps"
+
+// Players take turns revealing cells and try to find the mines instead of
+// avoiding them. Revealing a mine claims it and grants another turn. The first
+// player to claim more than half of the mines wins.
+type Flags struct {
+	turns        turnOrder
+	claimedMines map[uint32]int
+	mines        int
+	winner       uint32
+}
+
+type FlagsInfoUpdate struct {
+	CurrentPlayer uint32
+	ClaimedMines  map[uint32]int
+	// Set once a player claimed more than half of the mines
+	Winner uint32
+	// Mines claimed by the move
+	MarksChange []PlayerMarkChange
+}
+
+func (f *FlagsInfoUpdate) GetGameModeId() GameModeId {
+	return ModeFlags
+}
+
+func (f *Flags) Init(board *Board, params GameParams) {
+	board.AllowMineReveals()
+	f.turns = turnOrder{}
+	f.claimedMines = make(map[uint32]int)
+	f.mines = board.Mines
+	f.winner = 0
+}
+
+func (f *Flags) Name() string {
+	return "Flags"
+}
+
+func (f *Flags) GameModeId() GameModeId {
+	return ModeFlags
+}
+
+func (f *Flags) AddPlayer(playerId uint32) {
+	// Players coming back keep their mines
+	if _, ok := f.claimedMines[playerId]; f.turns.add(playerId) && !ok {
+		f.claimedMines[playerId] = 0
+	}
+}
+
+// The player keeps the claimed mines but gets no more turns
+func (f *Flags) RemovePlayer(playerId uint32) GamemodeUpdateInfo {
+	if !f.turns.remove(playerId) {
+		return nil
+	}
+	return &FlagsInfoUpdate{CurrentPlayer: f.turns.current(), ClaimedMines: maps.Clone(f.claimedMines), Winner: f.winner}
+}
+
+func (f *Flags) CurrentPlayer() uint32 {
+	return f.turns.current()
+}
+
+// Only reveals are allowed, the mines are found by revealing them
+func (f *Flags) ValidateMove(move Move) error {
+	if move.Type != Reveal {
+		return ErrMoveNotAllowed
+	}
+	return f.turns.validate(move)
+}
+
+func (f *Flags) OnMove(b *Board, move Move, result *MoveResult) (GamemodeUpdateInfo, error) {
+	if result.Result == NoChange {
+		return nil, nil
+	}
+	info := &FlagsInfoUpdate{}
+	switch result.Result {
+	case MineRevealed:
+		for _, cell := range result.UpdatedCells {
+			if cell.Mine {
+				f.claimedMines[move.PlayerId]++
+				info.MarksChange = append(info.MarksChange, PlayerMarkChange{cell.X, cell.Y, move.PlayerId})
+			}
+		}
+		// Claiming the majority ends the game
+		if f.claimedMines[move.PlayerId]*2 > f.mines {
+			f.winner = move.PlayerId
+			result.Result = GameWon
+		}
+	case GameWon:
+		// Every safe cell got revealed before anyone claimed the majority
+		f.winner = f.leader()
+	default:
+		f.turns.next()
+	}
+	info.ClaimedMines = maps.Clone(f.claimedMines)
+	info.CurrentPlayer = f.turns.current()
+	info.Winner = f.winner
+	return info, nil
+}
+
+// Revealed mines don't end the game, only claiming the majority or clearing the board
+func (f *Flags) GameOver(b *Board, move Move, result *MoveResult) *GameOutcome {
+	if result.Result != GameWon {
+		return nil
+	}
+	outcome := &GameOutcome{Reason: EndBoardCleared, PlayerId: move.PlayerId, Placements: placementsByScore(f.claimedMines)}
+	if f.winner != 0 {
+		outcome.Winners = []uint32{f.winner}
+	} else {
+		// Tied players share the win
+		outcome.Winners = placementWinners(outcome.Placements)
+	}
+	if f.claimedMines[f.winner]*2 > f.mines {
+		outcome.Reason = EndScoreReached
+	}
+	return outcome
+}
+
+func (f *Flags) Snapshot() []byte {
+	w := &snapshotWriter{}
+	w.turns(f.turns)
+	w.scores(f.claimedMines)
+	w.uint32(f.winner)
+	return w.data
+}
+
+func (f *Flags) Restore(data []byte) error {
+	r := newSnapshotReader(data)
+	f.turns = r.turns()
+	f.claimedMines = r.scores()
+	f.winner = r.Uint32()
+	return r.Close()
+}
+
+// Returns the player with the most claimed mines or 0 on a tie
+func (f *Flags) leader() uint32 {
+	var leader uint32
+	most := -1
+	for playerId, claimed := range f.claimedMines {
+		if claimed > most {
+			leader, most = playerId, claimed
+		} else if claimed == most {
+			leader = 0
+		}
+	}
+	return leader
+}
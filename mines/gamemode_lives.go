@@ -0,0 +1,157 @@
+package mines
+
+import "maps"
+
+const DefaultLives = 3
+
+// Hitting a mine costs a life instead of ending the game. The lives are shared
+// by the team unless every player has their own. The game is lost once nobody
+// has any lives left.
+type Lives struct {
+	perPlayer   bool
+	lives       int
+	teamLives   int
+	playerLives map[uint32]int
+	// Lives before every move, only kept in games that allow undo
+	allowUndo bool
+	history   []livesUndo
+}
+
+type livesUndo struct {
+	teamLives   int
+	playerLives map[uint32]int
+}
+
+type LivesInfoUpdate struct {
+	// Lives left of the team, unused when every player has their own lives
+	TeamLives   int
+	PlayerLives map[uint32]int
+	// Mines hit by the move
+	ExplodedMines []PlayerMarkChange
+}
+
+func (l *LivesInfoUpdate) GetGameModeId() GameModeId {
+	return ModeLives
+}
+
+func (l *Lives) Init(board *Board, params GameParams) {
+	board.AllowMineReveals()
+	l.lives = params.Lives
+	if l.lives <= 0 {
+		l.lives = DefaultLives
+	}
+	l.perPlayer = params.LivesPerPlayer
+	l.teamLives = l.lives
+	l.playerLives = make(map[uint32]int)
+	l.allowUndo = params.AllowUndo
+	l.history = nil
+}
+
+func (l *Lives) Name() string {
+	return "Lives"
+}
+
+func (l *Lives) GameModeId() GameModeId {
+	return ModeLives
+}
+
+func (l *Lives) AddPlayer(playerId uint32) {
+	if _, ok := l.playerLives[playerId]; !ok && l.perPlayer {
+		l.playerLives[playerId] = l.lives
+	}
+}
+
+// Lives are kept so leaving and coming back doesn't refill them
+func (l *Lives) RemovePlayer(playerId uint32) GamemodeUpdateInfo {
+	return nil
+}
+
+func (l *Lives) ValidateMove(move Move) error {
+	if l.perPlayer && l.livesLeft(move.PlayerId) == 0 {
+		return ErrEliminated
+	}
+	return nil
+}
+
+func (l *Lives) livesLeft(playerId uint32) int {
+	if !l.perPlayer {
+		return l.teamLives
+	}
+	l.AddPlayer(playerId)
+	return l.playerLives[playerId]
+}
+
+func (l *Lives) OnMove(b *Board, move Move, result *MoveResult) (GamemodeUpdateInfo, error) {
+	if result.Result == NoChange {
+		return nil, nil
+	}
+	if l.allowUndo {
+		l.history = append(l.history, livesUndo{l.teamLives, maps.Clone(l.playerLives)})
+	}
+	info := &LivesInfoUpdate{}
+	for _, cell := range result.UpdatedCells {
+		if !cell.Revealed || !cell.Mine {
+			continue
+		}
+		if l.perPlayer {
+			l.playerLives[move.PlayerId] = max(l.livesLeft(move.PlayerId)-1, 0)
+		} else {
+			l.teamLives = max(l.teamLives-1, 0)
+		}
+		info.ExplodedMines = append(info.ExplodedMines, PlayerMarkChange{cell.X, cell.Y, move.PlayerId})
+	}
+	if len(info.ExplodedMines) == 0 {
+		return nil, nil
+	}
+	info.TeamLives = l.teamLives
+	info.PlayerLives = maps.Clone(l.playerLives)
+	return info, nil
+}
+
+func (l *Lives) UndoMove() GamemodeUpdateInfo {
+	if len(l.history) == 0 {
+		return nil
+	}
+	undo := l.history[len(l.history)-1]
+	l.history = l.history[:len(l.history)-1]
+	l.teamLives, l.playerLives = undo.teamLives, undo.playerLives
+	return &LivesInfoUpdate{TeamLives: l.teamLives, PlayerLives: maps.Clone(l.playerLives)}
+}
+
+// Undo history isn't kept, restored games start without moves to undo
+func (l *Lives) Snapshot() []byte {
+	w := &snapshotWriter{}
+	w.int(l.teamLives)
+	w.scores(l.playerLives)
+	return w.data
+}
+
+func (l *Lives) Restore(data []byte) error {
+	r := newSnapshotReader(data)
+	l.teamLives = r.Int()
+	l.playerLives = r.scores()
+	return r.Close()
+}
+
+// The game ends when the board is cleared or when nobody has lives left
+func (l *Lives) GameOver(b *Board, move Move, result *MoveResult) *GameOutcome {
+	if result.Result == GameWon {
+		return &GameOutcome{Reason: EndBoardCleared, PlayerId: move.PlayerId}
+	}
+	if result.Result == MineRevealed && l.OutOfLives() {
+		return &GameOutcome{Reason: EndOutOfLives, PlayerId: move.PlayerId}
+	}
+	return nil
+}
+
+func (l *Lives) OutOfLives() bool {
+	if !l.perPlayer {
+		return l.teamLives == 0
+	}
+	for _, lives := range l.playerLives {
+		if lives > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,20 @@
+package mines
+
+import "time"
+
+// Time limit of time attack games created without one
+const DefaultTimeAttackLimit = 3 * time.Minute
+
+// Classic game against the clock. The board has to be cleared before the time
+// limit of the game runs out.
+type TimeAttack struct {
+	Classic
+}
+
+func (t *TimeAttack) Name() string {
+	return "Time attack"
+}
+
+func (t *TimeAttack) GameModeId() GameModeId {
+	return ModeTimeAttack
+}
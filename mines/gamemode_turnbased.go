@@ -0,0 +1,205 @@
+package mines
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+const (
+	// Points for every safe cell revealed by a player
+	RevealPoints = 1
+	// Points lost by revealing a mine
+	MinePenalty = 10
+)
+
+// Players take turns on a single board. Reveals and chords pass the turn to
+// the next player, flags don't. Turns can be limited in time after which the
+// turn passes to the next player.
+type TurnBased struct {
+	turns        turnOrder
+	playerScores map[uint32]int
+	turnTime     time.Duration
+	turnStart    time.Time
+}
+
+type TurnBasedInfoUpdate struct {
+	CurrentPlayer uint32
+	PlayerScores  map[uint32]int
+	// Time left in the current turn. Zero when turns are not limited.
+	TurnTimeLeft time.Duration
+}
+
+func (t *TurnBasedInfoUpdate) GetGameModeId() GameModeId {
+	return ModeTurnBased
+}
+
+func (t *TurnBased) Init(board *Board, params GameParams) {
+	t.turns = turnOrder{}
+	t.playerScores = make(map[uint32]int)
+	t.turnTime = params.TurnTime
+	t.turnStart = time.Now()
+}
+
+func (t *TurnBased) Name() string {
+	return "Turn based"
+}
+
+func (t *TurnBased) GameModeId() GameModeId {
+	return ModeTurnBased
+}
+
+func (t *TurnBased) AddPlayer(playerId uint32) {
+	if len(t.turns.players) == 0 {
+		t.turnStart = time.Now()
+	}
+	// Players coming back keep their score
+	if _, ok := t.playerScores[playerId]; t.turns.add(playerId) && !ok {
+		t.playerScores[playerId] = 0
+	}
+}
+
+// The player keeps the score but gets no more turns, the turn passes on when
+// it was theirs
+func (t *TurnBased) RemovePlayer(playerId uint32) GamemodeUpdateInfo {
+	current := t.turns.current()
+	if !t.turns.remove(playerId) {
+		return nil
+	}
+	now := time.Now()
+	if current == playerId {
+		t.turnStart = now
+	}
+	return t.info(now)
+}
+
+func (t *TurnBased) CurrentPlayer() uint32 {
+	return t.turns.current()
+}
+
+func (t *TurnBased) ValidateMove(move Move) error {
+	return t.turns.validate(move)
+}
+
+func (t *TurnBased) OnMove(b *Board, move Move, result *MoveResult) (GamemodeUpdateInfo, error) {
+	if result.Result == NoChange {
+		return nil, nil
+	}
+	for _, cell := range result.UpdatedCells {
+		if !cell.Revealed {
+			continue
+		}
+		if cell.Mine {
+			t.playerScores[move.PlayerId] -= MinePenalty
+		} else {
+			t.playerScores[move.PlayerId] += RevealPoints
+		}
+	}
+	if move.Type != Flag {
+		t.nextTurn(time.Now())
+	}
+	return t.info(time.Now()), nil
+}
+
+// The players with the highest score win once the board is cleared or a mine is blown
+func (t *TurnBased) GameOver(b *Board, move Move, result *MoveResult) *GameOutcome {
+	outcome := mineOrWinOutcome(move, result)
+	if outcome != nil {
+		outcome.Placements = placementsByScore(t.playerScores)
+		outcome.Winners = placementWinners(outcome.Placements)
+	}
+	return outcome
+}
+
+// Passes the turn to the next player when the current one runs out of time
+func (t *TurnBased) Tick(now time.Time) GamemodeUpdateInfo {
+	if t.turnTime == 0 || len(t.turns.players) == 0 {
+		return nil
+	}
+	if now.Sub(t.turnStart) >= t.turnTime {
+		t.nextTurn(now)
+	}
+	return t.info(now)
+}
+
+// The current turn keeps the time it had left
+func (t *TurnBased) Snapshot() []byte {
+	w := &snapshotWriter{}
+	w.turns(t.turns)
+	w.scores(t.playerScores)
+	w.duration(time.Since(t.turnStart))
+	return w.data
+}
+
+func (t *TurnBased) Restore(data []byte) error {
+	r := newSnapshotReader(data)
+	t.turns = r.turns()
+	t.playerScores = r.scores()
+	t.turnStart = time.Now().Add(-r.Duration())
+	return r.Close()
+}
+
+func (t *TurnBased) nextTurn(now time.Time) {
+	t.turns.next()
+	t.turnStart = now
+}
+
+func (t *TurnBased) info(now time.Time) *TurnBasedInfoUpdate {
+	info := &TurnBasedInfoUpdate{CurrentPlayer: t.CurrentPlayer(), PlayerScores: maps.Clone(t.playerScores)}
+	if t.turnTime > 0 {
+		info.TurnTimeLeft = max(t.turnTime-now.Sub(t.turnStart), 0)
+	}
+	return info
+}
+
+// Order in which players take turns, players move in the order they joined
+type turnOrder struct {
+	players []uint32
+	turn    int
+}
+
+// Returns false when the player already has a turn
+func (o *turnOrder) add(playerId uint32) bool {
+	if slices.Contains(o.players, playerId) {
+		return false
+	}
+	o.players = append(o.players, playerId)
+	return true
+}
+
+// Returns false when the player has no turn. The turn stays with the current
+// player unless it was the removed one's, then it passes to the next player.
+func (o *turnOrder) remove(playerId uint32) bool {
+	index := slices.Index(o.players, playerId)
+	if index < 0 {
+		return false
+	}
+	o.players = slices.Delete(o.players, index, index+1)
+	if index < o.turn {
+		o.turn--
+	}
+	if o.turn >= len(o.players) {
+		o.turn = 0
+	}
+	return true
+}
+
+func (o *turnOrder) current() uint32 {
+	if len(o.players) == 0 {
+		return 0
+	}
+	return o.players[o.turn]
+}
+
+func (o *turnOrder) next() {
+	if len(o.players) > 0 {
+		o.turn = (o.turn + 1) % len(o.players)
+	}
+}
+
+func (o *turnOrder) validate(move Move) error {
+	if o.current() != move.PlayerId {
+		return ErrNotYourTurn
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package mines
+
+import (
+	"maps"
+	"slices"
+	"sort"
+)
+
+// Every player gets the same board. The first player to clear it wins while
+// blowing a mine only eliminates the player that blew it.
+type Versus struct {
+	// Board every player starts from, the board of the game nobody plays on
+	template   *Board
+	boards     map[uint32]*Board
+	eliminated map[uint32]bool
+	winner     uint32
+}
+
+type VersusPlayerProgress struct {
+	PlayerId uint32
+	// Percentage of the safe cells the player revealed
+	Progress   int
+	Eliminated bool
+}
+
+type VersusInfoUpdate struct {
+	Players []VersusPlayerProgress
+}
+
+func (v *VersusInfoUpdate) GetGameModeId() GameModeId {
+	return ModeVersus
+}
+
+func (v *Versus) Init(board *Board, params GameParams) {
+	// Mines placed by the first reveal would end up different for every
+	// player, so everyone starts from the same opening instead
+	if board.safeFirstMove || board.noGuess {
+		board.Reveal(board.openingCell())
+	}
+	v.template = board
+	v.boards = make(map[uint32]*Board)
+	v.eliminated = make(map[uint32]bool)
+	v.winner = 0
+}
+
+func (v *Versus) Name() string {
+	return "Versus"
+}
+
+func (v *Versus) GameModeId() GameModeId {
+	return ModeVersus
+}
+
+func (v *Versus) AddPlayer(playerId uint32) {
+	if _, ok := v.boards[playerId]; !ok {
+		v.boards[playerId] = v.template.Clone()
+	}
+}
+
+// Players that leave count as eliminated so the game doesn't wait on them.
+// Their board is kept to place them by their progress.
+func (v *Versus) RemovePlayer(playerId uint32) GamemodeUpdateInfo {
+	if _, ok := v.boards[playerId]; !ok || v.eliminated[playerId] {
+		return nil
+	}
+	v.eliminated[playerId] = true
+	return v.progressInfo()
+}
+
+func (v *Versus) PlayerBoard(playerId uint32) *Board {
+	v.AddPlayer(playerId)
+	return v.boards[playerId]
+}
+
+func (v *Versus) HasBoard(playerId uint32) bool {
+	_, ok := v.boards[playerId]
+	return ok
+}
+
+func (v *Versus) Eliminated(playerId uint32) bool {
+	return v.eliminated[playerId]
+}
+
+func (v *Versus) Finished() bool {
+	if v.winner != 0 {
+		return true
+	}
+	return len(v.boards) > 0 && len(v.eliminated) == len(v.boards)
+}
+
+func (v *Versus) OnMove(b *Board, move Move, result *MoveResult) (GamemodeUpdateInfo, error) {
+	if result.Result == NoChange {
+		return nil, nil
+	}
+	switch result.Result {
+	case MineBlown:
+		v.eliminated[move.PlayerId] = true
+	case GameWon:
+		v.winner = move.PlayerId
+	}
+	return v.progressInfo(), nil
+}
+
+// Players are placed by their progress. The game is won by the first player to
+// clear the board and lost by everyone when all players blew a mine.
+func (v *Versus) GameOver(b *Board, move Move, result *MoveResult) *GameOutcome {
+	if !v.Finished() {
+		return nil
+	}
+	progress := make(map[uint32]int, len(v.boards))
+	for playerId, board := range v.boards {
+		progress[playerId] = board.progress()
+	}
+	outcome := &GameOutcome{Reason: EndEliminated, PlayerId: move.PlayerId, Placements: placementsByScore(progress)}
+	if v.winner != 0 {
+		outcome.Reason = EndBoardCleared
+		outcome.Winners = []uint32{v.winner}
+	}
+	return outcome
+}
+
+func (v *Versus) Snapshot() []byte {
+	w := &snapshotWriter{}
+	players := slices.Sorted(maps.Keys(v.boards))
+	w.int(len(players))
+	for _, playerId := range players {
+		w.uint32(playerId)
+		w.board(v.boards[playerId])
+	}
+	w.players(slices.Sorted(maps.Keys(v.eliminated)))
+	w.uint32(v.winner)
+	return w.data
+}
+
+// Boards of the players are restored onto copies of the board of the game
+func (v *Versus) Restore(data []byte) error {
+	r := newSnapshotReader(data)
+	// Every board takes at least its cells, the template is cloned no more
+	// often than the data has boards for
+	count := r.Count(2 + len(v.template.cells))
+	for range count {
+		board := v.template.Clone()
+		v.boards[r.Uint32()] = board
+		r.board(board)
+	}
+	for _, playerId := range r.players() {
+		v.eliminated[playerId] = true
+	}
+	v.winner = r.Uint32()
+	return r.Close()
+}
+
+func (v *Versus) progressInfo() *VersusInfoUpdate {
+	info := &VersusInfoUpdate{}
+	for playerId, board := range v.boards {
+		info.Players = append(info.Players, VersusPlayerProgress{
+			PlayerId:   playerId,
+			Progress:   board.progress(),
+			Eliminated: v.eliminated[playerId],
+		})
+	}
+	sort.Slice(info.Players, func(i, j int) bool {
+		return info.Players[i].PlayerId < info.Players[j].PlayerId
+	})
+	return info
+}
+
+// Enabled cell closest to the centre of the board
+func (board *Board) openingCell() (int, int) {
+	centerX, centerY := board.Width/2, board.Height/2
+	opening, closest := 0, -1
+	for index := range board.cells {
+		if board.has(index, cellDisabled) {
+			continue
+		}
+		dx, dy := index%board.Width-centerX, index/board.Width-centerY
+		if distance := dx*dx + dy*dy; closest < 0 || distance < closest {
+			opening, closest = index, distance
+		}
+	}
+	return opening % board.Width, opening / board.Width
+}
+
+// Percentage of safe cells revealed
+func (board *Board) progress() int {
+	safeCells := board.PlayableCells() - board.Mines
+	if safeCells == 0 {
+		return 100
+	}
+	return board.RevealedCells * 100 / safeCells
+}
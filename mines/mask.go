@@ -0,0 +1,159 @@
+package mines
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	MaskCell = '#'
+	MaskHole = '.'
+)
+
+// Mask gives a board its shape by disabling cells. Disabled cells never hold
+// a mine, aren't anybody's neighbour and don't have to be revealed to win.
+type Mask struct {
+	Width    int
+	Height   int
+	disabled []bool
+	playable int
+}
+
+// Creates a mask of the given size with every cell enabled
+func NewMask(width, height int) (*Mask, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("Invalid mask dimensions (%d, %d)", width, height)
+	}
+	if err := CheckBoardSize(width, height); err != nil {
+		return nil, err
+	}
+	return &Mask{Width: width, Height: height, disabled: make([]bool, width*height), playable: width * height}, nil
+}
+
+// ParseMask reads a mask from rows of text where MaskCell is a playable cell and
+// MaskHole a disabled one. All rows need the same length.
+func ParseMask(rows []string) (*Mask, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("Mask has no rows")
+	}
+	mask, err := NewMask(len(rows[0]), len(rows))
+	if err != nil {
+		return nil, err
+	}
+	for y, row := range rows {
+		if len(row) != mask.Width {
+			return nil, fmt.Errorf("Mask row %d has length %d instead of %d", y, len(row), mask.Width)
+		}
+		for x, char := range []byte(row) {
+			switch char {
+			case MaskCell:
+			case MaskHole:
+				mask.SetEnabled(x, y, false)
+			default:
+				return nil, fmt.Errorf("Unknown mask character %q at (%d, %d)", char, x, y)
+			}
+		}
+	}
+	return mask, nil
+}
+
+func (mask *Mask) Enabled(x, y int) bool {
+	return !mask.disabled[x+y*mask.Width]
+}
+
+func (mask *Mask) SetEnabled(x, y int, enabled bool) {
+	index := x + y*mask.Width
+	if mask.disabled[index] == !enabled {
+		return
+	}
+	mask.disabled[index] = !enabled
+	if enabled {
+		mask.playable++
+	} else {
+		mask.playable--
+	}
+}
+
+// Number of enabled cells
+func (mask *Mask) PlayableCells() int {
+	return mask.playable
+}
+
+func (mask *Mask) Equal(other *Mask) bool {
+	if mask == nil || other == nil {
+		return mask == other
+	}
+	if mask.Width != other.Width || mask.Height != other.Height {
+		return false
+	}
+	for i, disabled := range mask.disabled {
+		if other.disabled[i] != disabled {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the rows of the mask in the format read by ParseMask
+func (mask *Mask) String() string {
+	var builder strings.Builder
+	for y := range mask.Height {
+		if y > 0 {
+			builder.WriteByte('\n')
+		}
+		for x := range mask.Width {
+			if mask.Enabled(x, y) {
+				builder.WriteByte(MaskCell)
+			} else {
+				builder.WriteByte(MaskHole)
+			}
+		}
+	}
+	return builder.String()
+}
+
+// Packs the mask into a bitset with one bit per cell in the order x + y*width.
+// Set bits are disabled cells.
+func (mask *Mask) Bytes() []byte {
+	return packBits(mask.disabled)
+}
+
+// Reads a mask of the given size packed by Bytes
+func MaskFromBytes(width, height int, bits []byte) (*Mask, error) {
+	// The size is checked against the bits before anything gets allocated
+	if width > 0 && height > 8*len(bits)/width {
+		return nil, fmt.Errorf("Mask of size (%d, %d) doesn't fit %d bytes", width, height, len(bits))
+	}
+	mask, err := NewMask(width, height)
+	if err != nil {
+		return nil, err
+	}
+	if len(bits) != bitsetLength(width*height) {
+		return nil, fmt.Errorf("Mask of size (%d, %d) needs %d bytes, got %d", width, height, bitsetLength(width*height), len(bits))
+	}
+	for i := range width * height {
+		if bitSet(bits, i) {
+			mask.SetEnabled(i%width, i/width, false)
+		}
+	}
+	return mask, nil
+}
+
+func bitsetLength(size int) int {
+	return (size + 7) / 8
+}
+
+// Packs the values into a bitset, the first value being the lowest bit
+func packBits(values []bool) []byte {
+	bits := make([]byte, bitsetLength(len(values)))
+	for i, value := range values {
+		if value {
+			bits[i/8] |= 1 << (i % 8)
+		}
+	}
+	return bits
+}
+
+func bitSet(bits []byte, i int) bool {
+	return bits[i/8]&(1<<(i%8)) != 0
+}
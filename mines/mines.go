@@ -1,9 +1,12 @@
 package mines
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"strconv"
+	"time"
 )
 
 type GameModeId byte
@@ -11,20 +14,47 @@ type GameModeId byte
 const (
 	ModeClassic GameModeId = 0
 	ModeCoop               = 1
+	ModeVersus             = 2
+	ModeTurnBased          = 3
+	ModeFlags              = 4
+	ModeLives              = 5
+	ModeTimeAttack         = 6
 )
 
 
 var GameModeNames = map[GameModeId]string{
 	ModeClassic: "Classic",
 	ModeCoop:    "Coop",
+	ModeVersus:  "Versus",
+	ModeTurnBased: "Turn based",
+	ModeFlags:   "Flags",
+	ModeLives:   "Lives",
+	ModeTimeAttack: "Time attack",
 }
 
+// Reports whether the players of the gamemode play against each other, hints
+// or a live view of the other players would give a player an edge there
+func (id GameModeId) Competitive() bool {
+	switch id {
+	case ModeVersus, ModeTurnBased, ModeFlags:
+		return true
+	default:
+		return false
+	}
+}
+
+// Cell is a copy of the state of one cell. Boards store their cells compactly
+// and hand out copies in move results and from Board.Cell.
 type Cell struct {
 	Mine     bool
 	Revealed bool
 	Flagged  bool
 	X        int
 	Y        int
+	// Marked with "?" by a player. Doesn't count as a flag.
+	Questioned bool
+	// Cut out of the board by its mask. Never holds a mine and can't be played.
+	Disabled bool
 }
 
 type GamemodeUpdateInfo interface {
@@ -35,8 +65,34 @@ type Board struct {
 	Width         int
 	Height        int
 	Mines         int
-	Cells         [][]*Cell
 	RevealedCells int
+	// Neighbourhood of the cells. Boards without one use the square grid.
+	Topology Topology
+	// Shape of the board. Boards without one use the whole rectangle.
+	Mask *Mask
+	// Flags of every cell indexed x + y*width
+	cells []byte
+	// Cached mine counts of the cells for countsTopology
+	counts         []byte
+	// Indices of the cells that were revealed or marked in the order they
+	// changed so snapshots of the board don't have to scan every cell
+	changed        []int
+	countsTopology Topology
+	seed           int64
+	// Mines get moved away from the first revealed cell
+	safeFirstMove bool
+	// Mines get placed on the first reveal so the board needs no guessing
+	noGuess bool
+	// No layout without guessing was found, see NoGuessFallback
+	noGuessFallback bool
+	// Flagging cycles through none -> flag -> question mark -> none
+	questionMarks bool
+	// Revealing a mine reports MineRevealed instead of MineBlown
+	revealMines bool
+	// Cells of infinite boards which have no width or height
+	chunks *chunkedBoard
+	// Changes of the move being made in games that allow undo
+	journal *boardUndo
 }
 
 type MoveType byte
@@ -44,6 +100,8 @@ type MoveType byte
 const (
 	Reveal MoveType = 0x01
 	Flag            = 0x02
+	// Reveals the neighbours of a revealed number that has all its mines flagged
+	Chord = 0x03
 )
 
 type Move struct {
@@ -58,47 +116,313 @@ type GameParams struct {
 	Height   int
 	Mines    int
 	GameMode GameModeId
+	// Seed of the mine layout. The same seed and dimensions always produce
+	// the same board. CreateGame picks a random seed when it is zero.
+	Seed int64
+	// The first revealed cell and its neighbours never contain a mine
+	FirstMoveSafe bool
+	// The board can be solved from the first reveal without guessing.
+	// Implies FirstMoveSafe.
+	NoGuess bool
+	// Players can mark cells with "?" by flagging a flagged cell
+	QuestionMarks bool
+	// Time limit of a single turn in turn based games. Zero means no limit.
+	TurnTime time.Duration
+	// Number of mines that can be hit before the game is lost in the lives
+	// gamemode. DefaultLives are used when zero.
+	Lives int
+	// Every player has their own lives instead of sharing them with the team
+	LivesPerPlayer bool
+	// Shape of the board deciding which cells neighbour each other
+	Topology TopologyId
+	// The game is lost when the board isn't cleared in time. The clock starts
+	// with the first move. Zero means no limit.
+	TimeLimit time.Duration
+	// Disables cells to give the board a shape other than a rectangle. Has to
+	// be the size of the board. Nil uses every cell.
+	Mask *Mask
+	// Handcrafted board to play instead of a seeded layout. The size, mines and
+	// mask of the game are taken from it. FirstMoveSafe and NoGuess don't move
+	// its mines.
+	Layout *Board
+	// The board has no borders and is generated in chunks as it gets explored.
+	// Mines are the mines of every chunk and the size is ignored. Only the
+	// classic and coop gamemodes can be played on it.
+	Infinite bool
+	// Moves can be taken back, e.g. in casual or practice games. Only the
+	// gamemodes implementing Rewindable support it.
+	AllowUndo bool
 }
 
 type GameMode interface {
-	Init(*Board)
+	Init(*Board, GameParams)
 	Name() string
 	GameModeId() GameModeId
 	OnMove(*Board, Move, *MoveResult) (GamemodeUpdateInfo, error) // Returns the changes to the gamemode
+	GameOver(*Board, Move, *MoveResult) *GameOutcome              // Called after OnMove, returns nil while the game goes on
+	// Encodes the state of the gamemode for Game.Snapshot
+	Snapshot() []byte
+	// Restores the state encoded by Snapshot. Called after Init with the
+	// params and board of the restored game.
+	Restore([]byte) error
+}
+
+var ErrNotYourTurn = errors.New("not your turn")
+var ErrMoveNotAllowed = errors.New("move not allowed in this gamemode")
+var ErrEliminated = errors.New("player is out of the game")
+var ErrBoardTooLarge = errors.New("board is too large")
+
+// Most cells a board can have, so a requested size can't take all the memory
+const MaxBoardCells = 1 << 24
+
+// Fails with ErrBoardTooLarge for boards of more than MaxBoardCells cells.
+// Other sizes are left to the board to check.
+func CheckBoardSize(width, height int) error {
+	if width > 0 && height > MaxBoardCells/width {
+		return fmt.Errorf("Board of size (%d, %d) has more than %d cells: %w", width, height, MaxBoardCells, ErrBoardTooLarge)
+	}
+	return nil
+}
+
+// Implemented by gamemodes that need to know the players before they move
+type PlayerTracker interface {
+	AddPlayer(playerId uint32)
+	// Takes out a player that left, e.g. out of the turn order. Returns the
+	// changes to broadcast, nil when there are none.
+	RemovePlayer(playerId uint32) GamemodeUpdateInfo
+}
+
+// Implemented by gamemodes that don't allow every move, e.g. out of turn moves
+type MoveValidator interface {
+	ValidateMove(Move) error
+}
+
+// Implemented by gamemodes whose state changes with time. Tick is called
+// periodically while the game runs and returns the changes to broadcast.
+type Ticker interface {
+	Tick(now time.Time) GamemodeUpdateInfo
+}
+
+// Implemented by gamemodes that can take back moves in games that allow undo
+type Rewindable interface {
+	// Reverts the changes of the last move that changed the board and returns
+	// the changes to broadcast, nil when there are none
+	UndoMove() GamemodeUpdateInfo
+}
+
+// Implemented by gamemodes in which every player plays on a board of their own
+type SeparateBoards interface {
+	PlayerTracker
+	// Returns the board of the player, adding the player if needed
+	PlayerBoard(playerId uint32) *Board
+	// Reports whether the player was added and got a board
+	HasBoard(playerId uint32) bool
+	Eliminated(playerId uint32) bool
+	// The game is over for every player
+	Finished() bool
 }
 
 type Game struct {
 	board  *Board
 	Params GameParams
 	Mode   GameMode
+	outcome *GameOutcome
+	// Clock of the game started by the first move and stopped by the end
+	startTime time.Time
+	endTime   time.Time
+	// Moves that changed the board, the last one last. Only kept when the
+	// game allows undo.
+	journal []moveRecord
 }
 
 func (game *Game) MakeMove(move Move) (*MoveResult, GamemodeUpdateInfo, error) {
-	result, err := game.board.makeMove(move)
+	// Moves that come in after the end neither change the board nor the outcome
+	if game.IsOver() {
+		return &MoveResult{NoChange, nil}, nil, nil
+	}
+	// Eliminated players can't play on
+	if mode, ok := game.Mode.(SeparateBoards); ok && (mode.Eliminated(move.PlayerId) || mode.Finished()) {
+		return &MoveResult{NoChange, nil}, nil, nil
+	}
+	if validator, ok := game.Mode.(MoveValidator); ok {
+		if err := validator.ValidateMove(move); err != nil {
+			return nil, nil, err
+		}
+	}
+	board := game.playerBoard(move.PlayerId)
+	record := moveRecord{board: board, outcome: game.outcome, startTime: game.startTime, endTime: game.endTime}
+	if game.Params.AllowUndo {
+		board.startRecording()
+	}
+	result, err := board.makeMove(move)
+	record.undo = board.stopRecording()
 	if err != nil {
 		return nil, nil, err
 	}
-	deltaState, err := game.Mode.OnMove(game.board, move, result)
+	now := time.Now()
+	if game.startTime.IsZero() && result.Result != NoChange {
+		game.startTime = now
+	}
+	deltaState, err := game.Mode.OnMove(board, move, result)
 	if err != nil {
 		return nil, nil, err
 	}
+	if outcome := game.Mode.GameOver(board, move, result); outcome != nil {
+		game.end(outcome, now)
+		record.endedGame = true
+	}
+	if game.Params.AllowUndo && result.Result != NoChange {
+		game.journal = append(game.journal, record)
+	}
 
 	return result, deltaState, err
 
 }
 
+// Reports whether the gamemode ended the game by one of the moves
+func (game *Game) IsOver() bool {
+	return game.outcome != nil
+}
+
+// Outcome of the game or nil while the game goes on
+func (game *Game) Outcome() *GameOutcome {
+	return game.outcome
+}
+
+func (game *Game) end(outcome *GameOutcome, now time.Time) {
+	game.endTime = now
+	outcome.Elapsed = game.Elapsed(now)
+	game.outcome = outcome
+}
+
+// Reports whether the clock of the game runs or ran, i.e. the first move was made
+func (game *Game) Started() bool {
+	return !game.startTime.IsZero()
+}
+
+// Time since the first move. The clock stops when the game ends.
+func (game *Game) Elapsed(now time.Time) time.Duration {
+	if game.startTime.IsZero() {
+		return 0
+	}
+	if !game.endTime.IsZero() {
+		return game.endTime.Sub(game.startTime)
+	}
+	return now.Sub(game.startTime)
+}
+
+// Ends the game with a loss when its time limit ran out. Returns the outcome
+// when it did.
+func (game *Game) CheckTimeLimit(now time.Time) *GameOutcome {
+	limit := game.Params.TimeLimit
+	if limit <= 0 || game.IsOver() || !game.Started() || game.Elapsed(now) < limit {
+		return nil
+	}
+	game.end(&GameOutcome{Reason: EndTimeUp}, game.startTime.Add(limit))
+	return game.outcome
+}
+
+// Returns the board the player makes moves on
+func (game *Game) playerBoard(playerId uint32) *Board {
+	if mode, ok := game.Mode.(SeparateBoards); ok {
+		return mode.PlayerBoard(playerId)
+	}
+	return game.board
+}
+
+// Reports whether every player plays on a separate board
+func (game *Game) HasSeparateBoards() bool {
+	_, ok := game.Mode.(SeparateBoards)
+	return ok
+}
+
+// Reports whether the player got a board of their own. Players sharing the
+// board never have one.
+func (game *Game) HasOwnBoard(playerId uint32) bool {
+	mode, ok := game.Mode.(SeparateBoards)
+	return ok && mode.HasBoard(playerId)
+}
+
+// Reports whether the first reveal is still going to place the mines
+func (game *Game) MinesPending() bool {
+	return game.board.safeFirstMove || game.board.noGuess
+}
+
+// Reports whether the game was meant to need no guessing but its board fell
+// back to only a safe first move
+func (game *Game) NoGuessFallback() bool {
+	return game.board.NoGuessFallback()
+}
+
+// Lets the gamemode know about the player, e.g. to give the player a turn or a board
+func (game *Game) AddPlayer(playerId uint32) {
+	if mode, ok := game.Mode.(PlayerTracker); ok {
+		mode.AddPlayer(playerId)
+	}
+}
+
+// Lets the gamemode know the player left so the game doesn't wait on them.
+// The game ends when the players left are all done. Returns the changes to
+// broadcast, nil when there are none.
+func (game *Game) RemovePlayer(playerId uint32) GamemodeUpdateInfo {
+	mode, ok := game.Mode.(PlayerTracker)
+	if !ok || game.IsOver() {
+		return nil
+	}
+	info := mode.RemovePlayer(playerId)
+	// The players that are left may all be done, e.g. eliminated in versus
+	if outcome := game.Mode.GameOver(game.board, Move{PlayerId: playerId}, &MoveResult{Result: NoChange}); outcome != nil {
+		game.end(outcome, time.Now())
+	}
+	return info
+}
+
 func GetGameModeById(id GameModeId) (GameMode, error) {
 	switch id {
 	case ModeClassic:
 		return &Classic{}, nil
 	case ModeCoop:
 		return &Coop{}, nil
+	case ModeVersus:
+		return &Versus{}, nil
+	case ModeTurnBased:
+		return &TurnBased{}, nil
+	case ModeFlags:
+		return &Flags{}, nil
+	case ModeLives:
+		return &Lives{}, nil
+	case ModeTimeAttack:
+		return &TimeAttack{}, nil
 	default:
 		return nil, fmt.Errorf("Unknown gamemode id: %d", id)
 	}
 }
 
+// NewSeed returns a random non-zero seed for board generation
+func NewSeed() int64 {
+	for {
+		if seed := rand.Int63(); seed != 0 {
+			return seed
+		}
+	}
+}
+
 func CreateGame(params GameParams) (*Game, error) {
+	if params.Seed == 0 {
+		params.Seed = NewSeed()
+	}
+	if params.GameMode == ModeTimeAttack && params.TimeLimit <= 0 {
+		params.TimeLimit = DefaultTimeAttackLimit
+	}
+	if params.Infinite && params.GameMode != ModeClassic && params.GameMode != ModeCoop {
+		return nil, fmt.Errorf("Gamemode %s can't be played on an infinite board", GameModeNames[params.GameMode])
+	}
+	if params.Infinite && params.AllowUndo {
+		return nil, fmt.Errorf("Moves on infinite boards can't be undone")
+	}
+	if layout := params.Layout; layout != nil {
+		params.Width, params.Height, params.Mines, params.Mask = layout.Width, layout.Height, layout.Mines, layout.Mask
+	}
 	board, err := CreateBoardFromParams(params)
 	if err != nil {
 		fmt.Println(err)
@@ -108,7 +432,10 @@ func CreateGame(params GameParams) (*Game, error) {
 	if err != nil {
 		return nil, err
 	}
-	gamemode.Init(board)
+	if _, ok := gamemode.(Rewindable); params.AllowUndo && !ok {
+		return nil, fmt.Errorf("Gamemode %s doesn't support undo", gamemode.Name())
+	}
+	gamemode.Init(board, params)
 
 	return &Game{board: board, Params: params, Mode: gamemode}, nil
 }
@@ -120,15 +447,18 @@ func (move Move) String() string {
 		return msg + "Reveal"
 	case Flag:
 		return msg + "Flag"
+	case Chord:
+		return msg + "Chord"
 	default:
 		return msg + "UNKNOWN"
 	}
 }
 
 type InvalidBoardParamsError struct {
-	height int
-	width  int
-	mines  int
+	height        int
+	width         int
+	mines         int
+	firstMoveSafe bool
 }
 
 type InvalidMoveError struct {
@@ -145,6 +475,8 @@ const (
 	CellRevealed
 	Flagged
 	GameWon
+	// A mine was revealed on a board that allows it without ending the game
+	MineRevealed
 )
 
 type MoveResult struct {
@@ -157,6 +489,7 @@ const (
 	ShowMine       = 0x10
 	ShowFlag       = 0x20
 	Unflag         = 0x30
+	ShowQuestion   = 0x40
 )
 
 type UpdatedCell struct {
@@ -179,60 +512,169 @@ func (e InvalidBoardParamsError) Error() string {
 		return fmt.Sprintf("Cannot create a board with negative amount of mines: %d", e.mines)
 	case e.mines > e.width*e.height:
 		return fmt.Sprintf("Not enough space for %d mines. (%d > %d * %d)", e.mines, e.mines, e.width, e.height)
+	case e.firstMoveSafe && e.mines == e.width*e.height:
+		return fmt.Sprintf("No space left for a safe first move with %d mines", e.mines)
 	default:
 		return "Cannot construct board: unknown error"
 	}
 }
 
 func CreateBoardFromParams(params GameParams) (*Board, error) {
-	return CreateBoard(params.Width, params.Height, params.Mines)
+	if params.Layout != nil {
+		return boardFromLayout(params)
+	}
+	if params.Infinite {
+		return infiniteBoardFromParams(params)
+	}
+	if err := CheckBoardSize(params.Width, params.Height); err != nil {
+		return nil, err
+	}
+	firstMoveSafe := params.FirstMoveSafe || params.NoGuess
+	if params.Mask != nil && (params.Mask.Width != params.Width || params.Mask.Height != params.Height) {
+		return nil, fmt.Errorf("Mask of size (%d, %d) doesn't fit board (%d, %d)", params.Mask.Width, params.Mask.Height, params.Width, params.Height)
+	}
+	if firstMoveSafe && params.Mines > 0 && params.Mines == playableCells(params.Width, params.Height, params.Mask) {
+		return nil, &InvalidBoardParamsError{params.Height, params.Width, params.Mines, true}
+	}
+	topology, err := GetTopologyById(params.Topology)
+	if err != nil {
+		return nil, err
+	}
+	board, err := createBoard(params.Width, params.Height, params.Mines, params.Seed, params.Mask)
+	if err != nil {
+		return nil, err
+	}
+	board.Topology = topology
+	board.safeFirstMove = firstMoveSafe
+	board.noGuess = params.NoGuess
+	board.questionMarks = params.QuestionMarks
+	// Counted with the generated layout, moved mines keep the counts up to date
+	board.mineCounts()
+	return board, nil
+}
+
+func infiniteBoardFromParams(params GameParams) (*Board, error) {
+	if params.Topology != TopologySquare {
+		return nil, fmt.Errorf("Infinite boards only support the square topology")
+	}
+	if params.Mask != nil || params.NoGuess {
+		return nil, fmt.Errorf("Infinite boards can't have a mask or be generated without guessing")
+	}
+	board, err := CreateInfiniteBoard(params.Mines, params.Seed)
+	if err != nil {
+		return nil, err
+	}
+	board.safeFirstMove = params.FirstMoveSafe
+	board.questionMarks = params.QuestionMarks
+	return board, nil
 }
 
-func CreateBoard(width, height, mines int) (*Board, error) {
-	if (width <= 0) || (height <= 0) || (mines < 0) || (mines > width*height) {
-		return nil, &InvalidBoardParamsError{height, width, mines}
-
+// Copies the layout of the params so the game doesn't change it
+func boardFromLayout(params GameParams) (*Board, error) {
+	topology, err := GetTopologyById(params.Topology)
+	if err != nil {
+		return nil, err
 	}
-	cells := make([][]*Cell, width)
-	for i := range cells {
-		cells[i] = make([]*Cell, height)
-		for j := range height {
-			cells[i][j] = &Cell{false, false, false, i, j}
-		}
+	board := params.Layout.Clone()
+	board.Topology = topology
+	board.safeFirstMove, board.noGuess = false, false
+	board.questionMarks = params.QuestionMarks
+	board.mineCounts()
+	return board, nil
+}
+
+// Clone returns a deep copy of the board including the pending first move
+// options
+func (board *Board) Clone() *Board {
+	clone := *board
+	clone.cells = slices.Clone(board.cells)
+	clone.counts = slices.Clone(board.counts)
+	clone.changed = slices.Clone(board.changed)
+	clone.journal = nil
+	if board.chunks != nil {
+		clone.chunks = board.chunks.clone()
 	}
-	mines_position := make([]int, width*height)
+	return &clone
+}
+
+// Clone whose mines stay where they are, its first reveal doesn't move them
+// even when the board's does
+func (board *Board) CloneLayout() *Board {
+	clone := board.Clone()
+	clone.safeFirstMove, clone.noGuess = false, false
+	return clone
+}
+
+// CreateBoard places the mines using a random source built from seed so the
+// layout can be regenerated exactly from the same arguments.
+func CreateBoard(width, height, mines int, seed int64) (*Board, error) {
+	return createBoard(width, height, mines, seed, nil)
+}
 
-	for i := range mines_position {
-		mines_position[i] = i
+// CreateMaskedBoard creates a board shaped by the mask. Mines only get placed
+// on enabled cells.
+func CreateMaskedBoard(mask *Mask, mines int, seed int64) (*Board, error) {
+	return createBoard(mask.Width, mask.Height, mines, seed, mask)
+}
+
+func createBoard(width, height, mines int, seed int64, mask *Mask) (*Board, error) {
+	if err := CheckBoardSize(width, height); err != nil {
+		return nil, err
 	}
+	if (width <= 0) || (height <= 0) || (mines < 0) || (mines > width*height) {
+		return nil, &InvalidBoardParamsError{height, width, mines, false}
 
-	rand.Shuffle(len(mines_position), func(i, j int) {
-		mines_position[i], mines_position[j] = mines_position[j], mines_position[i]
-	})
-	for _, position := range mines_position[:mines] {
-		cells[position%width][position/width].Mine = true
 	}
+	playable := playableCells(width, height, mask)
+	if mines > playable {
+		return nil, fmt.Errorf("Not enough playable cells for %d mines (%d > %d)", mines, mines, playable)
+	}
+	cells := make([]byte, width*height)
+	if mask != nil {
+		for index := range cells {
+			if mask.disabled[index] {
+				cells[index] = cellDisabled
+			}
+		}
+	}
+	placeMines(cells, mines, playable, rand.New(rand.NewSource(seed)))
 
-	return &Board{width, height, mines, cells, 0}, nil
+	return &Board{Width: width, Height: height, Mines: mines, cells: cells, Mask: mask, seed: seed}, nil
 
 }
 
-func cascade(board *Board, cell *Cell, updatedCells []*Cell) []*Cell {
-	cell.Revealed = true
-	updatedCells = append(updatedCells, cell)
-
-	if GetNumberOfMines(board, cell) != 0 {
-		return updatedCells
-	}
-	for _, ncell := range getNeighbouringCells(board, cell) {
-		if !ncell.Revealed && !ncell.Flagged {
-			updatedCells = cascade(board, ncell, updatedCells)
+// Selection sampling: every playable cell gets a mine with the probability of
+// the mines still missing among the cells left, which takes one pass and no
+// memory beyond the cells
+func placeMines(cells []byte, mines, playable int, rng *rand.Rand) {
+	for index := 0; mines > 0; index++ {
+		if cells[index] == cellDisabled {
+			continue
 		}
+		if rng.Float64()*float64(playable) < float64(mines) {
+			cells[index] = cellMine
+			mines--
+		}
+		playable--
+	}
+}
+
+func playableCells(width, height int, mask *Mask) int {
+	if mask == nil {
+		return width * height
 	}
-	return updatedCells
+	return mask.PlayableCells()
+}
+
+// Number of cells that are part of the board shape
+func (board *Board) PlayableCells() int {
+	return playableCells(board.Width, board.Height, board.Mask)
 }
 
 func ValidCellIndex(board *Board, x, y int) bool {
+	if board.Infinite() {
+		return validChunkedPosition(x, y)
+	}
 	return (x >= 0) && (x < board.Width) && (y >= 0) && (y < board.Height)
 }
 
@@ -240,51 +682,155 @@ func (board *Board) Reveal(x, y int) (*MoveResult, error) {
 	if !ValidCellIndex(board, x, y) {
 		return nil, &InvalidMoveError{board, x, y}
 	}
-	var cell = board.Cells[x][y]
-	if cell.Revealed || cell.Flagged {
+	if board.Infinite() {
+		return board.revealChunked(x, y)
+	}
+	index := board.index(x, y)
+	if board.has(index, cellRevealed|cellFlagged|cellDisabled) {
 		return &MoveResult{NoChange, nil}, nil
 	}
-	if cell.Mine {
-		cell.Revealed = true
-		return &MoveResult{MineBlown, []*Cell{cell}}, nil
+	if board.noGuess {
+		if err := board.placeNoGuessMines(index); err != nil {
+			board.noGuessFallback = true
+			board.clearSafeZone(index)
+		}
+	} else if board.safeFirstMove {
+		board.clearSafeZone(index)
 	}
-	var updatedCells = []*Cell{}
-	updatedCells = cascade(board, cell, updatedCells)
-	board.RevealedCells += len(updatedCells)
-	var result MoveResultType
-	if board.RevealedCells+board.Mines == board.Width*board.Height {
-		result = GameWon
-	} else {
-		result = CellRevealed
+	board.safeFirstMove, board.noGuess = false, false
+	if board.has(index, cellMine) {
+		board.reveal(index)
+		return &MoveResult{board.mineResult(), board.snapshot([]int{index})}, nil
 	}
+	revealed := board.cascade(index, nil)
+	board.RevealedCells += len(revealed)
+	return &MoveResult{board.revealResult(), board.snapshot(revealed)}, nil
+}
 
-	return &MoveResult{result, updatedCells}, nil
+// AllowMineReveals makes revealing a mine a regular move that doesn't end the game
+func (board *Board) AllowMineReveals() {
+	board.revealMines = true
 }
 
-func getNeighbouringCells(board *Board, cell *Cell) []*Cell {
-	var cells []*Cell
-	for dx := -1; dx <= 1; dx++ {
-		for dy := -1; dy <= 1; dy++ {
-			x := cell.X + dx
-			y := cell.Y + dy
-			if ValidCellIndex(board, x, y) {
-				cells = append(cells, board.Cells[x][y])
-			}
-		}
+func (board *Board) mineResult() MoveResultType {
+	if board.revealMines {
+		return MineRevealed
 	}
-	return cells
+	return MineBlown
+}
 
+func (board *Board) revealResult() MoveResultType {
+	if board.RevealedCells+board.Mines == board.PlayableCells() {
+		return GameWon
+	}
+	return CellRevealed
 }
 
-func GetNumberOfMines(board *Board, cell *Cell) int {
-	mines := 0
-	for _, cell := range getNeighbouringCells(board, cell) {
-		if cell.Mine {
-			mines++
+// Chord reveals all unflagged neighbours of a revealed number once the same
+// number of its neighbours is flagged. A wrongly placed flag makes the chord
+// reveal a mine.
+func (board *Board) Chord(x, y int) (*MoveResult, error) {
+	if !ValidCellIndex(board, x, y) {
+		return nil, &InvalidMoveError{board, x, y}
+	}
+	if board.Infinite() {
+		return board.chordChunked(x, y)
+	}
+	index := board.index(x, y)
+	if !board.has(index, cellRevealed) || board.has(index, cellMine) {
+		return &MoveResult{NoChange, nil}, nil
+	}
+	neighbours := board.neighbours(index, nil)
+	flagged := 0
+	for _, n := range neighbours {
+		if board.has(n, cellFlagged) {
+			flagged++
+		}
+	}
+	if flagged != board.number(index) {
+		return &MoveResult{NoChange, nil}, nil
+	}
+	var revealed []int
+	mineBlown := false
+	for _, n := range neighbours {
+		// Cells might get revealed by the cascade of a previous neighbour
+		if board.has(n, cellRevealed|cellFlagged) {
+			continue
+		}
+		if board.has(n, cellMine) {
+			board.reveal(n)
+			revealed = append(revealed, n)
+			mineBlown = true
+			continue
 		}
+		revealed = board.cascade(n, revealed)
+	}
+	if len(revealed) == 0 {
+		return &MoveResult{NoChange, nil}, nil
+	}
+	for _, n := range revealed {
+		if !board.has(n, cellMine) {
+			board.RevealedCells++
+		}
+	}
+	if mineBlown {
+		return &MoveResult{board.mineResult(), board.snapshot(revealed)}, nil
 	}
-	return mines
+	return &MoveResult{board.revealResult(), board.snapshot(revealed)}, nil
+}
+
+// Moves the mines out of the cell and its neighbours. The displaced mines go to
+// free cells picked by the seed so boards with the same seed stay similar for
+// different first moves. On boards too dense to keep the whole neighbourhood
+// free only the cell itself is guaranteed to be safe.
+func (board *Board) clearSafeZone(index int) {
+	zone := append([]int{index}, board.neighbours(index, nil)...)
+	displaced := 0
+	for _, n := range zone {
+		if board.has(n, cellMine) {
+			board.setMine(n, false)
+			displaced++
+		}
+	}
+	if displaced == 0 {
+		return
+	}
+	free := board.PlayableCells() - len(zone) - (board.Mines - displaced)
+	rng := rand.New(rand.NewSource(board.seed))
+	for ; displaced > 0 && free > 0; displaced, free = displaced-1, free-1 {
+		// Takes the first free cell from a random start so dense boards don't
+		// keep drawing taken cells
+		target := rng.Intn(len(board.cells))
+		for board.has(target, cellMine|cellDisabled) || slices.Contains(zone, target) {
+			target = (target + 1) % len(board.cells)
+		}
+		board.setMine(target, true)
+	}
+	for _, n := range zone[1:] {
+		if displaced == 0 {
+			return
+		}
+		if !board.has(n, cellMine) {
+			board.setMine(n, true)
+			displaced--
+		}
+	}
+}
 
+func (board *Board) topology() Topology {
+	if board.Topology == nil {
+		return squareTopology{}
+	}
+	return board.Topology
+}
+
+// Returns the number of mines around the cell including the cell itself.
+// Disabled cells are nobody's neighbours.
+func GetNumberOfMines(board *Board, cell *Cell) int {
+	if board.Infinite() {
+		return board.chunkNumber(cell.X, cell.Y)
+	}
+	return board.number(board.index(cell.X, cell.Y))
 }
 
 func (board *Board) Print() {
@@ -296,11 +842,16 @@ func (board *Board) Print() {
 	for y := range board.Height {
 		print(y % 10)
 		for x := range board.Width {
-			if board.Cells[x][y].Revealed {
-				print(strconv.Itoa(GetNumberOfMines(board, board.Cells[x][y])))
-			} else if board.Cells[x][y].Flagged {
+			index := board.index(x, y)
+			if board.has(index, cellDisabled) {
+				print(" ")
+			} else if board.has(index, cellRevealed) {
+				print(strconv.Itoa(board.number(index)))
+			} else if board.has(index, cellFlagged) {
 				print("F")
 
+			} else if board.has(index, cellQuestioned) {
+				print("?")
 			} else {
 				print("#")
 			}
@@ -312,7 +863,10 @@ func (board *Board) Print() {
 func (board *Board) PrintRevaled() {
 	for y := range board.Height {
 		for x := range board.Width {
-			if board.Cells[x][y].Mine {
+			index := board.index(x, y)
+			if board.has(index, cellDisabled) {
+				print(" ")
+			} else if board.has(index, cellMine) {
 				print("O")
 			} else {
 				print("#")
@@ -324,11 +878,9 @@ func (board *Board) PrintRevaled() {
 }
 func (board *Board) RemainingCells() int {
 	remaining := 0
-	for _, column := range board.Cells {
-		for _, cell := range column {
-			if !cell.Revealed {
-				remaining++
-			}
+	for _, flags := range board.cells {
+		if flags&(cellRevealed|cellDisabled) == 0 {
+			remaining++
 		}
 	}
 	return remaining
@@ -339,11 +891,29 @@ func (board *Board) Flag(x, y int) (*MoveResult, error) {
 	if !ValidCellIndex(board, x, y) {
 		return nil, &InvalidMoveError{board, x, y}
 	}
-	if board.Cells[x][y].Revealed {
+	if board.Infinite() {
+		return board.flagChunked(x, y)
+	}
+	index := board.index(x, y)
+	if board.has(index, cellRevealed|cellDisabled) {
 		return &MoveResult{NoChange, nil}, nil
 	}
-	board.Cells[x][y].Flagged = !board.Cells[x][y].Flagged
-	return &MoveResult{Flagged, []*Cell{board.Cells[x][y]}}, nil
+	board.record(index)
+	board.toggleMark(&board.cells[index])
+	board.track(index)
+	return &MoveResult{Flagged, board.snapshot([]int{index})}, nil
+}
+
+// Cycles the mark of a hidden cell through none -> flag -> question mark -> none
+func (board *Board) toggleMark(flags *byte) {
+	switch {
+	case *flags&cellFlagged != 0 && board.questionMarks:
+		*flags = *flags&^cellFlagged | cellQuestioned
+	case *flags&cellQuestioned != 0:
+		*flags &^= cellQuestioned
+	default:
+		*flags ^= cellFlagged
+	}
 }
 
 func (board *Board) makeMove(move Move) (*MoveResult, error) {
@@ -352,6 +922,8 @@ func (board *Board) makeMove(move Move) (*MoveResult, error) {
 		return board.Reveal(move.X, move.Y)
 	case Flag:
 		return board.Flag(move.X, move.Y)
+	case Chord:
+		return board.Chord(move.X, move.Y)
 	default:
 		return nil, fmt.Errorf("Invalid move type %x", move.Type)
 
@@ -369,6 +941,8 @@ func (board *Board) ProcessTextCommand(text string) (*MoveResult, error) {
 	}
 	if flag == 'f' || flag == 'F' {
 		return board.Flag(x, y)
+	} else if flag == 'c' || flag == 'C' {
+		return board.Chord(x, y)
 	} else {
 		result, err := board.Reveal(x, y)
 		println(result.UpdatedCells)
@@ -383,6 +957,11 @@ func (game *Game) CreateCellUpdates(cells []*Cell) ([]UpdatedCell, error) {
 	return game.board.CreateCellUpdates(cells)
 }
 
+// Same as CreateCellUpdates for the cells of the board the player plays on
+func (game *Game) CreatePlayerCellUpdates(playerId uint32, cells []*Cell) ([]UpdatedCell, error) {
+	return game.playerBoard(playerId).CreateCellUpdates(cells)
+}
+
 func (board *Board) CreateCellUpdates(cells []*Cell) ([]UpdatedCell, error) {
 	updates := make([]UpdatedCell, len(cells))
 	var value byte
@@ -395,6 +974,8 @@ func (board *Board) CreateCellUpdates(cells []*Cell) ([]UpdatedCell, error) {
 			}
 		} else if cell.Flagged {
 			value = ShowFlag
+		} else if cell.Questioned {
+			value = ShowQuestion
 		} else {
 			// Is not flagger nor revealed so it must be unflag
 			value = Unflag
@@ -408,15 +989,24 @@ func (game *Game) GetChangedCellUpdates() ([]UpdatedCell, error) {
 	return game.board.GetChangedCellUpdates()
 }
 
+// Same as GetChangedCellUpdates for the board the player plays on
+func (game *Game) GetPlayerChangedCellUpdates(playerId uint32) ([]UpdatedCell, error) {
+	return game.playerBoard(playerId).GetChangedCellUpdates()
+}
+
 func (board *Board) GetChangedCellUpdates() ([]UpdatedCell, error) {
-	updatedCells := []*Cell{}
-	for y := range board.Height {
-		for x := range board.Width {
-			cell := board.Cells[x][y]
-			if cell.Revealed || cell.Flagged {
-				updatedCells = append(updatedCells, cell)
-			}
+	if board.Infinite() {
+		var changed []Position
+		for chunk := range board.chunks.chunks {
+			changed = append(changed, board.changedChunkCells(chunk)...)
+		}
+		return board.CreateCellUpdates(board.chunkSnapshot(changed))
+	}
+	changed := make([]int, 0, len(board.changed))
+	for _, index := range board.changed {
+		if board.has(index, cellRevealed|cellFlagged|cellQuestioned) {
+			changed = append(changed, index)
 		}
 	}
-	return board.CreateCellUpdates(updatedCells)
+	return board.CreateCellUpdates(board.snapshot(changed))
 }
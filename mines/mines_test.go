@@ -0,0 +1,1528 @@
+package mines_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/tomasstrnad1997/mines/mines"
+	"github.com/tomasstrnad1997/mines/mines/solver"
+)
+
+func mineLayout(board *mines.Board) [][]bool {
+	layout := make([][]bool, board.Width)
+	for x := range board.Width {
+		layout[x] = make([]bool, board.Height)
+		for y := range board.Height {
+			layout[x][y] = board.Cell(x, y).Mine
+		}
+	}
+	return layout
+}
+
+// Copies the mines of the board onto a board with nothing revealed
+func hiddenLayout(board *mines.Board) *mines.Board {
+	hidden, err := mines.CreateBoard(board.Width, board.Height, 0, 1)
+	if err != nil {
+		panic(err)
+	}
+	for x := range board.Width {
+		for y := range board.Height {
+			hidden.SetMine(x, y, board.Cell(x, y).Mine)
+		}
+	}
+	return hidden
+}
+
+func isSolvable(t *testing.T, board *mines.Board, x, y int) bool {
+	solvable, err := solver.IsSolvable(board, x, y)
+	if err != nil {
+		t.Fatalf("Failed to check solvability: %v", err)
+	}
+	return solvable
+}
+
+func sameLayout(a, b [][]bool) bool {
+	for x := range a {
+		for y := range a[x] {
+			if a[x][y] != b[x][y] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestSeededBoardIsReproducible(t *testing.T) {
+	first, err := mines.CreateBoard(30, 16, 99, 42)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	second, err := mines.CreateBoard(30, 16, 99, 42)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	if !sameLayout(mineLayout(first), mineLayout(second)) {
+		t.Fatalf("Boards created with the same seed differ")
+	}
+	other, err := mines.CreateBoard(30, 16, 99, 43)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	if sameLayout(mineLayout(first), mineLayout(other)) {
+		t.Fatalf("Boards created with different seeds are identical")
+	}
+}
+
+func TestCreateGamePicksSeed(t *testing.T) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 10, Height: 10, Mines: 10, GameMode: mines.ModeClassic})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	if game.Params.Seed == 0 {
+		t.Fatalf("Game was created without a seed")
+	}
+}
+
+func countMines(board *mines.Board) int {
+	count := 0
+	for _, cell := range allCells(board) {
+		if cell.Mine {
+			count++
+		}
+	}
+	return count
+}
+
+func TestFirstMoveSafe(t *testing.T) {
+	params := mines.GameParams{Width: 9, Height: 9, Mines: 30, Seed: 7, FirstMoveSafe: true}
+	for x := range params.Width {
+		for y := range params.Height {
+			board, err := mines.CreateBoardFromParams(params)
+			if err != nil {
+				t.Fatalf("Failed to create board: %v", err)
+			}
+			result, err := board.Reveal(x, y)
+			if err != nil {
+				t.Fatalf("Failed to reveal (%d, %d): %v", x, y, err)
+			}
+			if result.Result == mines.MineBlown {
+				t.Fatalf("First move at (%d, %d) blew a mine", x, y)
+			}
+			if mines.GetNumberOfMines(board, board.Cell(x, y)) != 0 {
+				t.Fatalf("Neighbours of the first move at (%d, %d) contain a mine", x, y)
+			}
+			if countMines(board) != params.Mines {
+				t.Fatalf("Relocating mines changed their count to %d", countMines(board))
+			}
+		}
+	}
+}
+
+func TestFirstMoveSafeIsReproducible(t *testing.T) {
+	params := mines.GameParams{Width: 16, Height: 16, Mines: 40, Seed: 1234, FirstMoveSafe: true}
+	var layouts [][][]bool
+	for range 2 {
+		board, err := mines.CreateBoardFromParams(params)
+		if err != nil {
+			t.Fatalf("Failed to create board: %v", err)
+		}
+		if _, err := board.Reveal(8, 8); err != nil {
+			t.Fatalf("Failed to reveal: %v", err)
+		}
+		layouts = append(layouts, mineLayout(board))
+	}
+	if !sameLayout(layouts[0], layouts[1]) {
+		t.Fatalf("Same seed and first move produced different boards")
+	}
+}
+
+func TestFirstMoveSafeFullBoard(t *testing.T) {
+	_, err := mines.CreateBoardFromParams(mines.GameParams{Width: 3, Height: 3, Mines: 9, FirstMoveSafe: true})
+	if err == nil {
+		t.Fatalf("Created a full board with a safe first move")
+	}
+}
+
+func TestBoardTooLarge(t *testing.T) {
+	for _, size := range [][2]int{{65535, 65535}, {1 << 31, 1 << 31}, {1, mines.MaxBoardCells + 1}} {
+		params := mines.GameParams{Width: size[0], Height: size[1], Mines: 10}
+		if _, err := mines.CreateBoardFromParams(params); !errors.Is(err, mines.ErrBoardTooLarge) {
+			t.Fatalf("Board of size (%d, %d) was not rejected as too large: %v", size[0], size[1], err)
+		}
+		if _, err := mines.CreateGame(params); !errors.Is(err, mines.ErrBoardTooLarge) {
+			t.Fatalf("Game of size (%d, %d) was not rejected as too large: %v", size[0], size[1], err)
+		}
+	}
+	if _, err := mines.NewMask(65535, 65535); !errors.Is(err, mines.ErrBoardTooLarge) {
+		t.Fatalf("Mask of size (65535, 65535) was not rejected as too large: %v", err)
+	}
+	if _, err := mines.CreateBoardFromParams(mines.GameParams{Width: 4096, Height: 4096, Mines: 10}); err != nil {
+		t.Fatalf("Failed to create a board of the maximum size: %v", err)
+	}
+}
+
+func TestGenerateNoGuessBoard(t *testing.T) {
+	budget := mines.NoGuessBudget{MaxAttempts: 5000}
+	board, err := mines.GenerateNoGuessBoard(16, 16, 40, 99, 3, 4, budget)
+	if err != nil {
+		t.Fatalf("Failed to generate no guess board: %v", err)
+	}
+	if countMines(board) != 40 {
+		t.Fatalf("Generated board has %d mines instead of 40", countMines(board))
+	}
+	if !isSolvable(t, board, 3, 4) {
+		t.Fatalf("Generated board needs guessing")
+	}
+	again, err := mines.GenerateNoGuessBoard(16, 16, 40, 99, 3, 4, budget)
+	if err != nil {
+		t.Fatalf("Failed to generate no guess board: %v", err)
+	}
+	if !sameLayout(mineLayout(board), mineLayout(again)) {
+		t.Fatalf("Same seed and first move produced different boards")
+	}
+}
+
+func TestNoGuessGame(t *testing.T) {
+	params := mines.GameParams{Width: 9, Height: 9, Mines: 10, Seed: 3, NoGuess: true}
+	board, err := mines.CreateBoardFromParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	result, err := board.Reveal(0, 0)
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result == mines.MineBlown {
+		t.Fatalf("First move of a no guess board blew a mine")
+	}
+	if !isSolvable(t, hiddenLayout(board), 0, 0) {
+		t.Fatalf("No guess board needs guessing")
+	}
+	if board.NoGuessFallback() {
+		t.Fatalf("No guess board reported a fallback")
+	}
+}
+
+func TestNoGuessFallback(t *testing.T) {
+	// Too dense to find a layout without guessing
+	params := mines.GameParams{Width: 6, Height: 6, Mines: 26, Seed: 3, NoGuess: true}
+	board, err := mines.CreateBoardFromParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	result, err := board.Reveal(0, 0)
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result == mines.MineBlown {
+		t.Fatalf("First move of a fallen back board blew a mine")
+	}
+	if !board.NoGuessFallback() {
+		t.Fatalf("Board needing guesses didn't report the fallback")
+	}
+}
+
+// Builds a board from rows where '*' marks a mine
+func boardFromRows(rows ...string) *mines.Board {
+	board, err := mines.CreateBoard(len(rows[0]), len(rows), 0, 1)
+	if err != nil {
+		panic(err)
+	}
+	for x := range board.Width {
+		for y := range board.Height {
+			board.SetMine(x, y, rows[y][x] == '*')
+		}
+	}
+	return board
+}
+
+// Copies of all cells of the board
+func allCells(board *mines.Board) []*mines.Cell {
+	var cells []*mines.Cell
+	for x := range board.Width {
+		for y := range board.Height {
+			cells = append(cells, board.Cell(x, y))
+		}
+	}
+	return cells
+}
+
+func TestChord(t *testing.T) {
+	board := boardFromRows(
+		"*..",
+		"...",
+		"...",
+	)
+	if _, err := board.Reveal(1, 1); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	result, err := board.Chord(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to chord: %v", err)
+	}
+	if result.Result != mines.NoChange {
+		t.Fatalf("Chord without flags changed the board")
+	}
+	if _, err := board.Flag(0, 0); err != nil {
+		t.Fatalf("Failed to flag: %v", err)
+	}
+	result, err = board.Chord(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to chord: %v", err)
+	}
+	if result.Result != mines.GameWon {
+		t.Fatalf("Chord did not win the game, result %d", result.Result)
+	}
+	if len(result.UpdatedCells) != 7 {
+		t.Fatalf("Chord revealed %d cells instead of 7", len(result.UpdatedCells))
+	}
+}
+
+func TestChordWrongFlag(t *testing.T) {
+	board := boardFromRows(
+		"*..",
+		"...",
+		"...",
+	)
+	if _, err := board.Reveal(1, 1); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if _, err := board.Flag(2, 2); err != nil {
+		t.Fatalf("Failed to flag: %v", err)
+	}
+	result, err := board.Chord(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to chord: %v", err)
+	}
+	if result.Result != mines.MineBlown {
+		t.Fatalf("Chord around a wrong flag did not blow the mine")
+	}
+	if !board.Cell(0, 0).Revealed {
+		t.Fatalf("Blown mine was not revealed")
+	}
+}
+
+func TestQuestionMarkCycle(t *testing.T) {
+	board, err := mines.CreateBoardFromParams(mines.GameParams{Width: 3, Height: 3, Mines: 1, Seed: 1, QuestionMarks: true})
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	expected := []mines.UpdatedCell{
+		{X: 1, Y: 1, Value: mines.ShowFlag},
+		{X: 1, Y: 1, Value: mines.ShowQuestion},
+		{X: 1, Y: 1, Value: mines.Unflag},
+	}
+	for _, update := range expected {
+		result, err := board.Flag(1, 1)
+		if err != nil {
+			t.Fatalf("Failed to flag: %v", err)
+		}
+		updates, err := board.CreateCellUpdates(result.UpdatedCells)
+		if err != nil {
+			t.Fatalf("Failed to create cell updates: %v", err)
+		}
+		if len(updates) != 1 || updates[0] != update {
+			t.Fatalf("Expected update %v, got %v", update, updates)
+		}
+	}
+	if cell := board.Cell(1, 1); cell.Flagged || cell.Questioned {
+		t.Fatalf("Cell is still marked after a full cycle")
+	}
+}
+
+func TestQuestionMarkIsNotFlag(t *testing.T) {
+	board := boardFromRows(
+		"*..",
+		"...",
+		"...",
+	)
+	if _, err := board.Reveal(1, 1); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	for _, cell := range []*mines.Cell{board.Cell(0, 0), board.Cell(2, 2)} {
+		cell.Questioned = true
+		board.SetCell(cell)
+	}
+	result, err := board.Chord(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to chord: %v", err)
+	}
+	if result.Result != mines.NoChange {
+		t.Fatalf("Chord counted a question mark as a flag")
+	}
+	result, err = board.Reveal(2, 2)
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if board.Cell(2, 2).Questioned {
+		t.Fatalf("Revealed cell kept its question mark")
+	}
+	if result.Result != mines.GameWon {
+		t.Fatalf("Question mark on the mine prevented the win")
+	}
+}
+
+func TestVersusSeparateBoards(t *testing.T) {
+	layout, err := mines.ParseBoard("###\n###\n##O\n")
+	if err != nil {
+		t.Fatalf("Failed to parse board: %v", err)
+	}
+	game, err := mines.CreateGame(mines.GameParams{GameMode: mines.ModeVersus, Layout: layout})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	game.AddPlayer(1)
+	game.AddPlayer(2)
+	result, _, err := game.MakeMove(mines.Move{X: 1, Y: 1, Type: mines.Flag, PlayerId: 1})
+	if err != nil {
+		t.Fatalf("Failed to make move: %v", err)
+	}
+	if result.Result != mines.Flagged {
+		t.Fatalf("Flag was not placed")
+	}
+	updates, err := game.GetPlayerChangedCellUpdates(2)
+	if err != nil {
+		t.Fatalf("Failed to get cell updates: %v", err)
+	}
+	if len(updates) != 0 {
+		t.Fatalf("Move of one player changed the board of another")
+	}
+	result, info, err := game.MakeMove(mines.Move{X: 0, Y: 0, Type: mines.Reveal, PlayerId: 2})
+	if err != nil {
+		t.Fatalf("Failed to make move: %v", err)
+	}
+	if result.Result != mines.GameWon {
+		t.Fatalf("First reveal of a safe board did not win, result %d", result.Result)
+	}
+	versus, ok := info.(*mines.VersusInfoUpdate)
+	if !ok || len(versus.Players) != 2 || versus.Players[1].Progress != 100 || versus.Players[0].Progress != 0 {
+		t.Fatalf("Unexpected versus progress %v", info)
+	}
+	mode := game.Mode.(mines.SeparateBoards)
+	if !mode.Finished() {
+		t.Fatalf("Game not finished after a player won")
+	}
+}
+
+func TestVersusElimination(t *testing.T) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 3, Height: 3, Mines: 8, Seed: 1, GameMode: mines.ModeVersus})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	game.AddPlayer(1)
+	game.AddPlayer(2)
+	mode := game.Mode.(mines.SeparateBoards)
+	board := mode.PlayerBoard(1)
+	var mineX, mineY int
+	for x := range board.Width {
+		for y := range board.Height {
+			if board.Cell(x, y).Mine {
+				mineX, mineY = x, y
+			}
+		}
+	}
+	result, _, err := game.MakeMove(mines.Move{X: mineX, Y: mineY, Type: mines.Reveal, PlayerId: 1})
+	if err != nil {
+		t.Fatalf("Failed to make move: %v", err)
+	}
+	if result.Result != mines.MineBlown || !mode.Eliminated(1) {
+		t.Fatalf("Player was not eliminated by a mine")
+	}
+	if mode.Finished() {
+		t.Fatalf("Game finished while a player is still playing")
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: mineX, Y: mineY, Type: mines.Reveal, PlayerId: 2}); err != nil {
+		t.Fatalf("Failed to make move: %v", err)
+	}
+	if !mode.Finished() {
+		t.Fatalf("Game not finished after every player was eliminated")
+	}
+}
+
+func TestVersusPlayerLeaves(t *testing.T) {
+	for _, blowFirst := range []bool{false, true} {
+		game, err := mines.CreateGame(mines.GameParams{Width: 3, Height: 3, Mines: 8, Seed: 1, GameMode: mines.ModeVersus})
+		if err != nil {
+			t.Fatalf("Failed to create game: %v", err)
+		}
+		game.AddPlayer(1)
+		game.AddPlayer(2)
+		mode := game.Mode.(mines.SeparateBoards)
+		board := mode.PlayerBoard(1)
+		var mineX, mineY int
+		for x := range board.Width {
+			for y := range board.Height {
+				if board.Cell(x, y).Mine {
+					mineX, mineY = x, y
+				}
+			}
+		}
+		blow := func() {
+			if _, _, err := game.MakeMove(mines.Move{X: mineX, Y: mineY, Type: mines.Reveal, PlayerId: 1}); err != nil {
+				t.Fatalf("Failed to make move: %v", err)
+			}
+		}
+		if blowFirst {
+			blow()
+		}
+		info, ok := game.RemovePlayer(2).(*mines.VersusInfoUpdate)
+		if !ok || len(info.Players) != 2 || !info.Players[1].Eliminated {
+			t.Fatalf("Player that left is not shown as eliminated: %v", info)
+		}
+		if !blowFirst {
+			if game.IsOver() {
+				t.Fatalf("Game ended while a player is still playing")
+			}
+			blow()
+		}
+		if !game.IsOver() {
+			t.Fatalf("Game did not end once the only player left blew a mine")
+		}
+		if game.Outcome().Reason != mines.EndEliminated {
+			t.Fatalf("Game ended by %v instead of elimination", game.Outcome().Reason)
+		}
+	}
+}
+
+func TestTurnBased(t *testing.T) {
+	params := mines.GameParams{Width: 5, Height: 5, Mines: 5, Seed: 3, GameMode: mines.ModeTurnBased}
+	game, err := mines.CreateGame(params)
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	// Same seed gives the same layout
+	board, err := mines.CreateBoardFromParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	var safe, mine *mines.Cell
+	for _, cell := range allCells(board) {
+		if cell.Mine {
+			mine = cell
+		} else if mines.GetNumberOfMines(board, cell) > 0 {
+			safe = cell
+		}
+	}
+	game.AddPlayer(1)
+	game.AddPlayer(2)
+	if _, _, err := game.MakeMove(mines.Move{X: safe.X, Y: safe.Y, Type: mines.Reveal, PlayerId: 2}); !errors.Is(err, mines.ErrNotYourTurn) {
+		t.Fatalf("Out of turn move was not rejected: %v", err)
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: mine.X, Y: mine.Y, Type: mines.Flag, PlayerId: 1}); err != nil {
+		t.Fatalf("Failed to flag: %v", err)
+	}
+	_, info, err := game.MakeMove(mines.Move{X: safe.X, Y: safe.Y, Type: mines.Reveal, PlayerId: 1})
+	if err != nil {
+		t.Fatalf("Flag passed the turn: %v", err)
+	}
+	turnInfo := info.(*mines.TurnBasedInfoUpdate)
+	if turnInfo.CurrentPlayer != 2 || turnInfo.PlayerScores[1] != mines.RevealPoints {
+		t.Fatalf("Unexpected turn %d and score %d after a reveal", turnInfo.CurrentPlayer, turnInfo.PlayerScores[1])
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: mine.X, Y: mine.Y, Type: mines.Flag, PlayerId: 2}); err != nil {
+		t.Fatalf("Failed to unflag: %v", err)
+	}
+	_, info, err = game.MakeMove(mines.Move{X: mine.X, Y: mine.Y, Type: mines.Reveal, PlayerId: 2})
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	turnInfo = info.(*mines.TurnBasedInfoUpdate)
+	if turnInfo.CurrentPlayer != 1 || turnInfo.PlayerScores[2] != -mines.MinePenalty {
+		t.Fatalf("Unexpected turn %d and score %d after a mine", turnInfo.CurrentPlayer, turnInfo.PlayerScores[2])
+	}
+}
+
+func TestTurnBasedPlayerLeaves(t *testing.T) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 5, Height: 5, Mines: 5, Seed: 3, GameMode: mines.ModeTurnBased})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	for playerId := range uint32(3) {
+		game.AddPlayer(playerId + 1)
+	}
+	// The turn of the leaving player passes on
+	info, ok := game.RemovePlayer(1).(*mines.TurnBasedInfoUpdate)
+	if !ok || info.CurrentPlayer != 2 {
+		t.Fatalf("Turn didn't pass when its player left: %v", info)
+	}
+	// Other players leaving don't take the turn away
+	info, ok = game.RemovePlayer(3).(*mines.TurnBasedInfoUpdate)
+	if !ok || info.CurrentPlayer != 2 {
+		t.Fatalf("Turn moved when another player left: %v", info)
+	}
+	if game.RemovePlayer(3) != nil {
+		t.Fatalf("Removing a player twice changed the game")
+	}
+	// Coming back gives a turn after the others
+	game.AddPlayer(1)
+	if _, _, err := game.MakeMove(mines.Move{X: 0, Y: 0, Type: mines.Flag, PlayerId: 1}); !errors.Is(err, mines.ErrNotYourTurn) {
+		t.Fatalf("Player coming back took the turn: %v", err)
+	}
+}
+
+func TestTurnBasedTurnTime(t *testing.T) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 5, Height: 5, Mines: 5, GameMode: mines.ModeTurnBased, TurnTime: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	game.AddPlayer(1)
+	game.AddPlayer(2)
+	ticker := game.Mode.(mines.Ticker)
+	info := ticker.Tick(time.Now()).(*mines.TurnBasedInfoUpdate)
+	if info.CurrentPlayer != 1 || info.TurnTimeLeft <= 0 {
+		t.Fatalf("Turn passed before its time ran out")
+	}
+	info = ticker.Tick(time.Now().Add(2 * time.Second)).(*mines.TurnBasedInfoUpdate)
+	if info.CurrentPlayer != 2 {
+		t.Fatalf("Turn did not pass after its time ran out")
+	}
+}
+
+func TestFlags(t *testing.T) {
+	params := mines.GameParams{Width: 4, Height: 4, Mines: 3, Seed: 5, GameMode: mines.ModeFlags}
+	game, err := mines.CreateGame(params)
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	board, err := mines.CreateBoardFromParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	var safe *mines.Cell
+	var mineCells []*mines.Cell
+	for _, cell := range allCells(board) {
+		if cell.Mine {
+			mineCells = append(mineCells, cell)
+		} else if mines.GetNumberOfMines(board, cell) > 0 {
+			safe = cell
+		}
+	}
+	game.AddPlayer(1)
+	game.AddPlayer(2)
+	if _, _, err := game.MakeMove(mines.Move{X: safe.X, Y: safe.Y, Type: mines.Flag, PlayerId: 1}); !errors.Is(err, mines.ErrMoveNotAllowed) {
+		t.Fatalf("Flag move was not rejected: %v", err)
+	}
+	result, info, err := game.MakeMove(mines.Move{X: mineCells[0].X, Y: mineCells[0].Y, Type: mines.Reveal, PlayerId: 1})
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result != mines.MineRevealed {
+		t.Fatalf("Revealing a mine reported %d", result.Result)
+	}
+	if info.(*mines.FlagsInfoUpdate).CurrentPlayer != 1 {
+		t.Fatalf("Claiming a mine did not grant another turn")
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: safe.X, Y: safe.Y, Type: mines.Reveal, PlayerId: 1}); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: mineCells[1].X, Y: mineCells[1].Y, Type: mines.Reveal, PlayerId: 2}); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	result, info, err = game.MakeMove(mines.Move{X: mineCells[2].X, Y: mineCells[2].Y, Type: mines.Reveal, PlayerId: 2})
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result != mines.GameWon || info.(*mines.FlagsInfoUpdate).Winner != 2 {
+		t.Fatalf("Claiming the majority of mines did not win the game")
+	}
+	outcome := game.Outcome()
+	if outcome == nil || outcome.Reason != mines.EndScoreReached || !outcome.Won(2) || outcome.Won(1) {
+		t.Fatalf("Unexpected outcome %v", outcome)
+	}
+	if outcome.Placements[0] != (mines.PlayerPlacement{PlayerId: 2, Place: 1}) {
+		t.Fatalf("Winner is not placed first: %v", outcome.Placements)
+	}
+}
+
+func TestFlagsTie(t *testing.T) {
+	layout, err := mines.ParseBoard("O#O\n###\n")
+	if err != nil {
+		t.Fatalf("Failed to parse board: %v", err)
+	}
+	game, err := mines.CreateGame(mines.GameParams{GameMode: mines.ModeFlags, Layout: layout})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	game.AddPlayer(1)
+	game.AddPlayer(2)
+	game.AddPlayer(3)
+	// Both claim a mine, neither gets the majority before the board is cleared
+	moves := []mines.Move{
+		{X: 0, Y: 0, PlayerId: 1},
+		{X: 1, Y: 1, PlayerId: 1},
+		{X: 2, Y: 0, PlayerId: 2},
+		{X: 0, Y: 1, PlayerId: 2},
+		{X: 1, Y: 0, PlayerId: 3},
+		{X: 2, Y: 1, PlayerId: 1},
+	}
+	for _, move := range moves {
+		move.Type = mines.Reveal
+		if _, _, err := game.MakeMove(move); err != nil {
+			t.Fatalf("Failed to reveal %v: %v", move, err)
+		}
+	}
+	outcome := game.Outcome()
+	if outcome == nil || outcome.Reason != mines.EndBoardCleared {
+		t.Fatalf("Clearing the board ended the game with %v", outcome)
+	}
+	if !outcome.Won(1) || !outcome.Won(2) || outcome.Won(3) {
+		t.Fatalf("Tie has winners %v", outcome.Winners)
+	}
+}
+
+func TestLives(t *testing.T) {
+	params := mines.GameParams{Width: 5, Height: 5, Mines: 3, Seed: 9, GameMode: mines.ModeLives, Lives: 2}
+	game, err := mines.CreateGame(params)
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	board, err := mines.CreateBoardFromParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	var mineCells []*mines.Cell
+	for _, cell := range allCells(board) {
+		if cell.Mine {
+			mineCells = append(mineCells, cell)
+		}
+	}
+	result, info, err := game.MakeMove(mines.Move{X: mineCells[0].X, Y: mineCells[0].Y, Type: mines.Reveal, PlayerId: 1})
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result != mines.MineRevealed || game.IsOver() {
+		t.Fatalf("Hitting a mine with lives left ended the game")
+	}
+	if info.(*mines.LivesInfoUpdate).TeamLives != 1 {
+		t.Fatalf("Hitting a mine did not cost a life")
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: mineCells[1].X, Y: mineCells[1].Y, Type: mines.Reveal, PlayerId: 2}); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if !game.IsOver() {
+		t.Fatalf("Game not over after the last life was lost")
+	}
+	if outcome := game.Outcome(); outcome.Reason != mines.EndOutOfLives || outcome.PlayerId != 2 || outcome.Won(1) {
+		t.Fatalf("Unexpected outcome %v", outcome)
+	}
+}
+
+func TestLivesPerPlayer(t *testing.T) {
+	params := mines.GameParams{Width: 5, Height: 5, Mines: 3, Seed: 9, GameMode: mines.ModeLives, Lives: 1, LivesPerPlayer: true}
+	game, err := mines.CreateGame(params)
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	board, err := mines.CreateBoardFromParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	var mineCells []*mines.Cell
+	for _, cell := range allCells(board) {
+		if cell.Mine {
+			mineCells = append(mineCells, cell)
+		}
+	}
+	game.AddPlayer(1)
+	game.AddPlayer(2)
+	if _, _, err := game.MakeMove(mines.Move{X: mineCells[0].X, Y: mineCells[0].Y, Type: mines.Reveal, PlayerId: 1}); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if game.IsOver() {
+		t.Fatalf("Game over while a player has lives left")
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: mineCells[1].X, Y: mineCells[1].Y, Type: mines.Reveal, PlayerId: 1}); !errors.Is(err, mines.ErrEliminated) {
+		t.Fatalf("Player without lives was able to move: %v", err)
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: mineCells[1].X, Y: mineCells[1].Y, Type: mines.Reveal, PlayerId: 2}); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if !game.IsOver() {
+		t.Fatalf("Game not over after every player lost their lives")
+	}
+}
+
+func TestTimeAttack(t *testing.T) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 10, Height: 10, Mines: 10, GameMode: mines.ModeTimeAttack, FirstMoveSafe: true})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	if game.Params.TimeLimit != mines.DefaultTimeAttackLimit {
+		t.Fatalf("Time attack game created without a time limit")
+	}
+	if game.CheckTimeLimit(time.Now().Add(time.Hour)) != nil || game.Started() {
+		t.Fatalf("Clock started before the first move")
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: 5, Y: 5, Type: mines.Reveal}); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if game.CheckTimeLimit(time.Now()) != nil {
+		t.Fatalf("Game ended before its time limit")
+	}
+	outcome := game.CheckTimeLimit(time.Now().Add(mines.DefaultTimeAttackLimit))
+	if outcome == nil || outcome.Reason != mines.EndTimeUp || outcome.Won(0) {
+		t.Fatalf("Game did not end with a loss when the time ran out")
+	}
+	if outcome.Elapsed != mines.DefaultTimeAttackLimit || game.Elapsed(time.Now().Add(time.Hour)) != outcome.Elapsed {
+		t.Fatalf("Clock did not stop at the time limit")
+	}
+}
+
+func TestTopologyNeighbours(t *testing.T) {
+	tests := []struct {
+		topology mines.TopologyId
+		x, y     int
+		expected int
+	}{
+		{mines.TopologySquare, 0, 0, 3},
+		{mines.TopologySquare, 2, 2, 8},
+		{mines.TopologyHex, 2, 2, 6},
+		{mines.TopologyHex, 2, 3, 6},
+		{mines.TopologyHex, 0, 0, 2},
+		{mines.TopologyTorus, 0, 0, 8},
+		{mines.TopologyKnight, 0, 0, 2},
+		{mines.TopologyKnight, 2, 2, 8},
+	}
+	for _, test := range tests {
+		board, err := mines.CreateBoardFromParams(mines.GameParams{Width: 5, Height: 5, Mines: 24, Seed: 1, Topology: test.topology})
+		if err != nil {
+			t.Fatalf("Failed to create board: %v", err)
+		}
+		// Every cell but one is a mine so a safe cell shows its number of neighbours
+		for x := range board.Width {
+			for y := range board.Height {
+				board.SetMine(x, y, x != test.x || y != test.y)
+			}
+		}
+		if count := mines.GetNumberOfMines(board, board.Cell(test.x, test.y)); count != test.expected {
+			t.Fatalf("%s cell (%d, %d) has %d neighbours instead of %d", mines.TopologyNames[test.topology], test.x, test.y, count, test.expected)
+		}
+	}
+}
+
+func TestTorusCascadeWraps(t *testing.T) {
+	board := boardFromRows(
+		"....",
+		"....",
+		"....",
+		"...*",
+	)
+	board.Topology, _ = mines.GetTopologyById(mines.TopologyTorus)
+	result, err := board.Reveal(0, 0)
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	// The mine neighbours (0, 0) over the corner so nothing cascades
+	if len(result.UpdatedCells) != 1 || mines.GetNumberOfMines(board, board.Cell(0, 0)) != 1 {
+		t.Fatalf("Unexpected reveal of %d cells", len(result.UpdatedCells))
+	}
+}
+
+func heartMask(t *testing.T) *mines.Mask {
+	t.Helper()
+	mask, err := mines.ParseMask([]string{
+		".##.##.",
+		"#######",
+		"#######",
+		".#####.",
+		"..###..",
+		"...#...",
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse mask: %v", err)
+	}
+	return mask
+}
+
+func TestMaskedBoardMines(t *testing.T) {
+	mask := heartMask(t)
+	if mask.PlayableCells() != 27 {
+		t.Fatalf("Heart has %d playable cells instead of 27", mask.PlayableCells())
+	}
+	for seed := range int64(20) {
+		board, err := mines.CreateMaskedBoard(mask, 20, seed)
+		if err != nil {
+			t.Fatalf("Failed to create board: %v", err)
+		}
+		placed := 0
+		for _, cell := range allCells(board) {
+			if cell.Mine && cell.Disabled {
+				t.Fatalf("Seed %d: mine placed on disabled cell (%d, %d)", seed, cell.X, cell.Y)
+			}
+			if cell.Mine {
+				placed++
+			}
+		}
+		if placed != 20 {
+			t.Fatalf("Seed %d: placed %d mines instead of 20", seed, placed)
+		}
+	}
+	if _, err := mines.CreateMaskedBoard(mask, 28, 1); err == nil {
+		t.Fatalf("Created masked board with more mines than playable cells")
+	}
+}
+
+func TestMaskedBoardWin(t *testing.T) {
+	board, err := mines.CreateMaskedBoard(heartMask(t), 0, 1)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	// A hole next to a mine doesn't count as a neighbour
+	board.SetMine(0, 2, true)
+	if count := mines.GetNumberOfMines(board, board.Cell(0, 1)); count != 1 {
+		t.Fatalf("Cell next to the hole sees %d mines instead of 1", count)
+	}
+	result, err := board.Reveal(0, 0)
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result != mines.NoChange {
+		t.Fatalf("Revealing a disabled cell changed the board")
+	}
+	result, err = board.Reveal(3, 5)
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result != mines.GameWon {
+		t.Fatalf("Revealing every playable cell did not win the game (%d revealed)", board.RevealedCells)
+	}
+	if board.RevealedCells != 26 || board.RemainingCells() != 1 {
+		t.Fatalf("Unexpected %d revealed and %d remaining cells", board.RevealedCells, board.RemainingCells())
+	}
+}
+
+func TestMaskedNoGuessBoard(t *testing.T) {
+	mask := heartMask(t)
+	board, err := mines.CreateBoardFromParams(mines.GameParams{Width: 7, Height: 6, Mines: 4, Seed: 2, NoGuess: true, Mask: mask})
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	if _, err := board.Reveal(3, 2); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	for _, cell := range allCells(board) {
+		if cell.Mine && cell.Disabled {
+			t.Fatalf("No guess layout put a mine on disabled cell (%d, %d)", cell.X, cell.Y)
+		}
+	}
+}
+
+func TestBoardTextFormat(t *testing.T) {
+	text := "; Pre-revealed corner with a hole\n" +
+		"..#-\n" +
+		"..O#\n" +
+		"##O#\n"
+	board, err := mines.ParseBoard(text)
+	if err != nil {
+		t.Fatalf("Failed to parse board: %v", err)
+	}
+	if board.Width != 4 || board.Height != 3 || board.Mines != 2 || board.RevealedCells != 4 {
+		t.Fatalf("Unexpected board %dx%d with %d mines and %d revealed cells", board.Width, board.Height, board.Mines, board.RevealedCells)
+	}
+	if !board.Cell(3, 0).Disabled || board.PlayableCells() != 11 {
+		t.Fatalf("Hole of the board was not disabled")
+	}
+	if formatted := mines.FormatBoard(board); formatted != "..#-\n..O#\n##O#\n" {
+		t.Fatalf("Formatted board\n%s\ndoes not match the parsed one", formatted)
+	}
+	for _, invalid := range []string{"", "##\n#", "#x", "..\n.."} {
+		if _, err := mines.ParseBoard(invalid); err == nil {
+			t.Fatalf("Parsed invalid board %q", invalid)
+		}
+	}
+}
+
+func TestBoardBinaryFormat(t *testing.T) {
+	board, err := mines.CreateMaskedBoard(heartMask(t), 6, 4)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	board.SetMine(3, 5, false)
+	if _, err := board.Reveal(3, 5); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	decoded, err := mines.LoadBoard(mines.EncodeBoard(board))
+	if err != nil {
+		t.Fatalf("Failed to decode board: %v", err)
+	}
+	if mines.FormatBoard(decoded) != mines.FormatBoard(board) || decoded.RevealedCells != board.RevealedCells {
+		t.Fatalf("Decoded board\n%s\ndoes not match original\n%s", mines.FormatBoard(decoded), mines.FormatBoard(board))
+	}
+	if !decoded.Mask.Equal(board.Mask) {
+		t.Fatalf("Decoded mask does not match original")
+	}
+	if _, err := mines.DecodeBoard(mines.EncodeBoard(board)[:20]); err == nil {
+		t.Fatalf("Decoded truncated board")
+	}
+}
+
+func TestMoveAfterGameEnd(t *testing.T) {
+	layout, err := mines.ParseBoard("O##\n###\n")
+	if err != nil {
+		t.Fatalf("Failed to parse board: %v", err)
+	}
+	game, err := mines.CreateGame(mines.GameParams{GameMode: mines.ModeCoop, Layout: layout})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: 0, Y: 0, Type: mines.Reveal, PlayerId: 1}); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	outcome := game.Outcome()
+	if outcome == nil || outcome.Reason != mines.EndMineBlown {
+		t.Fatalf("Blown mine ended the game with %v", outcome)
+	}
+	result, _, err := game.MakeMove(mines.Move{X: 2, Y: 1, Type: mines.Reveal, PlayerId: 2})
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result != mines.NoChange || game.Outcome() != outcome {
+		t.Fatalf("Move after the end changed the game with result %d", result.Result)
+	}
+}
+
+func TestMoveAfterTimeUp(t *testing.T) {
+	layout, err := mines.ParseBoard("#O#\n")
+	if err != nil {
+		t.Fatalf("Failed to parse board: %v", err)
+	}
+	game, err := mines.CreateGame(mines.GameParams{GameMode: mines.ModeClassic, Layout: layout, TimeLimit: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: 0, Y: 0, Type: mines.Reveal, PlayerId: 1}); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	outcome := game.CheckTimeLimit(time.Now().Add(time.Minute))
+	if outcome == nil || outcome.Reason != mines.EndTimeUp {
+		t.Fatalf("Time limit ended the game with %v", outcome)
+	}
+	// The winning move came too late
+	result, _, err := game.MakeMove(mines.Move{X: 2, Y: 0, Type: mines.Reveal, PlayerId: 1})
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result != mines.NoChange || game.Outcome().Reason != mines.EndTimeUp {
+		t.Fatalf("Move after the time ran out won the game with result %d", result.Result)
+	}
+}
+
+func TestGameFromLayout(t *testing.T) {
+	layout, err := mines.ParseBoard(
+		"O###\n" +
+			"####\n" +
+			"###O\n")
+	if err != nil {
+		t.Fatalf("Failed to parse board: %v", err)
+	}
+	game, err := mines.CreateGame(mines.GameParams{GameMode: mines.ModeClassic, FirstMoveSafe: true, Layout: layout})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	if game.Params.Width != 4 || game.Params.Height != 3 || game.Params.Mines != 2 {
+		t.Fatalf("Game params were not taken from the layout")
+	}
+	// The layout is played as is even with a safe first move
+	result, _, err := game.MakeMove(mines.Move{X: 0, Y: 0, Type: mines.Reveal})
+	if err != nil {
+		t.Fatalf("Failed to make move: %v", err)
+	}
+	if result.Result != mines.MineBlown {
+		t.Fatalf("Mine of the layout was moved")
+	}
+	if layout.Cell(0, 0).Revealed {
+		t.Fatalf("Playing the game changed the layout")
+	}
+}
+
+func TestInfiniteBoardChunks(t *testing.T) {
+	first, err := mines.CreateInfiniteBoard(40, 3)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	second, err := mines.CreateInfiniteBoard(40, 3)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	// Chunks come out the same whichever order they get generated in
+	second.Cell(mines.ChunkSize-1, mines.ChunkSize-1)
+	second.Cell(-1, mines.ChunkSize-1)
+	second.Cell(mines.ChunkSize-1, -1)
+	mineCount := 0
+	for y := -mines.ChunkSize; y < mines.ChunkSize; y++ {
+		for x := -mines.ChunkSize; x < mines.ChunkSize; x++ {
+			if first.Cell(x, y).Mine != second.Cell(x, y).Mine {
+				t.Fatalf("Boards with the same seed differ at (%d, %d)", x, y)
+			}
+			if first.Cell(x, y).Mine {
+				mineCount++
+			}
+		}
+	}
+	if mineCount != 4*40 {
+		t.Fatalf("Four chunks have %d mines instead of %d", mineCount, 4*40)
+	}
+	if chunk := mines.ChunkOf(-1, mines.ChunkSize); chunk != (mines.ChunkPos{X: -1, Y: 1}) {
+		t.Fatalf("Cell (-1, %d) is in chunk %v", mines.ChunkSize, chunk)
+	}
+	var safe *mines.Cell
+	for x := -100; safe == nil; x-- {
+		if cell := first.Cell(x, -7); !cell.Mine {
+			safe = cell
+		}
+	}
+	result, err := first.Reveal(safe.X, safe.Y)
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result != mines.CellRevealed {
+		t.Fatalf("Revealing a safe cell gave result %d", result.Result)
+	}
+	updates, err := first.ChunkCellUpdates(mines.ChunkOf(safe.X, safe.Y))
+	if err != nil {
+		t.Fatalf("Failed to get chunk updates: %v", err)
+	}
+	revealed, err := first.CreateCellUpdates(result.UpdatedCells)
+	if err != nil {
+		t.Fatalf("Failed to create updates: %v", err)
+	}
+	grouped := mines.GroupByChunk(revealed)
+	if len(updates) == 0 || len(updates) != len(grouped[mines.ChunkOf(safe.X, safe.Y)]) {
+		t.Fatalf("Chunk has %d updates, the reveal changed %d of its cells", len(updates), len(grouped[mines.ChunkOf(safe.X, safe.Y)]))
+	}
+}
+
+func TestInfiniteCoop(t *testing.T) {
+	params := mines.GameParams{Mines: 40, Seed: 5, GameMode: mines.ModeCoop, Infinite: true}
+	game, err := mines.CreateGame(params)
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	board, err := mines.CreateBoardFromParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	var mine, safe *mines.Cell
+	for x := 0; mine == nil || safe == nil; x++ {
+		cell := board.Cell(x, 0)
+		if cell.Mine && mine == nil {
+			mine = cell
+		} else if !cell.Mine && safe == nil {
+			safe = cell
+		}
+	}
+	result, info, err := game.MakeMove(mines.Move{X: mine.X, Y: mine.Y, Type: mines.Reveal, PlayerId: 1})
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result != mines.MineRevealed || game.IsOver() {
+		t.Fatalf("Revealing a mine ended the infinite game")
+	}
+	// Guessing isn't free even though the game goes on
+	if score := info.(*mines.CoopInfoUpdate).PlayerScores[1]; score != -mines.CoopMinePenalty {
+		t.Fatalf("Revealed mine scored %d instead of the penalty %d", score, -mines.CoopMinePenalty)
+	}
+	result, info, err = game.MakeMove(mines.Move{X: safe.X, Y: safe.Y, Type: mines.Reveal, PlayerId: 1})
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if score := info.(*mines.CoopInfoUpdate).PlayerScores[1]; score != len(result.UpdatedCells)-mines.CoopMinePenalty {
+		t.Fatalf("Score %d after revealing %d cells and a mine", score, len(result.UpdatedCells))
+	}
+	if _, err := mines.CreateGame(mines.GameParams{Mines: 40, GameMode: mines.ModeVersus, Infinite: true}); err == nil {
+		t.Fatalf("Created a versus game on an infinite board")
+	}
+}
+
+func TestChangedCellUpdates(t *testing.T) {
+	board := boardFromRows(
+		"*...",
+		"....",
+		"..*.",
+	)
+	if _, err := board.Reveal(3, 0); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if _, err := board.Flag(0, 0); err != nil {
+		t.Fatalf("Failed to flag: %v", err)
+	}
+	if _, err := board.Flag(0, 2); err != nil {
+		t.Fatalf("Failed to flag: %v", err)
+	}
+	// Removed flags aren't part of the snapshot
+	if _, err := board.Flag(0, 2); err != nil {
+		t.Fatalf("Failed to unflag: %v", err)
+	}
+	updates, err := board.GetChangedCellUpdates()
+	if err != nil {
+		t.Fatalf("Failed to get changed cells: %v", err)
+	}
+	expected := map[mines.Position]byte{{X: 0, Y: 0}: mines.ShowFlag}
+	for _, cell := range allCells(board) {
+		if cell.Revealed {
+			expected[mines.Position{X: cell.X, Y: cell.Y}] = byte(mines.GetNumberOfMines(board, cell))
+		}
+	}
+	if len(updates) != len(expected) {
+		t.Fatalf("Snapshot has %d cells instead of %d", len(updates), len(expected))
+	}
+	for _, update := range updates {
+		if value, ok := expected[mines.Position{X: update.X, Y: update.Y}]; !ok || value != update.Value {
+			t.Fatalf("Unexpected update %v", update)
+		}
+	}
+	clone := board.Clone()
+	if _, err := clone.Flag(1, 2); err != nil {
+		t.Fatalf("Failed to flag: %v", err)
+	}
+	if cloned, _ := clone.GetChangedCellUpdates(); len(cloned) != len(updates)+1 {
+		t.Fatalf("Clone has %d changed cells instead of %d", len(cloned), len(updates)+1)
+	}
+	if again, _ := board.GetChangedCellUpdates(); len(again) != len(updates) {
+		t.Fatalf("Flagging the clone changed the board")
+	}
+}
+
+func TestUndo(t *testing.T) {
+	params := mines.GameParams{Width: 8, Height: 8, Mines: 10, Seed: 3, GameMode: mines.ModeCoop, AllowUndo: true}
+	game, err := mines.CreateGame(params)
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	board, err := mines.CreateBoardFromParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	var mine, safe *mines.Cell
+	for _, cell := range allCells(board) {
+		if cell.Mine && mine == nil {
+			mine = cell
+		} else if !cell.Mine && safe == nil {
+			safe = cell
+		}
+	}
+	moves := []mines.Move{
+		{X: safe.X, Y: safe.Y, Type: mines.Reveal, PlayerId: 1},
+		{X: mine.X, Y: mine.Y, Type: mines.Flag, PlayerId: 2},
+		{X: mine.X, Y: mine.Y, Type: mines.Flag, PlayerId: 2},
+		{X: mine.X, Y: mine.Y, Type: mines.Reveal, PlayerId: 1},
+	}
+	for _, move := range moves {
+		if _, _, err := game.MakeMove(move); err != nil {
+			t.Fatalf("Failed to make move %v: %v", move, err)
+		}
+	}
+	if !game.IsOver() {
+		t.Fatalf("Revealing a mine did not end the game")
+	}
+	// Takes back the fatal move
+	undone, err := game.Undo(1)
+	if err != nil {
+		t.Fatalf("Failed to undo: %v", err)
+	}
+	if game.IsOver() || undone.Moves != 1 || len(undone.UpdatedCells) != 1 || undone.UpdatedCells[0].Value != mines.Unflag {
+		t.Fatalf("Undoing the fatal move gave %v", undone)
+	}
+	undone, err = game.Undo(10)
+	if err != nil {
+		t.Fatalf("Failed to undo: %v", err)
+	}
+	if undone.Moves != 3 || game.Started() {
+		t.Fatalf("Undid %d moves instead of 3", undone.Moves)
+	}
+	for _, update := range undone.UpdatedCells {
+		if update.Value != mines.Unflag {
+			t.Fatalf("Reverted cell %v is not hidden", update)
+		}
+	}
+	if changed, _ := game.GetChangedCellUpdates(); len(changed) != 0 {
+		t.Fatalf("Board has %d changed cells after undoing every move", len(changed))
+	}
+	scores := undone.Infos[len(undone.Infos)-1].(*mines.CoopInfoUpdate).PlayerScores
+	if len(scores) != 0 {
+		t.Fatalf("Undoing every move left the scores %v", scores)
+	}
+	if _, err := game.Undo(1); !errors.Is(err, mines.ErrNothingToUndo) {
+		t.Fatalf("Undo without moves returned %v", err)
+	}
+	// The board plays the same after the undo
+	result, _, err := game.MakeMove(moves[0])
+	if err != nil {
+		t.Fatalf("Failed to make move: %v", err)
+	}
+	if result.Result != mines.CellRevealed || len(result.UpdatedCells) != len(undone.UpdatedCells)-1 {
+		t.Fatalf("Replayed move revealed %d cells", len(result.UpdatedCells))
+	}
+}
+
+func TestUndoNotAllowed(t *testing.T) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 5, Height: 5, Mines: 3, GameMode: mines.ModeClassic})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: 0, Y: 0, Type: mines.Flag}); err != nil {
+		t.Fatalf("Failed to flag: %v", err)
+	}
+	if _, err := game.Undo(1); !errors.Is(err, mines.ErrUndoNotAllowed) {
+		t.Fatalf("Undo in a game without undo returned %v", err)
+	}
+	if _, err := mines.CreateGame(mines.GameParams{Width: 5, Height: 5, Mines: 3, GameMode: mines.ModeVersus, AllowUndo: true}); err == nil {
+		t.Fatalf("Created a versus game with undo")
+	}
+}
+
+func cellValues(updates []mines.UpdatedCell) map[mines.Position]byte {
+	values := make(map[mines.Position]byte, len(updates))
+	for _, update := range updates {
+		values[mines.Position{X: update.X, Y: update.Y}] = update.Value
+	}
+	return values
+}
+
+func TestGameSnapshot(t *testing.T) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 8, Height: 8, Mines: 10, Seed: 3, GameMode: mines.ModeCoop, FirstMoveSafe: true})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: 0, Y: 0, Type: mines.Reveal, PlayerId: 1}); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	revealed, err := game.GetChangedCellUpdates()
+	if err != nil {
+		t.Fatalf("Failed to get cell updates: %v", err)
+	}
+	var hidden []mines.Move
+	for y := range 8 {
+		for x := range 8 {
+			if _, ok := cellValues(revealed)[mines.Position{X: x, Y: y}]; !ok {
+				hidden = append(hidden, mines.Move{X: x, Y: y, Type: mines.Flag, PlayerId: 2})
+			}
+		}
+	}
+	if _, _, err := game.MakeMove(hidden[0]); err != nil {
+		t.Fatalf("Failed to flag: %v", err)
+	}
+	elapsed := game.Elapsed(time.Now())
+	restored, err := mines.RestoreGame(game.Snapshot())
+	if err != nil {
+		t.Fatalf("Failed to restore game: %v", err)
+	}
+	// The clock goes on from where it was
+	if restored.Params != game.Params || !restored.Started() || restored.Elapsed(time.Now()) < elapsed {
+		t.Fatalf("Restored game has params %v and elapsed %v", restored.Params, restored.Elapsed(time.Now()))
+	}
+	want, _ := game.GetChangedCellUpdates()
+	got, _ := restored.GetChangedCellUpdates()
+	if !maps.Equal(cellValues(want), cellValues(got)) {
+		t.Fatalf("Restored board shows %v instead of %v", got, want)
+	}
+	if !bytes.Equal(restored.Mode.Snapshot(), game.Mode.Snapshot()) {
+		t.Fatalf("Restored coop state differs")
+	}
+	// Both games play on the same mines
+	for _, move := range hidden[1:] {
+		move.Type = mines.Reveal
+		want, _, err := game.MakeMove(move)
+		if err != nil {
+			t.Fatalf("Failed to reveal: %v", err)
+		}
+		got, _, err := restored.MakeMove(move)
+		if err != nil {
+			t.Fatalf("Failed to reveal: %v", err)
+		}
+		if got.Result != want.Result || len(got.UpdatedCells) != len(want.UpdatedCells) {
+			t.Fatalf("Restored game played %v differently", move)
+		}
+		if game.IsOver() {
+			break
+		}
+	}
+}
+
+func TestVersusSameLayout(t *testing.T) {
+	for _, params := range []mines.GameParams{
+		{Width: 9, Height: 9, Mines: 10, Seed: 5, GameMode: mines.ModeVersus, FirstMoveSafe: true},
+		{Width: 9, Height: 9, Mines: 10, Seed: 5, GameMode: mines.ModeVersus, NoGuess: true},
+	} {
+		game, err := mines.CreateGame(params)
+		if err != nil {
+			t.Fatalf("Failed to create game: %v", err)
+		}
+		game.AddPlayer(1)
+		game.AddPlayer(2)
+		// Every player starts from the same opening
+		opening, err := game.GetPlayerChangedCellUpdates(1)
+		if err != nil {
+			t.Fatalf("Failed to get cell updates: %v", err)
+		}
+		if len(opening) == 0 {
+			t.Fatalf("Versus game started without an opening")
+		}
+		if _, _, err := game.MakeMove(mines.Move{X: 0, Y: 0, Type: mines.Reveal, PlayerId: 1}); err != nil {
+			t.Fatalf("Failed to reveal: %v", err)
+		}
+		if _, _, err := game.MakeMove(mines.Move{X: 8, Y: 8, Type: mines.Reveal, PlayerId: 2}); err != nil {
+			t.Fatalf("Failed to reveal: %v", err)
+		}
+		mode := game.Mode.(mines.SeparateBoards)
+		if !sameLayout(mineLayout(mode.PlayerBoard(1)), mineLayout(mode.PlayerBoard(2))) {
+			t.Fatalf("Different first reveals gave the players different mines with params %v", params)
+		}
+	}
+}
+
+// Snapshot of a board claiming far more cells than the data holds
+func TestRestoreOversizedBoard(t *testing.T) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 4, Height: 4, Mines: 2, Seed: 1})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	snapshot := game.Snapshot()
+	// The width and height follow the magic and the version
+	broken := append([]byte("MG\x01"), binary.AppendVarint(binary.AppendVarint(nil, 4), 6_500_000_000)...)
+	broken = append(broken, snapshot[5:]...)
+	if _, err := mines.RestoreGame(broken); err == nil {
+		t.Fatalf("Restored a board larger than its snapshot")
+	}
+}
+
+func FuzzRestoreGame(f *testing.F) {
+	layout, err := mines.ParseBoard("O#\n##\n")
+	if err != nil {
+		f.Fatalf("Failed to parse board: %v", err)
+	}
+	mask, err := mines.ParseMask([]string{"###", "#.#", "###"})
+	if err != nil {
+		f.Fatalf("Failed to parse mask: %v", err)
+	}
+	for _, params := range []mines.GameParams{
+		{Width: 8, Height: 8, Mines: 10, Seed: 3, GameMode: mines.ModeCoop, FirstMoveSafe: true},
+		{Width: 3, Height: 3, Mines: 1, Seed: 1, GameMode: mines.ModeVersus},
+		{Width: 3, Height: 3, Mines: 2, Seed: 1, GameMode: mines.ModeTurnBased, Mask: mask},
+		{GameMode: mines.ModeFlags, Layout: layout},
+		{Mines: 40, Seed: 5, GameMode: mines.ModeCoop, Infinite: true},
+	} {
+		game, err := mines.CreateGame(params)
+		if err != nil {
+			f.Fatalf("Failed to create game: %v", err)
+		}
+		game.AddPlayer(1)
+		game.AddPlayer(2)
+		if _, _, err := game.MakeMove(mines.Move{X: 0, Y: 1, Type: mines.Reveal, PlayerId: 1}); err != nil {
+			f.Fatalf("Failed to reveal: %v", err)
+		}
+		f.Add(game.Snapshot())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Broken snapshots fail, they must not panic or exhaust the memory
+		mines.RestoreGame(data)
+	})
+}
+
+func TestVersusSnapshot(t *testing.T) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 3, Height: 3, Mines: 1, Seed: 1, GameMode: mines.ModeVersus, FirstMoveSafe: true})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	game.AddPlayer(1)
+	game.AddPlayer(2)
+	if _, _, err := game.MakeMove(mines.Move{X: 0, Y: 0, Type: mines.Flag, PlayerId: 1}); err != nil {
+		t.Fatalf("Failed to flag: %v", err)
+	}
+	snapshot := game.Snapshot()
+	restored, err := mines.RestoreGame(snapshot)
+	if err != nil {
+		t.Fatalf("Failed to restore game: %v", err)
+	}
+	for _, playerId := range []uint32{1, 2} {
+		want, _ := game.GetPlayerChangedCellUpdates(playerId)
+		got, _ := restored.GetPlayerChangedCellUpdates(playerId)
+		if !maps.Equal(cellValues(want), cellValues(got)) {
+			t.Fatalf("Restored board of player %d shows %v instead of %v", playerId, got, want)
+		}
+	}
+	for length := range snapshot {
+		if _, err := mines.RestoreGame(snapshot[:length]); err == nil {
+			t.Fatalf("Restored a snapshot cut to %d bytes", length)
+		}
+	}
+}
+
+func TestHugeCascade(t *testing.T) {
+	board, err := mines.CreateBoard(1000, 1000, 0, 1)
+	if err != nil {
+		t.Fatalf("Failed to create board: %v", err)
+	}
+	board.SetMine(999, 999, true)
+	result, err := board.Reveal(0, 0)
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	if result.Result != mines.GameWon || len(result.UpdatedCells) != 1000*1000-1 {
+		t.Fatalf("Flood revealed %d cells with result %d", len(result.UpdatedCells), result.Result)
+	}
+}
+
+func BenchmarkCreateHugeBoard(b *testing.B) {
+	for i := range b.N {
+		if _, err := mines.CreateBoard(5000, 5000, 5000*5000/5, int64(i+1)); err != nil {
+			b.Fatalf("Failed to create board: %v", err)
+		}
+	}
+}
+
+func BenchmarkSafeFirstMoveHugeBoard(b *testing.B) {
+	for i := range b.N {
+		b.StopTimer()
+		board, err := mines.CreateBoardFromParams(mines.GameParams{Width: 5000, Height: 5000, Mines: 5000 * 5000 / 5, Seed: int64(i + 1), FirstMoveSafe: true})
+		if err != nil {
+			b.Fatalf("Failed to create board: %v", err)
+		}
+		b.StartTimer()
+		if _, err := board.Reveal(2500, 2500); err != nil {
+			b.Fatalf("Failed to reveal: %v", err)
+		}
+	}
+}
+
+func BenchmarkFloodHugeBoard(b *testing.B) {
+	for i := range b.N {
+		b.StopTimer()
+		board, err := mines.CreateBoard(2000, 2000, 2000, int64(i+1))
+		if err != nil {
+			b.Fatalf("Failed to create board: %v", err)
+		}
+		b.StartTimer()
+		for x := 0; x < board.Width; x += 100 {
+			if _, err := board.Reveal(x, x); err != nil {
+				b.Fatalf("Failed to reveal: %v", err)
+			}
+		}
+	}
+}
+
+// Snapshot of a huge board sent to a joining player after a few moves
+func BenchmarkChangedCellUpdatesHugeBoard(b *testing.B) {
+	board, err := mines.CreateBoardFromParams(mines.GameParams{Width: 5000, Height: 5000, Mines: 5000 * 5000 / 5, Seed: 1, FirstMoveSafe: true})
+	if err != nil {
+		b.Fatalf("Failed to create board: %v", err)
+	}
+	if _, err := board.Reveal(2500, 2500); err != nil {
+		b.Fatalf("Failed to reveal: %v", err)
+	}
+	for x := range 100 {
+		if _, err := board.Flag(x, 0); err != nil {
+			b.Fatalf("Failed to flag: %v", err)
+		}
+	}
+	b.ResetTimer()
+	for range b.N {
+		if _, err := board.GetChangedCellUpdates(); err != nil {
+			b.Fatalf("Failed to get changed cells: %v", err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package mines
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Limits the search of GenerateNoGuessBoard. Zero values mean no limit but at
+// least one of them has to be set. A timeout makes the result depend on how
+// fast the machine is, the same seed and first move can then give different
+// boards, so only callers that don't need to generate a board again set it.
+type NoGuessBudget struct {
+	MaxAttempts int
+	Timeout     time.Duration
+}
+
+// Budget of games, only counts attempts so every game generates the same board
+var DefaultNoGuessBudget = NoGuessBudget{MaxAttempts: 2000}
+
+var ErrNoGuessBudgetExceeded = errors.New("no board solvable without guessing found within budget")
+var ErrNoGuessCheck = errors.New("no solvability check to generate boards without guessing")
+
+// Reports whether the board can be cleared from the cell (x, y) without guessing
+type SolvableCheck func(board *Board, x, y int) (bool, error)
+
+// Check of the generated boards. The solver package sets it to its own check
+// when imported, this package can't import it.
+var NoGuessCheck SolvableCheck
+
+// GenerateNoGuessBoard creates a board that NoGuessCheck can fully solve after
+// revealing the cell (x, y). The cell and its neighbours are always free
+// of mines so the first reveal opens an area. Candidate layouts are derived
+// from seed so the same seed and first move give the same board as long as the
+// budget is not exceeded.
+func GenerateNoGuessBoard(width, height, mines int, seed int64, x, y int, budget NoGuessBudget) (*Board, error) {
+	return generateNoGuessBoard(squareTopology{}, nil, width, height, mines, seed, x, y, budget)
+}
+
+func generateNoGuessBoard(topology Topology, mask *Mask, width, height, mines int, seed int64, x, y int, budget NoGuessBudget) (*Board, error) {
+	if budget.MaxAttempts <= 0 && budget.Timeout <= 0 {
+		return nil, errors.New("no guess budget has no limit")
+	}
+	if NoGuessCheck == nil {
+		return nil, ErrNoGuessCheck
+	}
+	if (width <= 0) || (height <= 0) || (mines < 0) || (mines >= width*height) {
+		return nil, &InvalidBoardParamsError{height, width, mines, true}
+	}
+	if x < 0 || x >= width || y < 0 || y >= height {
+		return nil, &InvalidMoveError{&Board{Width: width, Height: height}, x, y}
+	}
+	if mines >= playableCells(width, height, mask) {
+		return nil, fmt.Errorf("Not enough playable cells for %d mines and a safe first move", mines)
+	}
+	var deadline time.Time
+	if budget.Timeout > 0 {
+		deadline = time.Now().Add(budget.Timeout)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	for attempt := 0; budget.MaxAttempts <= 0 || attempt < budget.MaxAttempts; attempt++ {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		candidate, err := createBoard(width, height, mines, rng.Int63(), mask)
+		if err != nil {
+			return nil, err
+		}
+		candidate.Topology = topology
+		candidate.clearSafeZone(candidate.index(x, y))
+		solvable, err := NoGuessCheck(candidate, x, y)
+		if err != nil {
+			return nil, err
+		}
+		if solvable {
+			return candidate, nil
+		}
+	}
+	return nil, ErrNoGuessBudgetExceeded
+}
+
+// Replaces the mine layout of the board with a no guess layout for the first
+// move. Leaves the board as it is when no such layout is found within budget.
+func (board *Board) placeNoGuessMines(index int) error {
+	x, y := index%board.Width, index/board.Width
+	generated, err := generateNoGuessBoard(board.topology(), board.Mask, board.Width, board.Height, board.Mines, board.seed, x, y, DefaultNoGuessBudget)
+	if err != nil {
+		return err
+	}
+	for i := range board.cells {
+		board.setMine(i, generated.has(i, cellMine))
+	}
+	board.seed = generated.seed
+	return nil
+}
+
+// Reports whether the board was meant to need no guessing but no such layout
+// was found, the first reveal then only moved the mines away from the cell
+func (board *Board) NoGuessFallback() bool {
+	return board.noGuessFallback
+}
@@ -0,0 +1,98 @@
+package mines
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+type EndReason byte
+
+const (
+	EndBoardCleared EndReason = iota + 1
+	EndMineBlown
+	EndOutOfLives
+	// A player reached the score needed to win
+	EndScoreReached
+	// Every player was eliminated
+	EndEliminated
+	EndAborted
+	// The time limit of the game ran out
+	EndTimeUp
+)
+
+type PlayerPlacement struct {
+	PlayerId uint32
+	// Players sharing a place have the same one, the best place is 1
+	Place int
+}
+
+// GameOutcome describes how a game ended
+type GameOutcome struct {
+	Reason EndReason
+	// Player whose move ended the game, e.g. the one who blew the mine. Zero
+	// when the game didn't end by a move.
+	PlayerId uint32
+	// Players that won, all of them on a tie. Empty when the players won or
+	// lost together as a team.
+	Winners []uint32
+	// Final placement of the players ordered from the best
+	Placements []PlayerPlacement
+	// Time from the first move to the end of the game
+	Elapsed time.Duration
+}
+
+// Reports whether the player won the game. Without winners the whole team wins
+// by clearing the board.
+func (outcome *GameOutcome) Won(playerId uint32) bool {
+	if len(outcome.Winners) == 0 {
+		return outcome.Reason == EndBoardCleared
+	}
+	return slices.Contains(outcome.Winners, playerId)
+}
+
+// The usual end of a game. Blowing a mine loses and clearing the board wins.
+func mineOrWinOutcome(move Move, result *MoveResult) *GameOutcome {
+	switch result.Result {
+	case MineBlown:
+		return &GameOutcome{Reason: EndMineBlown, PlayerId: move.PlayerId}
+	case GameWon:
+		return &GameOutcome{Reason: EndBoardCleared, PlayerId: move.PlayerId}
+	default:
+		return nil
+	}
+}
+
+// Orders the players by their score, the highest first
+func placementsByScore(scores map[uint32]int) []PlayerPlacement {
+	placements := make([]PlayerPlacement, 0, len(scores))
+	for playerId := range scores {
+		placements = append(placements, PlayerPlacement{PlayerId: playerId})
+	}
+	sort.Slice(placements, func(i, j int) bool {
+		a, b := placements[i], placements[j]
+		if scores[a.PlayerId] != scores[b.PlayerId] {
+			return scores[a.PlayerId] > scores[b.PlayerId]
+		}
+		return a.PlayerId < b.PlayerId
+	})
+	for i := range placements {
+		if i > 0 && scores[placements[i].PlayerId] == scores[placements[i-1].PlayerId] {
+			placements[i].Place = placements[i-1].Place
+		} else {
+			placements[i].Place = i + 1
+		}
+	}
+	return placements
+}
+
+// Players sharing the first place
+func placementWinners(placements []PlayerPlacement) []uint32 {
+	var winners []uint32
+	for _, placement := range placements {
+		if placement.Place == 1 {
+			winners = append(winners, placement.PlayerId)
+		}
+	}
+	return winners
+}
@@ -0,0 +1,151 @@
+package mines
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Reader reads the varint based binary formats of snapshots and replays. It
+// keeps the first error and the reads after it return zero values, so a
+// decoder can read everything and check Close once. Lengths read from the data
+// can't exceed the bytes left, which keeps made up lengths from allocating
+// anything.
+type Reader struct {
+	// Name of the format in errors, e.g. "Snapshot"
+	format string
+	data   []byte
+	err    error
+}
+
+func NewReader(format string, data []byte) *Reader {
+	return &Reader{format: format, data: data}
+}
+
+// Number of bytes left to read
+func (r *Reader) Len() int {
+	return len(r.data)
+}
+
+// Error of the first failed read
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Fails the reader unless it failed already. Messages are prefixed with the
+// name of the format.
+func (r *Reader) Fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf(r.format+" "+format, args...)
+	}
+}
+
+// Returns the error of the first failed read or an error when data is left
+func (r *Reader) Close() error {
+	if r.err == nil && len(r.data) > 0 {
+		r.Fail("has %d unexpected bytes at the end", len(r.data))
+	}
+	return r.err
+}
+
+func (r *Reader) Varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.Fail("ended unexpectedly")
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *Reader) Uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.Fail("ended unexpectedly")
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *Reader) Int() int {
+	return int(r.Varint())
+}
+
+// Reads a player id
+func (r *Reader) Uint32() uint32 {
+	value := r.Uvarint()
+	if value > 1<<32-1 {
+		r.Fail("has an invalid player id %d", value)
+		return 0
+	}
+	return uint32(value)
+}
+
+func (r *Reader) Byte() byte {
+	raw := r.Raw(1)
+	if raw == nil {
+		return 0
+	}
+	return raw[0]
+}
+
+func (r *Reader) Bool() bool {
+	return r.Byte() != 0
+}
+
+func (r *Reader) Duration() time.Duration {
+	return time.Duration(r.Varint())
+}
+
+// Returns the next length bytes of the data
+func (r *Reader) Raw(length int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if length > len(r.data) {
+		r.Fail("ended unexpectedly")
+		return nil
+	}
+	raw := r.data[:length]
+	r.data = r.data[length:]
+	return raw
+}
+
+// Reads bytes prefixed by their length as written by Count
+func (r *Reader) Bytes() []byte {
+	return r.Raw(r.Count(1))
+}
+
+// Reads the varint length of a list of items taking at least itemBytes each.
+// Items of no size, e.g. moves to undo, only need to fit an int32.
+func (r *Reader) Count(itemBytes int) int {
+	return r.checkCount(r.Varint(), itemBytes)
+}
+
+// Like Count for lengths written as uvarints
+func (r *Reader) UCount(itemBytes int) int {
+	count := r.Uvarint()
+	if count > 1<<31-1 {
+		r.Fail("has an invalid length %d", count)
+		return 0
+	}
+	return r.checkCount(int64(count), itemBytes)
+}
+
+func (r *Reader) checkCount(count int64, itemBytes int) int {
+	if r.err != nil {
+		return 0
+	}
+	if count < 0 || count > 1<<31-1 || (itemBytes > 0 && count > int64(len(r.data)/itemBytes)) {
+		r.Fail("has an invalid length %d", count)
+		return 0
+	}
+	return int(count)
+}
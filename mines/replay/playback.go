@@ -0,0 +1,129 @@
+package replay
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tomasstrnad1997/mines/mines"
+)
+
+// Playback simulates a replay on a game restored from its snapshot, one
+// event at a time
+type Playback struct {
+	replay *Replay
+	game   *mines.Game
+	// Number of events applied to the game
+	position int
+}
+
+func NewPlayback(replay *Replay) (*Playback, error) {
+	playback := &Playback{replay: replay}
+	if err := playback.reset(); err != nil {
+		return nil, err
+	}
+	return playback, nil
+}
+
+func (playback *Playback) reset() error {
+	game, err := mines.RestoreGame(playback.replay.Game)
+	if err != nil {
+		return err
+	}
+	playback.game = game
+	playback.position = 0
+	return nil
+}
+
+// Game in the state after the applied events. Seeking backwards replaces it.
+func (playback *Playback) Game() *mines.Game {
+	return playback.game
+}
+
+func (playback *Playback) Replay() *Replay {
+	return playback.replay
+}
+
+// Number of events applied so far
+func (playback *Playback) Position() int {
+	return playback.position
+}
+
+func (playback *Playback) Len() int {
+	return len(playback.replay.Events)
+}
+
+func (playback *Playback) Done() bool {
+	return playback.position == len(playback.replay.Events)
+}
+
+// Time of the match after the applied events
+func (playback *Playback) Time() time.Duration {
+	if playback.position == 0 {
+		return 0
+	}
+	return playback.replay.Events[playback.position-1].Time
+}
+
+// Step applies the next event and returns the changes of the gamemode it made,
+// nil when there are none
+func (playback *Playback) Step() (mines.GamemodeUpdateInfo, error) {
+	if playback.Done() {
+		return nil, fmt.Errorf("Replay has no events left")
+	}
+	event := playback.replay.Events[playback.position]
+	game := playback.game
+	var info mines.GamemodeUpdateInfo
+	switch event.Type {
+	case EventJoin:
+		game.AddPlayer(event.Move.PlayerId)
+	case EventLeave:
+		info = game.RemovePlayer(event.Move.PlayerId)
+	case EventMove:
+		var err error
+		if _, info, err = game.MakeMove(event.Move); err != nil {
+			return nil, fmt.Errorf("Replayed move %v failed: %v", event.Move, err)
+		}
+	case EventUndo:
+		result, err := game.Undo(event.Moves)
+		if err != nil {
+			return nil, fmt.Errorf("Replayed undo failed: %v", err)
+		}
+		if len(result.Infos) > 0 {
+			info = result.Infos[len(result.Infos)-1]
+		}
+	case EventTurnTimeout:
+		// Ticks at the end of the turn so the turn passes whenever the event
+		// gets replayed
+		if ticker, ok := game.Mode.(mines.Ticker); ok {
+			info = ticker.Tick(time.Now().Add(game.Params.TurnTime))
+		}
+	case EventTimeUp:
+		game.CheckTimeLimit(time.Now().Add(game.Params.TimeLimit))
+	}
+	playback.position++
+	return info, nil
+}
+
+// Seek applies or takes back events until the given number of them is applied.
+// Seeking backwards plays the replay again from the start.
+func (playback *Playback) Seek(position int) error {
+	if position < 0 || position > len(playback.replay.Events) {
+		return fmt.Errorf("Position %d is outside of the replay of %d events", position, len(playback.replay.Events))
+	}
+	if position < playback.position {
+		if err := playback.reset(); err != nil {
+			return err
+		}
+	}
+	for playback.position < position {
+		if _, err := playback.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SeekTime seeks to the last event that happened by the given time of the match
+func (playback *Playback) SeekTime(t time.Duration) error {
+	return playback.Seek(playback.replay.EventsBy(t))
+}
@@ -0,0 +1,99 @@
+package replay
+
+import (
+	"time"
+
+	"github.com/tomasstrnad1997/mines/mines"
+)
+
+// Recorder collects the events of a match as the server accepts them. It is
+// not safe for concurrent use, the server records under the lock of its game.
+// A nil Recorder records nothing.
+type Recorder struct {
+	replay *Replay
+	// Game whose first reveal places the mines and the snapshot from before
+	// it. Once the mines are placed the replay starts from them instead, so
+	// playing it back doesn't depend on placing them the same way again.
+	game   *mines.Game
+	start  []byte
+	placed bool
+}
+
+// NewRecorder starts the replay of a game nobody joined yet
+func NewRecorder(game *mines.Game, now time.Time) *Recorder {
+	return Resume(&Replay{Start: now, Game: game.Snapshot()}, game)
+}
+
+// Resume goes on recording the replay of the game, e.g. after restoring both
+// from a checkpoint
+func Resume(replay *Replay, game *mines.Game) *Recorder {
+	recorder := &Recorder{replay: replay}
+	if game.MinesPending() {
+		recorder.game, recorder.start = game, replay.Game
+	}
+	return recorder
+}
+
+func (recorder *Recorder) add(event Event, now time.Time) {
+	if recorder == nil {
+		return
+	}
+	event.Time = now.Sub(recorder.replay.Start)
+	recorder.replay.Events = append(recorder.replay.Events, event)
+}
+
+func (recorder *Recorder) Join(playerId uint32, now time.Time) {
+	recorder.add(Event{Type: EventJoin, Move: mines.Move{PlayerId: playerId}}, now)
+}
+
+func (recorder *Recorder) Leave(playerId uint32, now time.Time) {
+	recorder.add(Event{Type: EventLeave, Move: mines.Move{PlayerId: playerId}}, now)
+}
+
+// Records a move the game accepted. Moves rejected by the gamemode are left out.
+func (recorder *Recorder) Move(move mines.Move, now time.Time) {
+	recorder.add(Event{Type: EventMove, Move: move}, now)
+	recorder.followMines()
+}
+
+func (recorder *Recorder) Undo(moves int, now time.Time) {
+	recorder.add(Event{Type: EventUndo, Moves: moves}, now)
+	recorder.followMines()
+}
+
+func (recorder *Recorder) TurnTimeout(now time.Time) {
+	recorder.add(Event{Type: EventTurnTimeout}, now)
+}
+
+func (recorder *Recorder) TimeUp(now time.Time) {
+	recorder.add(Event{Type: EventTimeUp}, now)
+}
+
+// Starts the replay from the mines the first reveal placed. Taking back the
+// first reveal lets the next one place them again, the replay then starts
+// from before they were placed until they are.
+func (recorder *Recorder) followMines() {
+	if recorder == nil || recorder.game == nil {
+		return
+	}
+	switch pending := recorder.game.MinesPending(); {
+	case pending && recorder.placed:
+		recorder.replay.Game, recorder.placed = recorder.start, false
+	case !pending && !recorder.placed:
+		if placed, err := recorder.game.PlacedSnapshot(); err == nil {
+			recorder.replay.Game = placed
+		}
+		recorder.placed = true
+	}
+	// Without undo the mines stay where they are
+	if recorder.placed && !recorder.game.Params.AllowUndo {
+		recorder.game = nil
+	}
+}
+
+// Replay of the events recorded so far
+func (recorder *Recorder) Replay() *Replay {
+	replay := *recorder.replay
+	replay.Events = append([]Event(nil), replay.Events...)
+	return &replay
+}
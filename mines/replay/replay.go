@@ -0,0 +1,151 @@
+// Package replay records the moves of a match so it can be played back move by
+// move through Game.MakeMove, e.g. to review a co-op session or to settle a
+// dispute.
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/tomasstrnad1997/mines/mines"
+)
+
+type EventType byte
+
+const (
+	// A player joined the game. Gamemodes give out turns and boards in the
+	// order the players joined.
+	EventJoin EventType = iota + 1
+	EventMove
+	EventUndo
+	// The current player ran out of time and the turn passed on
+	EventTurnTimeout
+	// The time limit of the game ran out
+	EventTimeUp
+	// A player left the game, e.g. lost the connection
+	EventLeave
+)
+
+type Event struct {
+	Type EventType
+	// Time since the start of the match
+	Time time.Duration
+	// Move of EventMove. Only the PlayerId is set for EventJoin and EventLeave.
+	Move mines.Move
+	// Moves taken back by EventUndo
+	Moves int
+}
+
+// Replay of a match. The game snapshot holds the params, so the seed or the
+// handcrafted layout, and the state of the game before the first event.
+type Replay struct {
+	Start  time.Time
+	Game   []byte
+	Events []Event
+}
+
+// Replay format:
+// |magic "MR"|version|start|game|events|
+// Numbers are varints. The start is in unix nanoseconds, the game snapshot is
+// prefixed by its length and every event is |type|time|fields of the type|.
+var replayMagic = []byte("MR")
+
+const replayFormatVersion byte = 1
+
+// Params of the replayed game. Only the params are read, the game isn't
+// restored.
+func (replay *Replay) Params() (mines.GameParams, error) {
+	return mines.SnapshotParams(replay.Game)
+}
+
+// Players that joined the match in the order they first joined. Players
+// joining again after reconnecting are listed once.
+func (replay *Replay) Players() []uint32 {
+	var players []uint32
+	for _, event := range replay.Events {
+		if event.Type == EventJoin && !slices.Contains(players, event.Move.PlayerId) {
+			players = append(players, event.Move.PlayerId)
+		}
+	}
+	return players
+}
+
+// Number of events that happened by the given time of the match
+func (replay *Replay) EventsBy(t time.Duration) int {
+	count := 0
+	for count < len(replay.Events) && replay.Events[count].Time <= t {
+		count++
+	}
+	return count
+}
+
+// Time of the last event
+func (replay *Replay) Duration() time.Duration {
+	if len(replay.Events) == 0 {
+		return 0
+	}
+	return replay.Events[len(replay.Events)-1].Time
+}
+
+func Encode(replay *Replay) []byte {
+	data := append(bytes.Clone(replayMagic), replayFormatVersion)
+	data = binary.AppendVarint(data, replay.Start.UnixNano())
+	data = binary.AppendUvarint(data, uint64(len(replay.Game)))
+	data = append(data, replay.Game...)
+	data = binary.AppendUvarint(data, uint64(len(replay.Events)))
+	for _, event := range replay.Events {
+		data = append(data, byte(event.Type))
+		data = binary.AppendVarint(data, int64(event.Time))
+		switch event.Type {
+		case EventJoin, EventLeave:
+			data = binary.AppendUvarint(data, uint64(event.Move.PlayerId))
+		case EventMove:
+			data = binary.AppendUvarint(data, uint64(event.Move.PlayerId))
+			data = binary.AppendVarint(data, int64(event.Move.X))
+			data = binary.AppendVarint(data, int64(event.Move.Y))
+			data = append(data, byte(event.Move.Type))
+		case EventUndo:
+			data = binary.AppendUvarint(data, uint64(event.Moves))
+		}
+	}
+	return data
+}
+
+func Decode(data []byte) (*Replay, error) {
+	if len(data) <= len(replayMagic) || !bytes.HasPrefix(data, replayMagic) {
+		return nil, fmt.Errorf("Data is not a replay")
+	}
+	if version := data[len(replayMagic)]; version != replayFormatVersion {
+		return nil, fmt.Errorf("Unsupported replay version %d", version)
+	}
+	r := mines.NewReader("Replay", data[len(replayMagic)+1:])
+	replay := &Replay{Start: time.Unix(0, r.Varint())}
+	replay.Game = bytes.Clone(r.Raw(r.UCount(1)))
+	// Every event takes at least a type and a time
+	count := r.UCount(2)
+	for range count {
+		event := Event{Type: EventType(r.Byte()), Time: r.Duration()}
+		switch event.Type {
+		case EventJoin, EventLeave:
+			event.Move.PlayerId = r.Uint32()
+		case EventMove:
+			event.Move.PlayerId = r.Uint32()
+			event.Move.X = r.Int()
+			event.Move.Y = r.Int()
+			event.Move.Type = mines.MoveType(r.Byte())
+		case EventUndo:
+			event.Moves = r.UCount(0)
+		case EventTurnTimeout, EventTimeUp:
+		default:
+			r.Fail("has unknown event %d", event.Type)
+		}
+		replay.Events = append(replay.Events, event)
+	}
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
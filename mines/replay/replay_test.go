@@ -0,0 +1,164 @@
+package replay_test
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tomasstrnad1997/mines/mines"
+	"github.com/tomasstrnad1997/mines/mines/replay"
+	_ "github.com/tomasstrnad1997/mines/mines/solver"
+)
+
+func cellValues(t *testing.T, game *mines.Game) map[mines.Position]byte {
+	t.Helper()
+	updates, err := game.GetChangedCellUpdates()
+	if err != nil {
+		t.Fatalf("Failed to get cell updates: %v", err)
+	}
+	values := make(map[mines.Position]byte, len(updates))
+	for _, update := range updates {
+		values[mines.Position{X: update.X, Y: update.Y}] = update.Value
+	}
+	return values
+}
+
+// Plays a coop game of two players and records it the way the server does
+func recordGame(t *testing.T) (*mines.Game, *replay.Replay) {
+	t.Helper()
+	game, err := mines.CreateGame(mines.GameParams{Width: 8, Height: 8, Mines: 10, Seed: 7, GameMode: mines.ModeCoop, FirstMoveSafe: true, AllowUndo: true})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	start := time.Now()
+	recorder := replay.NewRecorder(game, start)
+	// The first player joins again after reconnecting
+	for _, playerId := range []uint32{1, 2, 1} {
+		game.AddPlayer(playerId)
+		recorder.Join(playerId, start)
+	}
+	moves := []mines.Move{
+		{X: 0, Y: 0, Type: mines.Reveal, PlayerId: 1},
+		{X: 7, Y: 7, Type: mines.Flag, PlayerId: 2},
+		{X: 7, Y: 0, Type: mines.Flag, PlayerId: 1},
+	}
+	for i, move := range moves {
+		if _, _, err := game.MakeMove(move); err != nil {
+			t.Fatalf("Failed to make move %v: %v", move, err)
+		}
+		recorder.Move(move, start.Add(time.Duration(i+1)*time.Second))
+	}
+	if _, err := game.Undo(1); err != nil {
+		t.Fatalf("Failed to undo: %v", err)
+	}
+	recorder.Undo(1, start.Add(5*time.Second))
+	return game, recorder.Replay()
+}
+
+func TestReplayEncoding(t *testing.T) {
+	_, recorded := recordGame(t)
+	encoded := replay.Encode(recorded)
+	decoded, err := replay.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode replay: %v", err)
+	}
+	if !decoded.Start.Equal(recorded.Start) || !slices.Equal(decoded.Game, recorded.Game) || !slices.Equal(decoded.Events, recorded.Events) {
+		t.Fatalf("Decoded replay differs from the recorded one")
+	}
+	if players := decoded.Players(); !slices.Equal(players, []uint32{1, 2}) {
+		t.Fatalf("Replay has players %v", players)
+	}
+	if decoded.Duration() != 5*time.Second {
+		t.Fatalf("Replay lasts %v instead of 5s", decoded.Duration())
+	}
+	for length := range encoded {
+		if _, err := replay.Decode(encoded[:length]); err == nil {
+			t.Fatalf("Decoded a replay cut to %d bytes", length)
+		}
+	}
+}
+
+func TestReplayParams(t *testing.T) {
+	game, recorded := recordGame(t)
+	params, err := recorded.Params()
+	if err != nil {
+		t.Fatalf("Failed to read params: %v", err)
+	}
+	if params != game.Params {
+		t.Fatalf("Read params %v instead of %v", params, game.Params)
+	}
+	// Params are read without creating the board, which a broken snapshot
+	// couldn't fill
+	recorded.Game = recorded.Game[:len(recorded.Game)/2]
+	if _, err := recorded.Params(); err != nil {
+		t.Fatalf("Failed to read params of a cut snapshot: %v", err)
+	}
+}
+
+func TestPlayback(t *testing.T) {
+	game, recorded := recordGame(t)
+	playback, err := replay.NewPlayback(recorded)
+	if err != nil {
+		t.Fatalf("Failed to create playback: %v", err)
+	}
+	if err := playback.Seek(playback.Len()); err != nil {
+		t.Fatalf("Failed to play the replay: %v", err)
+	}
+	want := cellValues(t, game)
+	if got := cellValues(t, playback.Game()); !maps.Equal(got, want) {
+		t.Fatalf("Replayed board shows %v instead of %v", got, want)
+	}
+	// Back to the state after the first reveal
+	if err := playback.SeekTime(time.Second); err != nil {
+		t.Fatalf("Failed to seek: %v", err)
+	}
+	if playback.Position() != 4 || playback.Time() != time.Second {
+		t.Fatalf("Seeked to event %d at %v", playback.Position(), playback.Time())
+	}
+	for position, value := range cellValues(t, playback.Game()) {
+		if value == mines.ShowFlag {
+			t.Fatalf("Cell %v is flagged before any flag was placed", position)
+		}
+	}
+	if _, err := playback.Step(); err != nil {
+		t.Fatalf("Failed to step: %v", err)
+	}
+	if got := cellValues(t, playback.Game())[mines.Position{X: 7, Y: 7}]; got != mines.ShowFlag {
+		t.Fatalf("Flag of the next move was not placed")
+	}
+}
+
+func TestReplayStartsFromPlacedMines(t *testing.T) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 9, Height: 9, Mines: 10, Seed: 4, GameMode: mines.ModeClassic, NoGuess: true})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	start := time.Now()
+	recorder := replay.NewRecorder(game, start)
+	game.AddPlayer(1)
+	recorder.Join(1, start)
+	move := mines.Move{X: 4, Y: 4, Type: mines.Reveal, PlayerId: 1}
+	if _, _, err := game.MakeMove(move); err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	recorder.Move(move, start.Add(time.Second))
+	// Without a check the mines would get placed differently
+	check := mines.NoGuessCheck
+	mines.NoGuessCheck = nil
+	defer func() { mines.NoGuessCheck = check }()
+	playback, err := replay.NewPlayback(recorder.Replay())
+	if err != nil {
+		t.Fatalf("Failed to create playback: %v", err)
+	}
+	if playback.Game().MinesPending() {
+		t.Fatalf("Replay starts before the mines were placed")
+	}
+	if err := playback.Seek(playback.Len()); err != nil {
+		t.Fatalf("Failed to play the replay: %v", err)
+	}
+	want := cellValues(t, game)
+	if got := cellValues(t, playback.Game()); !maps.Equal(got, want) {
+		t.Fatalf("Replayed board shows %v instead of %v", got, want)
+	}
+}
@@ -0,0 +1,406 @@
+package mines
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Game snapshot format:
+// |magic "MG"|version|params|board|started|elapsed|ended|outcome|mode|
+// Numbers are varints, byte strings and lists are prefixed by their length and
+// the state of the gamemode is the byte string returned by GameMode.Snapshot.
+// Snapshots of games that allow undo don't keep the moves to undo.
+var gameMagic = []byte("MG")
+
+const gameSnapshotVersion byte = 1
+
+// Flags a cell of a snapshot can have, the changed cells get tracked again
+const snapshotCellFlags = cellMine | cellRevealed | cellFlagged | cellQuestioned | cellDisabled
+
+// Snapshot encodes the state of the game so RestoreGame can continue it, e.g.
+// after the server restarts. The clock of a running game continues from the
+// time elapsed when the snapshot was taken.
+func (game *Game) Snapshot() []byte {
+	w := &snapshotWriter{data: slices.Clone(gameMagic)}
+	w.data = append(w.data, gameSnapshotVersion)
+	w.params(game.Params)
+	w.board(game.board)
+	w.bool(game.Started())
+	w.duration(game.Elapsed(time.Now()))
+	w.bool(game.IsOver())
+	if game.IsOver() {
+		w.outcome(game.outcome)
+	}
+	w.bytes(game.Mode.Snapshot())
+	return w.data
+}
+
+// Snapshot of the game as it was created but with the mines where its first
+// reveal placed them, e.g. for replays that shouldn't place them again. Fails
+// while the mines aren't placed yet and for infinite boards, whose chunks place
+// the mines of the first reveal the same way every time.
+func (game *Game) PlacedSnapshot() ([]byte, error) {
+	if game.board.Infinite() {
+		return nil, fmt.Errorf("Infinite boards have no placed snapshot")
+	}
+	if game.MinesPending() {
+		return nil, fmt.Errorf("Mines of the game are not placed yet")
+	}
+	start, err := CreateGame(game.Params)
+	if err != nil {
+		return nil, err
+	}
+	for index := range start.board.cells {
+		start.board.setMine(index, game.board.has(index, cellMine))
+	}
+	start.board.safeFirstMove, start.board.noGuess = false, false
+	return start.Snapshot(), nil
+}
+
+// Returns a reader of what follows the magic and the version of the snapshot
+func openSnapshot(data []byte) (*snapshotReader, error) {
+	if len(data) <= len(gameMagic) || !bytes.HasPrefix(data, gameMagic) {
+		return nil, fmt.Errorf("Data is not a game snapshot")
+	}
+	if version := data[len(gameMagic)]; version != gameSnapshotVersion {
+		return nil, fmt.Errorf("Unsupported game snapshot version %d", version)
+	}
+	return newSnapshotReader(data[len(gameMagic)+1:]), nil
+}
+
+// SnapshotParams reads the params of the game encoded by Game.Snapshot
+// without creating the game, so nothing gets allocated for its board
+func SnapshotParams(data []byte) (GameParams, error) {
+	r, err := openSnapshot(data)
+	if err != nil {
+		return GameParams{}, err
+	}
+	params := r.params()
+	if r.Err() != nil {
+		return GameParams{}, r.Err()
+	}
+	return params, nil
+}
+
+// RestoreGame creates the game encoded by Game.Snapshot
+func RestoreGame(data []byte) (*Game, error) {
+	r, err := openSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+	params := r.params()
+	if !params.Infinite && params.Layout == nil {
+		r.boardSize(params.Width, params.Height)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	game, err := CreateGame(params)
+	if err != nil {
+		return nil, err
+	}
+	r.board(game.board)
+	started := r.Bool()
+	elapsed := r.Duration()
+	if r.Bool() {
+		game.outcome = r.outcome()
+	}
+	mode := r.Bytes()
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+	if err := game.Mode.Restore(mode); err != nil {
+		return nil, fmt.Errorf("Failed to restore %s gamemode: %v", game.Mode.Name(), err)
+	}
+	if started {
+		now := time.Now()
+		game.startTime = now.Add(-elapsed)
+		if game.outcome != nil {
+			game.endTime = now
+		}
+	}
+	return game, nil
+}
+
+type snapshotWriter struct {
+	data []byte
+}
+
+func (w *snapshotWriter) int(value int) {
+	w.data = binary.AppendVarint(w.data, int64(value))
+}
+
+func (w *snapshotWriter) uint32(value uint32) {
+	w.data = binary.AppendUvarint(w.data, uint64(value))
+}
+
+func (w *snapshotWriter) bool(value bool) {
+	if value {
+		w.data = append(w.data, 1)
+	} else {
+		w.data = append(w.data, 0)
+	}
+}
+
+func (w *snapshotWriter) duration(value time.Duration) {
+	w.int(int(value))
+}
+
+func (w *snapshotWriter) bytes(value []byte) {
+	w.int(len(value))
+	w.data = append(w.data, value...)
+}
+
+// Scores ordered by player so equal states give equal snapshots
+func (w *snapshotWriter) scores(scores map[uint32]int) {
+	w.int(len(scores))
+	for _, playerId := range slices.Sorted(maps.Keys(scores)) {
+		w.uint32(playerId)
+		w.int(scores[playerId])
+	}
+}
+
+func (w *snapshotWriter) players(players []uint32) {
+	w.int(len(players))
+	for _, playerId := range players {
+		w.uint32(playerId)
+	}
+}
+
+func (w *snapshotWriter) turns(turns turnOrder) {
+	w.players(turns.players)
+	w.int(turns.turn)
+}
+
+func (w *snapshotWriter) params(params GameParams) {
+	w.int(params.Width)
+	w.int(params.Height)
+	w.int(params.Mines)
+	w.int(int(params.GameMode))
+	w.int(int(params.Seed))
+	w.bool(params.FirstMoveSafe)
+	w.bool(params.NoGuess)
+	w.bool(params.QuestionMarks)
+	w.duration(params.TurnTime)
+	w.int(params.Lives)
+	w.bool(params.LivesPerPlayer)
+	w.int(int(params.Topology))
+	w.duration(params.TimeLimit)
+	w.bool(params.Mask != nil)
+	if params.Mask != nil {
+		w.int(params.Mask.Width)
+		w.int(params.Mask.Height)
+		w.bytes(params.Mask.Bytes())
+	}
+	w.bool(params.Layout != nil)
+	if params.Layout != nil {
+		w.bytes(EncodeBoard(params.Layout))
+	}
+	w.bool(params.Infinite)
+	w.bool(params.AllowUndo)
+}
+
+// Writes the cells and pending first move of the board. Everything else is
+// created again from the params.
+func (w *snapshotWriter) board(board *Board) {
+	w.bool(board.safeFirstMove)
+	w.bool(board.noGuess)
+	if board.Infinite() {
+		chunks := slices.SortedFunc(maps.Keys(board.chunks.chunks), func(a, b ChunkPos) int {
+			if a.Y != b.Y {
+				return a.Y - b.Y
+			}
+			return a.X - b.X
+		})
+		w.int(len(chunks))
+		for _, chunk := range chunks {
+			w.int(chunk.X)
+			w.int(chunk.Y)
+			w.data = append(w.data, board.chunks.chunks[chunk]...)
+		}
+		return
+	}
+	w.int(len(board.cells))
+	for _, flags := range board.cells {
+		w.data = append(w.data, flags&snapshotCellFlags)
+	}
+}
+
+func (w *snapshotWriter) outcome(outcome *GameOutcome) {
+	w.int(int(outcome.Reason))
+	w.uint32(outcome.PlayerId)
+	w.players(outcome.Winners)
+	w.int(len(outcome.Placements))
+	for _, placement := range outcome.Placements {
+		w.uint32(placement.PlayerId)
+		w.int(placement.Place)
+	}
+	w.duration(outcome.Elapsed)
+}
+
+// Reads what snapshotWriter wrote
+type snapshotReader struct {
+	*Reader
+}
+
+func newSnapshotReader(data []byte) *snapshotReader {
+	return &snapshotReader{NewReader("Snapshot", data)}
+}
+
+func (r *snapshotReader) scores() map[uint32]int {
+	count := r.Count(2)
+	scores := make(map[uint32]int, count)
+	for range count {
+		playerId := r.Uint32()
+		scores[playerId] = r.Int()
+	}
+	return scores
+}
+
+func (r *snapshotReader) players() []uint32 {
+	count := r.Count(1)
+	var players []uint32
+	for range count {
+		players = append(players, r.Uint32())
+	}
+	return players
+}
+
+func (r *snapshotReader) turns() turnOrder {
+	turns := turnOrder{players: r.players(), turn: r.Int()}
+	if turns.turn < 0 || (turns.turn >= len(turns.players) && turns.turn != 0) {
+		r.Fail("has turn %d of %d players", turns.turn, len(turns.players))
+		turns.turn = 0
+	}
+	return turns
+}
+
+func (r *snapshotReader) params() GameParams {
+	params := GameParams{
+		Width:          r.Int(),
+		Height:         r.Int(),
+		Mines:          r.Int(),
+		GameMode:       GameModeId(r.Int()),
+		Seed:           int64(r.Int()),
+		FirstMoveSafe:  r.Bool(),
+		NoGuess:        r.Bool(),
+		QuestionMarks:  r.Bool(),
+		TurnTime:       r.Duration(),
+		Lives:          r.Int(),
+		LivesPerPlayer: r.Bool(),
+		Topology:       TopologyId(r.Int()),
+		TimeLimit:      r.Duration(),
+	}
+	if r.Bool() {
+		width, height := r.Int(), r.Int()
+		bits := r.Bytes()
+		if r.err == nil {
+			params.Mask, r.err = MaskFromBytes(width, height, bits)
+		}
+	}
+	if r.Bool() {
+		layout := r.Bytes()
+		if r.err == nil {
+			params.Layout, r.err = DecodeBoard(layout)
+		}
+	}
+	params.Infinite = r.Bool()
+	params.AllowUndo = r.Bool()
+	return params
+}
+
+// Fails unless a board of the size fits the cells left in the data, one byte
+// each, so a broken snapshot can't make the game allocate more than it holds
+func (r *snapshotReader) boardSize(width, height int) {
+	if r.err != nil {
+		return
+	}
+	if width <= 0 || height <= 0 || width > r.Len() || height > r.Len()/width {
+		r.Fail("has a board of size (%d, %d) for %d bytes", width, height, r.Len())
+	}
+}
+
+// Restores the cells of a board created from the params of the snapshot
+func (r *snapshotReader) board(board *Board) {
+	safeFirstMove := r.Bool()
+	noGuess := r.Bool()
+	if board.Infinite() {
+		count := r.Count(2 + ChunkSize*ChunkSize)
+		chunks := make(map[ChunkPos][]byte, count)
+		for range count {
+			chunk := ChunkPos{r.Int(), r.Int()}
+			cells := r.Raw(ChunkSize * ChunkSize)
+			origin := chunk.Origin()
+			if !validChunkedPosition(origin.X, origin.Y) {
+				r.Fail("has chunk %v out of range", chunk)
+			}
+			chunks[chunk] = slices.Clone(cells)
+		}
+		if r.err != nil {
+			return
+		}
+		board.chunks.chunks = chunks
+		board.Mines, board.RevealedCells = 0, 0
+		for _, cells := range chunks {
+			for _, flags := range cells {
+				if flags&cellMine != 0 {
+					board.Mines++
+				} else if flags&cellRevealed != 0 {
+					board.RevealedCells++
+				}
+			}
+		}
+		board.safeFirstMove = safeFirstMove
+		return
+	}
+	cells := r.Bytes()
+	if r.err != nil {
+		return
+	}
+	if len(cells) != len(board.cells) {
+		r.Fail("has %d cells for a board of %d", len(cells), len(board.cells))
+		return
+	}
+	for index, flags := range cells {
+		// The shape comes from the params and disabled cells stay empty
+		if flags&^snapshotCellFlags != 0 || flags&cellDisabled != board.cells[index]&cellDisabled || (flags&cellDisabled != 0 && flags != cellDisabled) {
+			r.Fail("has invalid cell %d", index)
+			return
+		}
+	}
+	board.cells = slices.Clone(cells)
+	board.changed = nil
+	board.Mines, board.RevealedCells = 0, 0
+	for index := range board.cells {
+		if board.has(index, cellMine) {
+			board.Mines++
+		}
+		if board.revealedSafe(index) {
+			board.RevealedCells++
+		}
+		if board.has(index, cellRevealed|cellFlagged|cellQuestioned) {
+			board.track(index)
+		}
+	}
+	board.counts = nil
+	board.mineCounts()
+	board.safeFirstMove, board.noGuess = safeFirstMove, noGuess
+}
+
+func (r *snapshotReader) outcome() *GameOutcome {
+	outcome := &GameOutcome{
+		Reason:   EndReason(r.Int()),
+		PlayerId: r.Uint32(),
+		Winners:  r.players(),
+	}
+	count := r.Count(2)
+	for range count {
+		outcome.Placements = append(outcome.Placements, PlayerPlacement{PlayerId: r.Uint32(), Place: r.Int()})
+	}
+	outcome.Elapsed = r.Duration()
+	return outcome
+}
@@ -0,0 +1,122 @@
+package solver
+
+import (
+	"errors"
+
+	"github.com/tomasstrnad1997/mines/mines"
+)
+
+var ErrNoMoves = errors.New("no cells left to reveal")
+
+// Hint is a move suggested to a player or made by a bot
+type Hint struct {
+	X    int
+	Y    int
+	Type mines.MoveType
+	// Probability of the cell containing a mine
+	Probability float64
+}
+
+func (hint *Hint) Move(playerId uint32) mines.Move {
+	return mines.Move{X: hint.X, Y: hint.Y, Type: hint.Type, PlayerId: playerId}
+}
+
+// BestMove suggests revealing a certainly safe cell, otherwise flagging a
+// certain mine and only when nothing is certain revealing the cell least
+// likely to contain a mine.
+func BestMove(view *View) (*Hint, error) {
+	result, err := Solve(view)
+	if err != nil {
+		return nil, err
+	}
+	for _, pos := range result.Safe {
+		if view.states[view.index(pos.X, pos.Y)] == hidden {
+			return &Hint{X: pos.X, Y: pos.Y, Type: mines.Reveal}, nil
+		}
+	}
+	for _, pos := range result.Mines {
+		if view.states[view.index(pos.X, pos.Y)] == hidden {
+			return &Hint{X: pos.X, Y: pos.Y, Type: mines.Flag, Probability: 1}, nil
+		}
+	}
+	var best *Hint
+	for index, state := range view.states {
+		if state != hidden {
+			continue
+		}
+		pos := view.pos(index)
+		probability := result.Probabilities[pos.X][pos.Y]
+		if best == nil || probability < best.Probability {
+			best = &Hint{X: pos.X, Y: pos.Y, Type: mines.Reveal, Probability: probability}
+		}
+	}
+	if best == nil {
+		return nil, ErrNoMoves
+	}
+	return best, nil
+}
+
+// Boards without guessing are generated with the same deductions hints use
+func init() {
+	mines.NoGuessCheck = IsSolvable
+}
+
+// IsSolvable reports whether the board can be cleared from the cell (x, y)
+// without guessing. The board is only used to reveal the cells the solver finds
+// safe and is left untouched, mines it would move on its first reveal are
+// checked where they are.
+func IsSolvable(board *mines.Board, x, y int) (bool, error) {
+	clone := board.CloneLayout()
+	view, err := NewView(board.Width, board.Height, board.Mines)
+	if err != nil {
+		return false, err
+	}
+	view.Topology = board.Topology
+	if board.Mask != nil {
+		if err := view.ApplyMask(board.Mask); err != nil {
+			return false, err
+		}
+	}
+	result, err := clone.Reveal(x, y)
+	if err != nil {
+		return false, err
+	}
+	for {
+		switch result.Result {
+		case mines.MineBlown:
+			return false, nil
+		case mines.GameWon:
+			return true, nil
+		}
+		updates, err := clone.CreateCellUpdates(result.UpdatedCells)
+		if err != nil {
+			return false, err
+		}
+		if err := view.Apply(updates); err != nil {
+			return false, err
+		}
+		solved, err := Solve(view)
+		if err != nil {
+			return false, err
+		}
+		// Reveal every safe cell at once and feed the view all the changes
+		merged := &mines.MoveResult{Result: mines.NoChange}
+		for _, pos := range solved.Safe {
+			revealed, err := clone.Reveal(pos.X, pos.Y)
+			if err != nil {
+				return false, err
+			}
+			if revealed.Result != mines.NoChange {
+				merged.Result = revealed.Result
+				merged.UpdatedCells = append(merged.UpdatedCells, revealed.UpdatedCells...)
+			}
+			if revealed.Result == mines.GameWon || revealed.Result == mines.MineBlown {
+				break
+			}
+		}
+		if merged.Result == mines.NoChange {
+			return false, nil
+		}
+		result = merged
+	}
+}
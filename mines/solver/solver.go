@@ -0,0 +1,519 @@
+// Package solver deduces safe cells, mines and mine probabilities from what a
+// player can see of a board. It only ever looks at revealed numbers and marks
+// sent to players, never at the hidden mine layout.
+package solver
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tomasstrnad1997/mines/mines"
+)
+
+// Limit of backtracking steps spent on one group of connected frontier cells
+const maxEnumerationSteps = 1 << 20
+
+type cellState byte
+
+const (
+	hidden cellState = iota
+	flagged
+	revealed
+	knownMine
+	// Not part of the board shape
+	disabled
+)
+
+type Pos struct {
+	X int
+	Y int
+}
+
+// View is the part of a board a player can see
+type View struct {
+	Width  int
+	Height int
+	// Total number of mines on the board
+	Mines int
+	// Neighbourhood of the board's cells, nil for the square grid
+	Topology mines.Topology
+	states   []cellState
+	numbers  []int
+}
+
+type Result struct {
+	// Cells that can't contain a mine
+	Safe []Pos
+	// Cells that must contain a mine
+	Mines []Pos
+	// Mine probability of every cell indexed [x][y]. Revealed cells have 0
+	// and revealed mines 1.
+	Probabilities [][]float64
+}
+
+func NewView(width, height, mines int) (*View, error) {
+	if width <= 0 || height <= 0 || mines < 0 || mines > width*height {
+		return nil, fmt.Errorf("Invalid view dimensions (%d, %d) with %d mines", width, height, mines)
+	}
+	return &View{
+		Width:   width,
+		Height:  height,
+		Mines:   mines,
+		states:  make([]cellState, width*height),
+		numbers: make([]int, width*height),
+	}, nil
+}
+
+// Applies cell updates in the form they are sent to players
+func (view *View) Apply(updates []mines.UpdatedCell) error {
+	for _, update := range updates {
+		if update.X < 0 || update.X >= view.Width || update.Y < 0 || update.Y >= view.Height {
+			return fmt.Errorf("Cell update out of view bounds: (%d, %d)", update.X, update.Y)
+		}
+		index := view.index(update.X, update.Y)
+		if update.Value&0xF0 == 0 {
+			view.states[index] = revealed
+			view.numbers[index] = int(update.Value)
+			continue
+		}
+		switch update.Value {
+		case mines.ShowMine:
+			view.states[index] = knownMine
+		case mines.ShowFlag:
+			view.states[index] = flagged
+		case mines.Unflag, mines.ShowQuestion:
+			view.states[index] = hidden
+		default:
+			return fmt.Errorf("Unknown cell update value: %x", update.Value)
+		}
+	}
+	return nil
+}
+
+// Excludes the cells disabled by the mask of the board from the view
+func (view *View) ApplyMask(mask *mines.Mask) error {
+	if mask.Width != view.Width || mask.Height != view.Height {
+		return fmt.Errorf("Mask of size (%d, %d) doesn't fit view (%d, %d)", mask.Width, mask.Height, view.Width, view.Height)
+	}
+	if view.Mines > mask.PlayableCells() {
+		return fmt.Errorf("Mask leaves %d cells for %d mines", mask.PlayableCells(), view.Mines)
+	}
+	for x := range view.Width {
+		for y := range view.Height {
+			if !mask.Enabled(x, y) {
+				view.states[view.index(x, y)] = disabled
+			}
+		}
+	}
+	return nil
+}
+
+func (view *View) index(x, y int) int {
+	return x + y*view.Width
+}
+
+func (view *View) pos(index int) Pos {
+	return Pos{index % view.Width, index / view.Width}
+}
+
+// Cells that are neither revealed nor known to be a mine. Flags are placed by
+// players and may be wrong so flagged cells are unknown as well.
+func (view *View) unknown(index int) bool {
+	return view.states[index] == hidden || view.states[index] == flagged
+}
+
+func (view *View) neighbours(index int) []int {
+	x, y := index%view.Width, index/view.Width
+	topology := view.Topology
+	if topology == nil {
+		topology, _ = mines.GetTopologyById(mines.TopologySquare)
+	}
+	var cells []int
+	for _, position := range topology.Neighbours(x, y, view.Width, view.Height) {
+		cells = append(cells, view.index(position.X, position.Y))
+	}
+	return cells
+}
+
+type constraint struct {
+	cells []int
+	mines int
+}
+
+// Solutions of one group of frontier cells that share constraints
+type component struct {
+	cells []int
+	// counts[k] is the number of valid assignments with k mines
+	counts []float64
+	// mineCounts[k][i] is in how many of those assignments cells[i] is a mine
+	mineCounts [][]float64
+	// Set when the enumeration ran out of steps
+	exhausted bool
+}
+
+// Solve finds cells that are certainly safe or mines and the mine probability
+// of every cell.
+func Solve(view *View) (*Result, error) {
+	size := view.Width * view.Height
+	minesLeft := view.Mines
+	var constraints []constraint
+	onFrontier := make([]bool, size)
+	for index, state := range view.states {
+		if state == knownMine {
+			minesLeft--
+		}
+		if state != revealed {
+			continue
+		}
+		c := constraint{mines: view.numbers[index]}
+		for _, n := range view.neighbours(index) {
+			if view.states[n] == knownMine {
+				c.mines--
+			} else if view.unknown(n) {
+				c.cells = append(c.cells, n)
+			}
+		}
+		if c.mines < 0 || c.mines > len(c.cells) {
+			return nil, fmt.Errorf("View is inconsistent around (%d, %d)", index%view.Width, index/view.Width)
+		}
+		if len(c.cells) > 0 {
+			constraints = append(constraints, c)
+			for _, cell := range c.cells {
+				onFrontier[cell] = true
+			}
+		}
+	}
+	if minesLeft < 0 {
+		return nil, fmt.Errorf("View shows more mines than the board has")
+	}
+
+	var interior []int
+	for index := range size {
+		if view.unknown(index) && !onFrontier[index] {
+			interior = append(interior, index)
+		}
+	}
+	var components []*component
+	for _, comp := range splitComponents(size, constraints) {
+		comp.enumerate(constraints)
+		// Groups too large to enumerate are treated like cells without any
+		// revealed neighbour which keeps the probabilities approximate
+		if comp.exhausted {
+			interior = append(interior, comp.cells...)
+		} else {
+			components = append(components, comp)
+		}
+	}
+
+	probabilities := make([]float64, size)
+	for index, state := range view.states {
+		if state == knownMine {
+			probabilities[index] = 1
+		}
+	}
+	certainMine := make([]bool, size)
+	certainSafe := make([]bool, size)
+	if err := combine(components, interior, minesLeft, probabilities, certainSafe, certainMine); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Probabilities: make([][]float64, view.Width)}
+	for x := range view.Width {
+		result.Probabilities[x] = make([]float64, view.Height)
+		for y := range view.Height {
+			result.Probabilities[x][y] = probabilities[view.index(x, y)]
+		}
+	}
+	for index := range size {
+		if certainSafe[index] {
+			result.Safe = append(result.Safe, view.pos(index))
+		} else if certainMine[index] {
+			result.Mines = append(result.Mines, view.pos(index))
+		}
+	}
+	return result, nil
+}
+
+// Groups frontier cells connected through constraints
+func splitComponents(size int, constraints []constraint) []*component {
+	parent := make([]int, size)
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	for _, c := range constraints {
+		for _, cell := range c.cells[1:] {
+			parent[find(cell)] = find(c.cells[0])
+		}
+	}
+	byRoot := make(map[int]*component)
+	var components []*component
+	seen := make([]bool, size)
+	for _, c := range constraints {
+		for _, cell := range c.cells {
+			if seen[cell] {
+				continue
+			}
+			seen[cell] = true
+			root := find(cell)
+			comp, ok := byRoot[root]
+			if !ok {
+				comp = &component{}
+				byRoot[root] = comp
+				components = append(components, comp)
+			}
+			comp.cells = append(comp.cells, cell)
+		}
+	}
+	return components
+}
+
+// Counts all mine assignments of the component cells that satisfy every
+// constraint touching them.
+func (comp *component) enumerate(constraints []constraint) {
+	local := make(map[int]int, len(comp.cells))
+	for i, cell := range comp.cells {
+		local[cell] = i
+	}
+	type localConstraint struct {
+		cells []int
+		mines int
+	}
+	var own []localConstraint
+	cellConstraints := make([][]int, len(comp.cells))
+	for _, c := range constraints {
+		if _, ok := local[c.cells[0]]; !ok {
+			continue
+		}
+		lc := localConstraint{mines: c.mines}
+		for _, cell := range c.cells {
+			lc.cells = append(lc.cells, local[cell])
+			cellConstraints[local[cell]] = append(cellConstraints[local[cell]], len(own))
+		}
+		own = append(own, lc)
+	}
+
+	n := len(comp.cells)
+	comp.counts = make([]float64, n+1)
+	comp.mineCounts = make([][]float64, n+1)
+	for k := range comp.mineCounts {
+		comp.mineCounts[k] = make([]float64, n)
+	}
+	assignment := make([]int, n)
+	// Remaining mines and unassigned cells of each constraint
+	needed := make([]int, len(own))
+	free := make([]int, len(own))
+	for i, c := range own {
+		needed[i] = c.mines
+		free[i] = len(c.cells)
+	}
+	steps := 0
+	mineTotal := 0
+	var search func(cell int)
+	search = func(cell int) {
+		if steps > maxEnumerationSteps {
+			comp.exhausted = true
+			return
+		}
+		steps++
+		if cell == n {
+			comp.counts[mineTotal]++
+			for i, value := range assignment {
+				comp.mineCounts[mineTotal][i] += float64(value)
+			}
+			return
+		}
+		for value := 0; value <= 1; value++ {
+			valid := true
+			for _, ci := range cellConstraints[cell] {
+				needed[ci] -= value
+				free[ci]--
+				if needed[ci] < 0 || needed[ci] > free[ci] {
+					valid = false
+				}
+			}
+			if valid {
+				assignment[cell] = value
+				mineTotal += value
+				search(cell + 1)
+				mineTotal -= value
+			}
+			for _, ci := range cellConstraints[cell] {
+				needed[ci] += value
+				free[ci]++
+			}
+			if comp.exhausted {
+				return
+			}
+		}
+	}
+	search(0)
+}
+
+func logBinomial(n, k int) float64 {
+	if k < 0 || k > n {
+		return math.Inf(-1)
+	}
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+	return a - b - c
+}
+
+// Convolves the solution counts of components into counts by total mines
+func convolve(components []*component, skip int) []float64 {
+	total := []float64{1}
+	for i, comp := range components {
+		if i == skip {
+			continue
+		}
+		next := make([]float64, len(total)+len(comp.counts)-1)
+		for a, ca := range total {
+			if ca == 0 {
+				continue
+			}
+			for b, cb := range comp.counts {
+				next[a+b] += ca * cb
+			}
+		}
+		total = normalize(next)
+	}
+	return total
+}
+
+// Scales counts to keep them in float range. Only ratios of counts matter.
+func normalize(counts []float64) []float64 {
+	largest := 0.0
+	for _, c := range counts {
+		largest = math.Max(largest, c)
+	}
+	if largest > 1e200 {
+		for i := range counts {
+			counts[i] /= largest
+		}
+	}
+	return counts
+}
+
+// Relative weights of layouts where the frontier holds k mines and the rest is
+// spread over the interior cells in every possible way.
+func layoutWeights(frontier []float64, interior, minesLeft int) []float64 {
+	logs := make([]float64, len(frontier))
+	largest := math.Inf(-1)
+	for k, count := range frontier {
+		logs[k] = math.Inf(-1)
+		if count > 0 {
+			logs[k] = math.Log(count) + logBinomial(interior, minesLeft-k)
+		}
+		largest = math.Max(largest, logs[k])
+	}
+	weights := make([]float64, len(frontier))
+	if math.IsInf(largest, -1) {
+		return weights
+	}
+	for k := range logs {
+		weights[k] = math.Exp(logs[k] - largest)
+	}
+	return weights
+}
+
+func logSumExp(logs []float64) float64 {
+	largest := math.Inf(-1)
+	for _, l := range logs {
+		largest = math.Max(largest, l)
+	}
+	if math.IsInf(largest, -1) {
+		return largest
+	}
+	sum := 0.0
+	for _, l := range logs {
+		sum += math.Exp(l - largest)
+	}
+	return largest + math.Log(sum)
+}
+
+// Relative weights of the component holding k mines given every possible
+// layout of the other components and the interior cells.
+func componentWeights(components []*component, ci, interior, minesLeft int) []float64 {
+	others := convolve(components, ci)
+	logs := make([]float64, len(components[ci].counts))
+	largest := math.Inf(-1)
+	for k := range logs {
+		terms := make([]float64, 0, len(others))
+		for j, count := range others {
+			if count > 0 {
+				terms = append(terms, math.Log(count)+logBinomial(interior, minesLeft-k-j))
+			}
+		}
+		logs[k] = logSumExp(terms)
+		largest = math.Max(largest, logs[k])
+	}
+	weights := make([]float64, len(logs))
+	if math.IsInf(largest, -1) {
+		return weights
+	}
+	for k := range logs {
+		weights[k] = math.Exp(logs[k] - largest)
+	}
+	return weights
+}
+
+func combine(components []*component, interior []int, minesLeft int, probabilities []float64, safe, mine []bool) error {
+	weights := layoutWeights(convolve(components, -1), len(interior), minesLeft)
+	totalWeight := 0.0
+	for _, w := range weights {
+		totalWeight += w
+	}
+	if totalWeight == 0 {
+		return fmt.Errorf("View has no valid mine layout")
+	}
+
+	if len(interior) > 0 {
+		expected := 0.0
+		possibleMine, possibleSafe := false, false
+		for k, w := range weights {
+			if w == 0 {
+				continue
+			}
+			left := minesLeft - k
+			expected += w * float64(left) / float64(len(interior))
+			possibleMine = possibleMine || left > 0
+			possibleSafe = possibleSafe || left < len(interior)
+		}
+		for _, index := range interior {
+			probabilities[index] = expected / totalWeight
+			safe[index] = !possibleMine
+			mine[index] = !possibleSafe
+		}
+	}
+
+	for ci, comp := range components {
+		total := 0.0
+		mineWeight := make([]float64, len(comp.cells))
+		for k, w := range componentWeights(components, ci, len(interior), minesLeft) {
+			if w == 0 || comp.counts[k] == 0 {
+				continue
+			}
+			total += w * comp.counts[k]
+			for i := range comp.cells {
+				mineWeight[i] += w * comp.mineCounts[k][i]
+			}
+		}
+		if total == 0 {
+			return fmt.Errorf("View has no valid mine layout")
+		}
+		for i, cell := range comp.cells {
+			probabilities[cell] = mineWeight[i] / total
+			safe[cell] = mineWeight[i] == 0
+			mine[cell] = mineWeight[i] == total
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package solver_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tomasstrnad1997/mines/mines"
+	"github.com/tomasstrnad1997/mines/mines/solver"
+)
+
+func revealedView(t *testing.T, board *mines.Board) *solver.View {
+	t.Helper()
+	view, err := solver.NewView(board.Width, board.Height, board.Mines)
+	if err != nil {
+		t.Fatalf("Failed to create view: %v", err)
+	}
+	updates, err := board.GetChangedCellUpdates()
+	if err != nil {
+		t.Fatalf("Failed to get cell updates: %v", err)
+	}
+	if err := view.Apply(updates); err != nil {
+		t.Fatalf("Failed to apply updates: %v", err)
+	}
+	return view
+}
+
+func TestUniformProbabilities(t *testing.T) {
+	view, err := solver.NewView(4, 4, 4)
+	if err != nil {
+		t.Fatalf("Failed to create view: %v", err)
+	}
+	result, err := solver.Solve(view)
+	if err != nil {
+		t.Fatalf("Failed to solve: %v", err)
+	}
+	for x := range 4 {
+		for y := range 4 {
+			if math.Abs(result.Probabilities[x][y]-0.25) > 1e-9 {
+				t.Fatalf("Probability of (%d, %d) is %f instead of 0.25", x, y, result.Probabilities[x][y])
+			}
+		}
+	}
+}
+
+func TestOneOnePattern(t *testing.T) {
+	// Bottom row revealed as |1|1|0| with a single mine on the board, the
+	// cell above the 0 and the one next to it are safe.
+	view, err := solver.NewView(3, 2, 1)
+	if err != nil {
+		t.Fatalf("Failed to create view: %v", err)
+	}
+	err = view.Apply([]mines.UpdatedCell{
+		{X: 0, Y: 1, Value: 1},
+		{X: 1, Y: 1, Value: 1},
+		{X: 2, Y: 1, Value: 0},
+	})
+	if err != nil {
+		t.Fatalf("Failed to apply updates: %v", err)
+	}
+	result, err := solver.Solve(view)
+	if err != nil {
+		t.Fatalf("Failed to solve: %v", err)
+	}
+	if len(result.Mines) != 1 || result.Mines[0] != (solver.Pos{X: 0, Y: 0}) {
+		t.Fatalf("Expected a certain mine at (0, 0), got %v", result.Mines)
+	}
+	if len(result.Safe) != 2 {
+		t.Fatalf("Expected two safe cells, got %v", result.Safe)
+	}
+}
+
+func TestSolverNeverContradictsBoard(t *testing.T) {
+	for seed := range int64(50) {
+		board, err := mines.CreateBoardFromParams(mines.GameParams{Width: 16, Height: 16, Mines: 40, Seed: seed, FirstMoveSafe: true})
+		if err != nil {
+			t.Fatalf("Failed to create board: %v", err)
+		}
+		if _, err := board.Reveal(8, 8); err != nil {
+			t.Fatalf("Failed to reveal: %v", err)
+		}
+		result, err := solver.Solve(revealedView(t, board))
+		if err != nil {
+			t.Fatalf("Failed to solve seed %d: %v", seed, err)
+		}
+		for _, pos := range result.Safe {
+			if board.Cell(pos.X, pos.Y).Mine {
+				t.Fatalf("Seed %d: cell (%d, %d) reported safe holds a mine", seed, pos.X, pos.Y)
+			}
+		}
+		for _, pos := range result.Mines {
+			if !board.Cell(pos.X, pos.Y).Mine {
+				t.Fatalf("Seed %d: cell (%d, %d) reported as mine is safe", seed, pos.X, pos.Y)
+			}
+		}
+	}
+}
+
+func TestNoGuessBoardsAreSolvable(t *testing.T) {
+	for seed := range int64(10) {
+		board, err := mines.GenerateNoGuessBoard(16, 16, 40, seed, 5, 5, mines.NoGuessBudget{MaxAttempts: 5000})
+		if err != nil {
+			t.Fatalf("Failed to generate board: %v", err)
+		}
+		solvable, err := solver.IsSolvable(board, 5, 5)
+		if err != nil {
+			t.Fatalf("Solvability check failed: %v", err)
+		}
+		if !solvable {
+			t.Fatalf("Seed %d: no guess board reported as unsolvable", seed)
+		}
+		if board.RevealedCells != 0 {
+			t.Fatalf("Solvability check modified the board")
+		}
+	}
+}
+
+func TestBotClearsNoGuessBoard(t *testing.T) {
+	board, err := mines.GenerateNoGuessBoard(9, 9, 10, 1, 4, 4, mines.NoGuessBudget{MaxAttempts: 5000})
+	if err != nil {
+		t.Fatalf("Failed to generate board: %v", err)
+	}
+	result, err := board.Reveal(4, 4)
+	if err != nil {
+		t.Fatalf("Failed to reveal: %v", err)
+	}
+	for result.Result != mines.GameWon {
+		hint, err := solver.BestMove(revealedView(t, board))
+		if err != nil {
+			t.Fatalf("Failed to find a move: %v", err)
+		}
+		if hint.Type == mines.Reveal && hint.Probability != 0 {
+			t.Fatalf("Bot had to guess on a no guess board")
+		}
+		if hint.Type == mines.Reveal {
+			result, err = board.Reveal(hint.X, hint.Y)
+		} else {
+			result, err = board.Flag(hint.X, hint.Y)
+		}
+		if err != nil {
+			t.Fatalf("Failed to make move: %v", err)
+		}
+		if result.Result == mines.MineBlown {
+			t.Fatalf("Bot blew a mine at (%d, %d)", hint.X, hint.Y)
+		}
+	}
+}
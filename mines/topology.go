@@ -0,0 +1,124 @@
+package mines
+
+import "fmt"
+
+type TopologyId byte
+
+const (
+	TopologySquare TopologyId = 0
+	// Rows of hexagons where every odd row is shifted right by half a cell
+	TopologyHex TopologyId = 1
+	// Square grid whose edges wrap around to the opposite side
+	TopologyTorus TopologyId = 2
+	// Cells neighbour the cells a chess knight can move to
+	TopologyKnight TopologyId = 3
+)
+
+var TopologyNames = map[TopologyId]string{
+	TopologySquare: "Square",
+	TopologyHex:    "Hexagonal",
+	TopologyTorus:  "Torus",
+	TopologyKnight: "Knight",
+}
+
+type Position struct {
+	X int
+	Y int
+}
+
+// Topology decides which cells of a board neighbour each other
+type Topology interface {
+	Id() TopologyId
+	// Returns the neighbours of (x, y) on a board of the given size without
+	// the cell itself. Every neighbour is listed once.
+	Neighbours(x, y, width, height int) []Position
+}
+
+func GetTopologyById(id TopologyId) (Topology, error) {
+	switch id {
+	case TopologySquare:
+		return squareTopology{}, nil
+	case TopologyHex:
+		return hexTopology{}, nil
+	case TopologyTorus:
+		return torusTopology{}, nil
+	case TopologyKnight:
+		return knightTopology{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown topology id: %d", id)
+	}
+}
+
+// Returns the positions of the offsets from (x, y) that are on the board
+func offsetNeighbours(x, y, width, height int, offsets [][2]int) []Position {
+	var positions []Position
+	for _, offset := range offsets {
+		nx, ny := x+offset[0], y+offset[1]
+		if nx >= 0 && nx < width && ny >= 0 && ny < height {
+			positions = append(positions, Position{nx, ny})
+		}
+	}
+	return positions
+}
+
+var squareOffsets = [][2]int{{-1, -1}, {-1, 0}, {-1, 1}, {0, -1}, {0, 1}, {1, -1}, {1, 0}, {1, 1}}
+
+type squareTopology struct{}
+
+func (squareTopology) Id() TopologyId {
+	return TopologySquare
+}
+
+func (squareTopology) Neighbours(x, y, width, height int) []Position {
+	return offsetNeighbours(x, y, width, height, squareOffsets)
+}
+
+var (
+	hexEvenRowOffsets = [][2]int{{-1, 0}, {1, 0}, {-1, -1}, {0, -1}, {-1, 1}, {0, 1}}
+	hexOddRowOffsets  = [][2]int{{-1, 0}, {1, 0}, {0, -1}, {1, -1}, {0, 1}, {1, 1}}
+)
+
+type hexTopology struct{}
+
+func (hexTopology) Id() TopologyId {
+	return TopologyHex
+}
+
+func (hexTopology) Neighbours(x, y, width, height int) []Position {
+	if y%2 == 0 {
+		return offsetNeighbours(x, y, width, height, hexEvenRowOffsets)
+	}
+	return offsetNeighbours(x, y, width, height, hexOddRowOffsets)
+}
+
+type torusTopology struct{}
+
+func (torusTopology) Id() TopologyId {
+	return TopologyTorus
+}
+
+func (torusTopology) Neighbours(x, y, width, height int) []Position {
+	var positions []Position
+	seen := map[Position]bool{{x, y}: true}
+	for _, offset := range squareOffsets {
+		// Small boards wrap onto the same cells more than once
+		position := Position{(x + offset[0] + width) % width, (y + offset[1] + height) % height}
+		if !seen[position] {
+			seen[position] = true
+			positions = append(positions, position)
+		}
+	}
+	return positions
+}
+
+var knightOffsets = [][2]int{{-2, -1}, {-2, 1}, {-1, -2}, {-1, 2}, {1, -2}, {1, 2}, {2, -1}, {2, 1}}
+
+type knightTopology struct{}
+
+func (knightTopology) Id() TopologyId {
+	return TopologyKnight
+}
+
+func (knightTopology) Neighbours(x, y, width, height int) []Position {
+	return offsetNeighbours(x, y, width, height, knightOffsets)
+}
@@ -0,0 +1,130 @@
+package mines
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrUndoNotAllowed = errors.New("undo is not allowed in this game")
+var ErrNothingToUndo = errors.New("no moves to undo")
+
+// Flags of a cell before a move changed them
+type cellChange struct {
+	index int
+	flags byte
+}
+
+// Everything a move changed on a board. Cells are recorded before each change
+// so reverting them in reverse order restores the board as it was.
+type boardUndo struct {
+	changes       []cellChange
+	revealedCells int
+	mines         int
+	seed          int64
+	safeFirstMove bool
+	noGuess       bool
+}
+
+// A move of a game that allows undo with what it changed
+type moveRecord struct {
+	board *Board
+	undo  *boardUndo
+	// State of the game before the move
+	outcome   *GameOutcome
+	startTime time.Time
+	endTime   time.Time
+	// The move ended the game
+	endedGame bool
+}
+
+type UndoResult struct {
+	// Number of moves taken back
+	Moves int
+	// Updates of the reverted cells. Cells that got hidden again show as Unflag.
+	UpdatedCells []UpdatedCell
+	// Changes of the gamemode to send, one for every move that had any
+	Infos []GamemodeUpdateInfo
+}
+
+// Starts recording the changes of the next move
+func (board *Board) startRecording() {
+	board.journal = &boardUndo{
+		revealedCells: board.RevealedCells,
+		mines:         board.Mines,
+		seed:          board.seed,
+		safeFirstMove: board.safeFirstMove,
+		noGuess:       board.noGuess,
+	}
+}
+
+// Stops recording and returns the changes recorded since startRecording
+func (board *Board) stopRecording() *boardUndo {
+	undo := board.journal
+	board.journal = nil
+	return undo
+}
+
+// Remembers the flags of the cell before they get changed
+func (board *Board) record(index int) {
+	if board.journal != nil {
+		board.journal.changes = append(board.journal.changes, cellChange{index, board.cells[index]})
+	}
+}
+
+// Reverts the recorded changes and returns the indices of the reverted cells
+func (board *Board) revert(undo *boardUndo) []int {
+	indices := make([]int, 0, len(undo.changes))
+	for _, change := range slices.Backward(undo.changes) {
+		// Mines first so the cached counts follow mines moved by a first move
+		board.setMine(change.index, change.flags&cellMine != 0)
+		board.cells[change.index] = change.flags | board.cells[change.index]&cellTracked
+		indices = append(indices, change.index)
+	}
+	board.RevealedCells, board.Mines, board.seed = undo.revealedCells, undo.mines, undo.seed
+	board.safeFirstMove, board.noGuess = undo.safeFirstMove, undo.noGuess
+	slices.Sort(indices)
+	return slices.Compact(indices)
+}
+
+// Number of moves that can be taken back
+func (game *Game) UndoableMoves() int {
+	return len(game.journal)
+}
+
+// Undo takes back up to the given number of the last moves that changed the
+// board, also the move that ended the game. A game that ended any other way,
+// e.g. by running out of time, can't be undone.
+func (game *Game) Undo(moves int) (*UndoResult, error) {
+	mode, ok := game.Mode.(Rewindable)
+	if !ok || !game.Params.AllowUndo {
+		return nil, ErrUndoNotAllowed
+	}
+	if len(game.journal) == 0 || moves <= 0 {
+		return nil, ErrNothingToUndo
+	}
+	if game.IsOver() && !game.journal[len(game.journal)-1].endedGame {
+		return nil, ErrNothingToUndo
+	}
+	moves = min(moves, len(game.journal))
+	result := &UndoResult{Moves: moves}
+	var board *Board
+	var reverted []int
+	for range moves {
+		record := game.journal[len(game.journal)-1]
+		game.journal = game.journal[:len(game.journal)-1]
+		board = record.board
+		reverted = append(reverted, board.revert(record.undo)...)
+		if info := mode.UndoMove(); info != nil {
+			result.Infos = append(result.Infos, info)
+		}
+		game.outcome, game.startTime, game.endTime = record.outcome, record.startTime, record.endTime
+	}
+	slices.Sort(reverted)
+	updates, err := board.CreateCellUpdates(board.snapshot(slices.Compact(reverted)))
+	if err != nil {
+		return nil, err
+	}
+	result.UpdatedCells = updates
+	return result, nil
+}
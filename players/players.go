@@ -21,6 +21,8 @@ type AuthToken struct {
 	ServerID  uint32
 	Nonce     [16]byte
 	Signature [32]byte
+	// The holder may only watch the game
+	Spectator bool
 }
 
 type PlayerInfo struct {
@@ -28,7 +30,7 @@ type PlayerInfo struct {
 	Name string
 }
 
-const AuthTokenLength = 4 + 4 + 8 + 16 + 32
+const AuthTokenLength = 4 + 4 + 8 + 16 + 32 + 1
 
 var (
 	ErrTokenExpired     = errors.New("token has expired")
@@ -75,12 +77,21 @@ func checkPasswordHash(password, hash string) bool {
 }
 
 func GenerateAuthToken(player *Player, serverID uint32, secret []byte, ttl time.Duration) (AuthToken, error) {
+	return generateToken(player, serverID, secret, ttl, false)
+}
+
+// Same as GenerateAuthToken for a player that may only watch the game
+func GenerateSpectatorToken(player *Player, serverID uint32, secret []byte, ttl time.Duration) (AuthToken, error) {
+	return generateToken(player, serverID, secret, ttl, true)
+}
+
+func generateToken(player *Player, serverID uint32, secret []byte, ttl time.Duration, spectator bool) (AuthToken, error) {
 	expiration := time.Now().Add(ttl).Unix()
 	var nonce [16]byte
 	if _, err := rand.Read(nonce[:]); err != nil {
 		return AuthToken{}, err
 	}
-	toSign := constructSignatureData(player.ID, serverID, nonce, expiration)
+	toSign := constructSignatureData(player.ID, serverID, nonce, expiration, spectator)
 	signature, err := calculateSignature(toSign, secret)
 	if err != nil {
 		return AuthToken{}, err
@@ -91,16 +102,20 @@ func GenerateAuthToken(player *Player, serverID uint32, secret []byte, ttl time.
 		Expiry:    expiration,
 		Nonce:     nonce,
 		Signature: [32]byte(signature),
+		Spectator: spectator,
 	}, nil
 }
 
-func constructSignatureData(playerID, serverID uint32, nonce [16]byte, expiration int64) []byte {
-	// playerID + serverID + expiration + nonce
-	data := make([]byte, 4+4+8+16)
+func constructSignatureData(playerID, serverID uint32, nonce [16]byte, expiration int64, spectator bool) []byte {
+	// playerID + serverID + expiration + nonce + spectator
+	data := make([]byte, 4+4+8+16+1)
 	binary.BigEndian.PutUint32(data[0:4], playerID)
 	binary.BigEndian.PutUint32(data[4:8], serverID)
 	binary.BigEndian.PutUint64(data[8:16], uint64(expiration))
-	copy(data[16:], nonce[:])
+	copy(data[16:32], nonce[:])
+	if spectator {
+		data[32] = 1
+	}
 	return data
 
 }
@@ -109,7 +124,7 @@ func ValidateAuthToken(token AuthToken, secret []byte) (bool, error) {
 	if time.Now().Unix() > token.Expiry {
 		return false, ErrTokenExpired
 	}
-	toVerify := constructSignatureData(token.PlayerID, token.ServerID, token.Nonce, token.Expiry)
+	toVerify := constructSignatureData(token.PlayerID, token.ServerID, token.Nonce, token.Expiry, token.Spectator)
 	expectedSignature, err := calculateSignature(toVerify, secret)
 	if err != nil {
 		return false, ErrInvalidFormat
@@ -62,3 +62,30 @@ func TestTokenModification(t *testing.T) {
 		t.Fatalf("Expected ErrInvalidSignature, got: %v", err)
 	}
 }
+
+func TestSpectatorToken(t *testing.T) {
+	secret := []byte("SECRET TOKEN")
+	var serverID uint32 = 234
+	player := players.Player{
+		ID: 1235,
+	}
+	token, err := players.GenerateSpectatorToken(&player, serverID, secret, time.Minute*10)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if !token.Spectator {
+		t.Fatalf("Spectator token does not grant the spectator role")
+	}
+	success, err := players.ValidateAuthToken(token, secret)
+	if !success {
+		t.Fatalf("Verification failed: %v", err)
+	}
+	token.Spectator = false
+	success, err = players.ValidateAuthToken(token, secret)
+	if success {
+		t.Fatalf("Token with a removed spectator role was validated as success: %v", err)
+	}
+	if !errors.Is(err, players.ErrInvalidSignature) {
+		t.Fatalf("Expected ErrInvalidSignature, got: %v", err)
+	}
+}
@@ -3,9 +3,11 @@ package protocol
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -22,11 +24,40 @@ type Handler interface {
 type ConnectionController struct {
 	server net.Conn
 	messageHandlers map[MessageType]MessageHandler
-	messageChannel chan []byte
+	messageChannel chan outgoingMessage
 	Connected bool
 	host string
 	port uint16
 	AttemptReconnect bool
+	// Oldest protocol version accepted from the peer. Peers that send no
+	// handshake count as version 0.
+	MinVersion uint16
+	// Features offered to the peer in the handshake
+	Features Features
+	// Version and features agreed on in the handshake
+	peer HandshakeInfo
+	peerMux sync.Mutex
+	handshakeDone bool
+	// This side dialed the connection and starts the handshake
+	dialed bool
+	// Closed once the handshake lets queued messages be written
+	ready chan struct{}
+	readyOnce sync.Once
+}
+
+// Message waiting to be written. Peers without the features get the fallback
+// instead, nothing when it is nil.
+type outgoingMessage struct {
+	message  []byte
+	features Features
+	fallback []byte
+}
+
+// Version and features both peers speak, set once the handshake is done
+func (controller *ConnectionController) Peer() HandshakeInfo {
+	controller.peerMux.Lock()
+	defer controller.peerMux.Unlock()
+	return controller.peer
 }
 
 func (controller *ConnectionController) GetServerAddress() string {
@@ -39,9 +70,17 @@ func (controller *ConnectionController) GetServerAddress() string {
 
 func (controller *ConnectionController) StartWriter() {
 	go func() {
+		<-controller.ready
 		for {
 			select {
-			case message := <-controller.messageChannel:
+			case outgoing := <-controller.messageChannel:
+				message := outgoing.message
+				if !controller.Peer().Supports(outgoing.features) {
+					message = outgoing.fallback
+				}
+				if message == nil {
+					continue
+				}
 				if !controller.Connected {
 					fmt.Println("Attempted to write to not connected server")
 				}
@@ -57,8 +96,16 @@ func (controller *ConnectionController) StartWriter() {
 }
 
 func (controller *ConnectionController) SendMessage(message []byte) error{
+	return controller.SendFeatureMessage(0, message, nil)
+}
+
+// Sends a message that only peers with the features understand, the others
+// get the fallback or nothing when it is nil. It is decided when the message
+// is written, by then the side that accepted the connection knows the peer.
+func (controller *ConnectionController) SendFeatureMessage(features Features, message []byte, fallback []byte) error {
+	outgoing := outgoingMessage{message: message, features: features, fallback: fallback}
 	select {
-		case controller.messageChannel <- message:
+		case controller.messageChannel <- outgoing:
 		default:
 			return fmt.Errorf("Failed to write to message channel")
 	}
@@ -76,13 +123,16 @@ func (controller *ConnectionController) SetConnection(conn net.Conn) error {
 
 func CreateConnectionController() *ConnectionController{
 	messageHandlers := make(map[MessageType]MessageHandler)
-	channel := make(chan []byte, 64)
-	controller := &ConnectionController{messageHandlers: messageHandlers, Connected: false, messageChannel: channel}
+	channel := make(chan outgoingMessage, 64)
+	controller := &ConnectionController{messageHandlers: messageHandlers, Connected: false, messageChannel: channel, Features: SupportedFeatures, ready: make(chan struct{})}
 	controller.StartWriter()
 	return controller
 }
 
 func (controller *ConnectionController) HandleMessage(bytes []byte) error {
+	if len(bytes) < HeaderLength {
+		return fmt.Errorf("Message of %d bytes has no header: %w", len(bytes), ErrMessageTooShort)
+	}
 	msgType := MessageType(bytes[0])
 	handlerFunc, exists := controller.messageHandlers[msgType]
 	if !exists {
@@ -113,8 +163,80 @@ func (controller *ConnectionController) connect() error{
 	if err != nil {
 		return err
 	}
-	controller.Connected = true
 	controller.server = server
+	controller.dialed = true
+	controller.handshakeDone = false
+	if err := controller.sendHandshake(HandshakeInfo{Version: ProtocolVersion, Features: controller.Features}); err != nil {
+		server.Close()
+		return err
+	}
+	controller.Connected = true
+	controller.markReady()
+	return nil
+}
+
+// Writes the handshake right away, queued messages wait until it is done
+func (controller *ConnectionController) sendHandshake(info HandshakeInfo) error {
+	encoded, err := EncodeHandshake(info)
+	if err != nil {
+		return err
+	}
+	_, err = controller.server.Write(encoded)
+	return err
+}
+
+func (controller *ConnectionController) markReady() {
+	controller.readyOnce.Do(func() { close(controller.ready) })
+}
+
+// Settles the handshake with the first message of the peer. Peers that start
+// with any other message are from before handshakes and get version 0 without
+// features. Reports whether the message was a part of the handshake.
+func (controller *ConnectionController) handleHandshake(message []byte) (bool, error) {
+	controller.handshakeDone = true
+	switch MessageType(message[0]) {
+	case Handshake:
+		info, err := DecodeHandshake(message)
+		if err != nil {
+			return true, err
+		}
+		return true, controller.agree(info, true)
+	case HandshakeRejected:
+		version, reason, err := DecodeHandshakeRejected(message)
+		if err != nil {
+			return true, err
+		}
+		return true, &VersionMismatchError{Local: ProtocolVersion, Remote: version, Reason: reason}
+	}
+	return false, controller.agree(HandshakeInfo{}, false)
+}
+
+// Downgrades to what both peers speak or rejects peers older than MinVersion.
+// The side that accepted the connection answers the handshake of the peer.
+func (controller *ConnectionController) agree(info HandshakeInfo, answer bool) error {
+	agreed := HandshakeInfo{Version: min(info.Version, ProtocolVersion), Features: info.Features & controller.Features}
+	if agreed.Version < controller.MinVersion {
+		mismatch := &VersionMismatchError{
+			Local:  ProtocolVersion,
+			Remote: info.Version,
+			Reason: fmt.Sprintf("Protocol versions older than %d are not supported", controller.MinVersion),
+		}
+		if !controller.dialed {
+			if encoded, err := EncodeHandshakeRejected(ProtocolVersion, mismatch.Reason); err == nil {
+				controller.server.Write(encoded)
+			}
+		}
+		return mismatch
+	}
+	controller.peerMux.Lock()
+	controller.peer = agreed
+	controller.peerMux.Unlock()
+	if answer && !controller.dialed {
+		if err := controller.sendHandshake(agreed); err != nil {
+			return err
+		}
+	}
+	controller.markReady()
 	return nil
 }
 
@@ -144,20 +266,29 @@ func (controller *ConnectionController) readLoop() error {
 	reader := bufio.NewReader(controller.server)
 	for {
 		header := make([]byte, HeaderLength)
-		bytesRead, err := reader.Read(header)
+		_, err := io.ReadFull(reader, header)
 		if err != nil {
 			return err
 		}
-		if bytesRead != HeaderLength{
-			return fmt.Errorf("Invalid message length read\n")
-		}
 		messageLenght := int(binary.BigEndian.Uint32(header[2:HeaderLength]))
+		if messageLenght > MaxPayloadLength {
+			return fmt.Errorf("Message of %d bytes exceeds the limit of %d: %w", messageLenght, MaxPayloadLength, ErrInvalidPayloadSize)
+		}
 		message := make([]byte, messageLenght+HeaderLength)
 		copy(message[0:HeaderLength], header)
 		_, err = io.ReadFull(reader, message[HeaderLength:])
 		if err != nil {
 			return err
 		}
+		if !controller.handshakeDone {
+			handled, err := controller.handleHandshake(message)
+			if err != nil {
+				return err
+			}
+			if handled {
+				continue
+			}
+		}
 		// fmt.Printf("Recieved: 0x%X %d\n", message[0], len(message))
 		if err = controller.HandleMessage(message); err != nil {
 			return err
@@ -176,7 +307,9 @@ func (controller *ConnectionController) ReadServerResponse() error{
 			fmt.Println("Connection lost:", err)
 			controller.Connected = false
 			controller.server.Close()
-			if controller.AttemptReconnect {
+			// Reconnecting won't make the versions match
+			var mismatch *VersionMismatchError
+			if controller.AttemptReconnect && !errors.As(err, &mismatch) {
 				fmt.Println("Attempting to reconnect...")
 				controller.connectLoop()
 			}else{
@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Version of the message layouts. Bump it whenever a layout changes so peers
+// notice they can't understand each other.
+const ProtocolVersion uint16 = 1
+
+// Oldest version servers accept. Peers from before the handshake count as
+// version 0 and would misread messages added since.
+const OldestServedVersion uint16 = 1
+
+// Optional parts of the protocol a peer understands
+type Features uint32
+
+const (
+	// Optional settings of StartGame
+	FeatureGameOptions Features = 1 << iota
+	// Infinite boards sent by chunks
+	FeatureChunks
+	FeatureUndo
+	FeatureSpectators
+	FeatureReplays
+)
+
+// Every feature of this version of the protocol
+const SupportedFeatures = FeatureGameOptions | FeatureChunks | FeatureUndo | FeatureSpectators | FeatureReplays
+
+// Protocol version and features a peer speaks. After the handshake it is what
+// both peers speak, the lower version and the features they share.
+type HandshakeInfo struct {
+	Version  uint16
+	Features Features
+}
+
+func (info HandshakeInfo) Supports(features Features) bool {
+	return info.Features&features == features
+}
+
+// Returned when the peers can't agree on a protocol version, either found by
+// this side or sent by a peer that rejected the connection
+type VersionMismatchError struct {
+	// Version this side speaks
+	Local uint16
+	// Version of the peer, 0 for peers that sent no handshake
+	Remote uint16
+	Reason string
+}
+
+func (err *VersionMismatchError) Error() string {
+	return fmt.Sprintf("Protocol version mismatch (local %d, remote %d): %s", err.Local, err.Remote, err.Reason)
+}
+
+// Sent by the side that dialed the connection before anything else and
+// answered by the other side with the agreed version and features
+// |version - uint16|features - uint32|
+func EncodeHandshake(info HandshakeInfo) ([]byte, error) {
+	payload := binary.BigEndian.AppendUint16(nil, info.Version)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(info.Features))
+	return encodeRequestMessage(Handshake, payload, nil)
+}
+
+func DecodeHandshake(data []byte) (HandshakeInfo, error) {
+	payload, err := decodeRequestMessage(data, Handshake, nil)
+	if err != nil {
+		return HandshakeInfo{}, err
+	}
+	if len(payload) != 6 {
+		return HandshakeInfo{}, ErrInvalidPayloadSize
+	}
+	return HandshakeInfo{
+		Version:  binary.BigEndian.Uint16(payload[0:2]),
+		Features: Features(binary.BigEndian.Uint32(payload[2:6])),
+	}, nil
+}
+
+// Answers a handshake the peer can't be served with. The connection gets
+// closed after it.
+// |version - uint16|reason - string|
+func EncodeHandshakeRejected(version uint16, reason string) ([]byte, error) {
+	payload := binary.BigEndian.AppendUint16(nil, version)
+	return encodeRequestMessage(HandshakeRejected, append(payload, reason...), nil)
+}
+
+func DecodeHandshakeRejected(data []byte) (uint16, string, error) {
+	payload, err := decodeRequestMessage(data, HandshakeRejected, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(payload) < 2 {
+		return 0, "", ErrInvalidPayloadSize
+	}
+	return binary.BigEndian.Uint16(payload[0:2]), string(payload[2:]), nil
+}
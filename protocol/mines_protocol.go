@@ -6,27 +6,41 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"time"
 
 	"github.com/tomasstrnad1997/mines/mines"
+	"github.com/tomasstrnad1997/mines/mines/solver"
 	"github.com/tomasstrnad1997/mines/players"
 )
 
 type MessageType byte
 
 const (
-	MoveCommand   MessageType = 0x01
-	TextMessage               = 0x02
-	Board                     = 0x03
-	StartGame                 = 0x04
-	CellUpdate                = 0x05
-	RequestReload             = 0x06
-	GameEnd                   = 0x07
-	GamemodeInfo              = 0x08
+	MoveCommand       MessageType = 0x01
+	TextMessage                   = 0x02
+	Board                         = 0x03
+	StartGame                     = 0x04
+	CellUpdate                    = 0x05
+	RequestReload                 = 0x06
+	GameEnd                       = 0x07
+	GamemodeInfo                  = 0x08
+	HintRequest                   = 0x09
+	HintResponse                  = 0x0A
+	GameTime                      = 0x0B
+	SubscribeChunks               = 0x0C
+	UnsubscribeChunks             = 0x0D
+	ChunkCellUpdate               = 0x0E
+	UndoRequest                   = 0x0F
+	MovesUndone                   = 0x10
+	SpectateRequest               = 0x11
 
 	SpawnServerRequest = 0xA0
 	SendGameServers    = 0xA1
 	GetGameServers     = 0xA2
 	ServerSpawned      = 0xA3
+	SaveReplay         = 0xA4
+	ReplaySaved        = 0xA5
 
 	RegisterPlayerRequest  = 0xC0
 	RegisterPlayerResponse = 0xC1
@@ -35,11 +49,46 @@ const (
 	ConnectToGameRequest   = 0xC4
 	ConnectToGameResponse  = 0xC5
 	AuthWithMMToken        = 0xC6
+	ReplayRequest          = 0xC7
+	ReplayResponse         = 0xC8
+
+	Handshake         = 0xF0
+	HandshakeRejected = 0xF1
 )
 
 // Custom flags of special second byte
 const (
-	HasIdFlag byte = 0x01
+	HasIdFlag      byte = 0x01
+	HasOptionsFlag byte = 0x02
+)
+
+// Optional game settings of StartGame. They are only sent when some option is
+// set so clients that don't opt in keep the plain message layout.
+// Every option is encoded as |optionId - byte|length - uint16|value|
+type gameOptionId byte
+
+const (
+	optionFirstMoveSafe gameOptionId = 0x01
+	optionNoGuess       gameOptionId = 0x02
+	optionQuestionMarks gameOptionId = 0x03
+	// Value is the turn time in milliseconds as uint32
+	optionTurnTime gameOptionId = 0x04
+	// Value is the number of lives as uint16
+	optionLives          gameOptionId = 0x05
+	optionLivesPerPlayer gameOptionId = 0x06
+	// Value is the time limit in milliseconds as uint32
+	optionTimeLimit gameOptionId = 0x07
+	// Value is the topology id as a single byte
+	optionTopology gameOptionId = 0x08
+	// Value is the board mask as a bitset of disabled cells, one bit per cell
+	// in the order x + y*width
+	optionMask gameOptionId = 0x09
+	// Value is a handcrafted board in the binary format of mines.EncodeBoard
+	optionLayout gameOptionId = 0x0A
+	// Mines of the StartGame message are the mines of every chunk
+	optionInfinite gameOptionId = 0x0B
+
+	optionAllowUndo gameOptionId = 0x0C
 )
 
 type GameEndType byte
@@ -48,16 +97,39 @@ const (
 	Win     GameEndType = 0x01
 	Loss                = 0x02
 	Aborted             = 0x03
+	// Sent to spectators, the outcome tells who won
+	Watched = 0x04
 )
 
 const (
 	HeaderLength         = 6
 	CellByteLength       = 9
 	UpdateCellByteLength = 9
+	// |width|height|mines|gamemode|seed|
+	GameStartByteLength = 3*4 + 1 + 8
+	// |playerId|progress|eliminated|
+	VersusProgressByteLength = 4 + 1 + 1
+	// |x|y|moveType|probability|
+	HintByteLength = 4 + 4 + 1 + 2
+	// |chunkX|chunkY|
+	ChunkByteLength = 4 + 4
+	// |x|y|value| relative to the origin of the chunk
+	ChunkCellUpdateByteLength = 1 + 1 + 1
 )
 
+// Largest payload a peer may announce. Longer messages are refused before
+// anything gets allocated for them.
+const MaxPayloadLength = 64 << 20
+
+// Every decoder fails with one of these errors, wrapped with the details, so
+// callers can tell malformed messages from other failures with errors.Is
 var (
 	ErrInvalidPayloadSize = errors.New("invalid payload size")
+	// The message doesn't even hold the header
+	ErrMessageTooShort       = errors.New("message too short")
+	ErrUnexpectedMessageType = errors.New("unexpected message type")
+	// The payload has the right size but values its layout doesn't allow
+	ErrInvalidPayload = errors.New("invalid payload")
 )
 
 type AuthResponse struct {
@@ -71,6 +143,8 @@ type GameServerInfo struct {
 	Host        string
 	Port        uint16
 	PlayerCount int
+	// Connections that only watch the game
+	SpectatorCount int
 }
 
 type GameServerConnectInfo struct {
@@ -91,14 +165,14 @@ type GameConnectionResponse struct {
 
 func checkAndDecodeLength(data []byte, message MessageType) (int, error) {
 	if len(data) < HeaderLength {
-		return 0, fmt.Errorf("Data too short to decode")
+		return 0, fmt.Errorf("Data too short to decode (%d bytes): %w", len(data), ErrMessageTooShort)
 	}
 	if MessageType(data[0]) != message {
-		return 0, fmt.Errorf("Invalid message type for command E:%d R:%d", message, data[0])
+		return 0, fmt.Errorf("Invalid message type for command E:%d R:%d: %w", message, data[0], ErrUnexpectedMessageType)
 	}
 	payloadLength := int(binary.BigEndian.Uint32(data[2:6]))
 	if payloadLength != len(data)-HeaderLength {
-		return payloadLength, fmt.Errorf("Payload size is invalid") // TODO: make a custom error
+		return payloadLength, fmt.Errorf("Payload size %d doesn't match the %d bytes received: %w", payloadLength, len(data)-HeaderLength, ErrInvalidPayloadSize)
 	}
 	return payloadLength, nil
 }
@@ -107,11 +181,11 @@ func GetRequestId(data []byte, requestId *uint32) error {
 	if requestId == nil {
 		return fmt.Errorf("RequestId pointer is nil")
 	}
-	if (data[1] & HasIdFlag) == 0 {
-		return fmt.Errorf("HasIdFlag not set so packet does not contain requestId")
-	}
 	if len(data) < HeaderLength+4 {
-		return fmt.Errorf("Data too short to retrieve requestId")
+		return fmt.Errorf("Data too short to retrieve requestId: %w", ErrInvalidPayloadSize)
+	}
+	if (data[1] & HasIdFlag) == 0 {
+		return fmt.Errorf("HasIdFlag not set so packet does not contain requestId: %w", ErrInvalidPayload)
 	}
 	*requestId = binary.BigEndian.Uint32(data[HeaderLength : HeaderLength+4])
 	return nil
@@ -127,6 +201,12 @@ func bytesToInt(bytes []byte) int {
 	return int(binary.BigEndian.Uint32(bytes))
 }
 
+// Cells of infinite boards can have negative coordinates so they are decoded
+// as signed integers
+func bytesToCoordinate(bytes []byte) int {
+	return int(int32(binary.BigEndian.Uint32(bytes)))
+}
+
 func writePayloadLength(buf *bytes.Buffer, length int) error {
 	err := binary.Write(buf, binary.BigEndian, uint32(length))
 	if err != nil {
@@ -266,14 +346,15 @@ func DecodeConnectToGameResponse(data []byte) (*GameConnectionResponse, error) {
 
 func decodeAuthToken(data []byte) (players.AuthToken, error) {
 	if len(data) != players.AuthTokenLength {
-		return players.AuthToken{}, fmt.Errorf("invalid data size to decode auth token: %d", len(data))
+		return players.AuthToken{}, fmt.Errorf("invalid data size to decode auth token: %d: %w", len(data), ErrInvalidPayloadSize)
 	}
 	var token players.AuthToken
 	token.PlayerID = binary.BigEndian.Uint32(data[0:4])
 	token.ServerID = binary.BigEndian.Uint32(data[4:8])
 	token.Expiry = int64(binary.BigEndian.Uint64(data[8:16]))
 	copy(token.Nonce[:], data[16:32])
-	copy(token.Signature[:], data[32:players.AuthTokenLength])
+	copy(token.Signature[:], data[32:64])
+	token.Spectator = data[64] != 0
 	return token, nil
 }
 
@@ -283,7 +364,10 @@ func encodeAuthToken(token players.AuthToken) []byte {
 	binary.BigEndian.PutUint32(encoded[4:8], token.ServerID)
 	binary.BigEndian.PutUint64(encoded[8:16], uint64(token.Expiry))
 	copy(encoded[16:32], token.Nonce[:])
-	copy(encoded[32:players.AuthTokenLength], token.Signature[:])
+	copy(encoded[32:64], token.Signature[:])
+	if token.Spectator {
+		encoded[64] = 1
+	}
 
 	return encoded
 }
@@ -307,7 +391,7 @@ func decodeGameServerConnectInfo(buf io.Reader) (*GameServerConnectInfo, error)
 		return nil, err
 	}
 	var port uint16
-	if err := binary.Read(buf, binary.BigEndian, &port); err != nil {
+	if err := readValue(buf, &port); err != nil {
 		return nil, err
 	}
 	return &GameServerConnectInfo{Host: host, Port: port}, nil
@@ -332,7 +416,7 @@ func DecodeConnectToGameRequest(data []byte) (uint32, error) {
 		return 0, err
 	}
 	if length != 4 {
-		return 0, fmt.Errorf("ConnectToGameServerRequest payload lentth != 4 (got %d)", length)
+		return 0, fmt.Errorf("ConnectToGameServerRequest payload lentth != 4 (got %d): %w", length, ErrInvalidPayloadSize)
 	}
 	return binary.BigEndian.Uint32(data[HeaderLength:]), nil
 }
@@ -358,6 +442,9 @@ func EncodeAuthResponse(response AuthResponse) ([]byte, error) {
 	if err := writePayloadLength(&buf, payloadLength); err != nil {
 		return nil, err
 	}
+	if err := buf.WriteByte(1); err != nil {
+		return nil, err
+	}
 	if err := binary.Write(&buf, binary.BigEndian, response.Player.ID); err != nil {
 		return nil, err
 	}
@@ -391,7 +478,10 @@ func DecodeAuthResponse(data []byte) (*AuthResponse, error) {
 	}
 	id := binary.BigEndian.Uint32(payload[1:5])
 	nameLen := binary.BigEndian.Uint32(payload[5:9])
-	name := string(payload[9 : 9+nameLen])
+	if uint64(pLen) != 1+4+4+uint64(nameLen) {
+		return nil, fmt.Errorf("Auth response with name of %d bytes has %d bytes: %w", nameLen, pLen, ErrInvalidPayloadSize)
+	}
+	name := string(payload[9:])
 	return &AuthResponse{
 		Success: true,
 		Player: &players.PlayerInfo{
@@ -420,10 +510,13 @@ func EncodeRegisterPlayerResponse(success bool) ([]byte, error) {
 }
 
 func DecodeRegisterPlayerResponse(data []byte) (bool, error) {
-	_, err := checkAndDecodeLength(data, ServerSpawned)
+	length, err := checkAndDecodeLength(data, RegisterPlayerResponse)
 	if err != nil {
 		return false, err
 	}
+	if length != 1 {
+		return false, fmt.Errorf("Register response has %d bytes: %w", length, ErrInvalidPayloadSize)
+	}
 	success := data[HeaderLength] == 1
 	return success, nil
 }
@@ -449,8 +542,14 @@ func decodeAuthPlayerParams(data []byte, tp MessageType) (*AuthPlayerParams, err
 		return nil, err
 	}
 	payload := data[HeaderLength:]
-	nameLen := bytesToInt(payload[0:4])
-	passwordOffset := nameLen + 4
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("Player params too short: %w", ErrInvalidPayloadSize)
+	}
+	nameLen := binary.BigEndian.Uint32(payload[0:4])
+	if uint64(nameLen) > uint64(len(payload)-4) {
+		return nil, fmt.Errorf("Name of %d bytes doesn't fit player params of %d bytes: %w", nameLen, len(payload), ErrInvalidPayloadSize)
+	}
+	passwordOffset := int(nameLen) + 4
 	name := string(payload[4:passwordOffset])
 	password := string(payload[passwordOffset:])
 	params := &AuthPlayerParams{Name: name, Password: password}
@@ -461,15 +560,37 @@ func DecodeRegisterPlayerRequest(data []byte) (*AuthPlayerParams, error) {
 	return decodeAuthPlayerParams(data, RegisterPlayerRequest)
 }
 
-func EncodeGameEnd(endType GameEndType) ([]byte, error) {
+// Encodes the end of the game from the view of the receiving player together
+// with the outcome of the game. A nil outcome only sends the end type.
+func EncodeGameEnd(endType GameEndType, outcome *mines.GameOutcome) ([]byte, error) {
 	var buf bytes.Buffer
 	buf.WriteByte(byte(GameEnd))
 	buf.WriteByte(byte(0x00))
-	err := writePayloadLength(&buf, 1)
+	// |endType|reason|playerId|elapsed|len(winners)|winnerId|...|len(placements)|playerId|place|...
+	payloadLength := 1
+	if outcome != nil {
+		payloadLength += 1 + 4 + 4 + 2 + 4*len(outcome.Winners) + 2 + 6*len(outcome.Placements)
+	}
+	err := writePayloadLength(&buf, payloadLength)
 	if err != nil {
 		return nil, err
 	}
 	buf.WriteByte(byte(endType))
+	if outcome == nil {
+		return buf.Bytes(), nil
+	}
+	buf.WriteByte(byte(outcome.Reason))
+	binary.Write(&buf, binary.BigEndian, outcome.PlayerId)
+	binary.Write(&buf, binary.BigEndian, uint32(outcome.Elapsed.Milliseconds()))
+	binary.Write(&buf, binary.BigEndian, uint16(len(outcome.Winners)))
+	for _, playerId := range outcome.Winners {
+		binary.Write(&buf, binary.BigEndian, playerId)
+	}
+	binary.Write(&buf, binary.BigEndian, uint16(len(outcome.Placements)))
+	for _, placement := range outcome.Placements {
+		binary.Write(&buf, binary.BigEndian, placement.PlayerId)
+		binary.Write(&buf, binary.BigEndian, uint16(placement.Place))
+	}
 	return buf.Bytes(), nil
 }
 
@@ -622,8 +743,8 @@ func EncodeSendGameServers(servers []*GameServerInfo, requestId *uint32) ([]byte
 }
 
 func EncodeGameServer(server *GameServerInfo) ([]byte, error) {
-	// encoded structure |NameLength - int|name - string|HostLength - int|host - string|port - uint16|PlayerCount - int|
-	// Total lengt = 4+NameLength+4+HostLength+2+4 = 14 + NameLengt + HostLength
+	// encoded structure |NameLength - int|name - string|HostLength - int|host - string|port - uint16|PlayerCount - int|SpectatorCount - int|
+	// Total lengt = 4+NameLength+4+HostLength+2+4+4 = 18 + NameLengt + HostLength
 	var buf bytes.Buffer
 	err := writeStringWithLength(&buf, server.Name)
 	if err != nil {
@@ -641,6 +762,10 @@ func EncodeGameServer(server *GameServerInfo) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = binary.Write(&buf, binary.BigEndian, int32(server.SpectatorCount))
+	if err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -656,20 +781,26 @@ func DecodeGameServer(buf io.Reader) (*GameServerInfo, error) {
 	}
 
 	var port uint16
-	if err := binary.Read(buf, binary.BigEndian, &port); err != nil {
+	if err := readValue(buf, &port); err != nil {
 		return nil, err
 	}
 
 	var playerCount int32
-	if err := binary.Read(buf, binary.BigEndian, &playerCount); err != nil {
+	if err := readValue(buf, &playerCount); err != nil {
+		return nil, err
+	}
+
+	var spectatorCount int32
+	if err := readValue(buf, &spectatorCount); err != nil {
 		return nil, err
 	}
 
 	return &GameServerInfo{
-		Name:        name,
-		Host:        host,
-		Port:        port,
-		PlayerCount: int(playerCount),
+		Name:           name,
+		Host:           host,
+		Port:           port,
+		PlayerCount:    int(playerCount),
+		SpectatorCount: int(spectatorCount),
 	}, nil
 }
 
@@ -684,18 +815,35 @@ func writeStringWithLength(buf *bytes.Buffer, str string) error {
 
 func readStringWithLength(r io.Reader) (string, error) {
 	var length int32
-	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+	if err := readValue(r, &length); err != nil {
 		return "", err
 	}
+	if length < 0 {
+		return "", fmt.Errorf("Negative string length %d: %w", length, ErrInvalidPayload)
+	}
 
-	strBytes := make([]byte, length)
-	if _, err := io.ReadFull(r, strBytes); err != nil {
+	strBytes, err := io.ReadAll(io.LimitReader(r, int64(length)))
+	if err != nil {
 		return "", err
 	}
+	if len(strBytes) != int(length) {
+		return "", fmt.Errorf("String of %d bytes cut at %d: %w", length, len(strBytes), ErrInvalidPayloadSize)
+	}
 
 	return string(strBytes), nil
 }
 
+// Reads a value of fixed size, running out of data means the payload is too short
+func readValue(r io.Reader, value any) error {
+	if err := binary.Read(r, binary.BigEndian, value); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("Failed to read %T: %w", value, ErrInvalidPayloadSize)
+		}
+		return err
+	}
+	return nil
+}
+
 func EncodeSpawnServerRequest(name string, requestId *uint32) ([]byte, error) {
 	var buf bytes.Buffer
 	buf.WriteByte(byte(SpawnServerRequest))
@@ -727,7 +875,7 @@ func DecodeSpawnServerRequest(data []byte, requestId *uint32) (string, error) {
 	offset := HeaderLength
 	if requestId != nil {
 		if err = GetRequestId(data, requestId); err != nil {
-			return "", nil
+			return "", err
 		}
 		offset += 4
 	}
@@ -736,12 +884,50 @@ func DecodeSpawnServerRequest(data []byte, requestId *uint32) (string, error) {
 	return serverName, nil
 }
 
-func DecodeGameEnd(data []byte) (GameEndType, error) {
-	_, err := checkAndDecodeLength(data, GameEnd)
+func DecodeGameEnd(data []byte) (GameEndType, *mines.GameOutcome, error) {
+	payloadLength, err := checkAndDecodeLength(data, GameEnd)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
+	}
+	if payloadLength < 1 {
+		return 0, nil, fmt.Errorf("Game end too short: %w", ErrInvalidPayloadSize)
+	}
+	endType := GameEndType(data[HeaderLength])
+	if payloadLength == 1 {
+		return endType, nil, nil
+	}
+	payload := data[HeaderLength+1:]
+	if len(payload) < 1+4+4+2 {
+		return 0, nil, fmt.Errorf("Game outcome too short: %w", ErrInvalidPayloadSize)
+	}
+	outcome := &mines.GameOutcome{
+		Reason:   mines.EndReason(payload[0]),
+		PlayerId: binary.BigEndian.Uint32(payload[1:5]),
+		Elapsed:  time.Duration(binary.BigEndian.Uint32(payload[5:9])) * time.Millisecond,
+	}
+	offset := 9
+	winnersCount := int(binary.BigEndian.Uint16(payload[offset : offset+2]))
+	offset += 2
+	if len(payload) < offset+4*winnersCount+2 {
+		return 0, nil, fmt.Errorf("Game outcome winners too short: %w", ErrInvalidPayloadSize)
+	}
+	for range winnersCount {
+		outcome.Winners = append(outcome.Winners, binary.BigEndian.Uint32(payload[offset:offset+4]))
+		offset += 4
+	}
+	placementsCount := int(binary.BigEndian.Uint16(payload[offset : offset+2]))
+	offset += 2
+	if len(payload) != offset+6*placementsCount {
+		return 0, nil, fmt.Errorf("Invalid game outcome placements length: %w", ErrInvalidPayloadSize)
 	}
-	return GameEndType(data[HeaderLength]), nil
+	for range placementsCount {
+		outcome.Placements = append(outcome.Placements, mines.PlayerPlacement{
+			PlayerId: binary.BigEndian.Uint32(payload[offset : offset+4]),
+			Place:    int(binary.BigEndian.Uint16(payload[offset+4 : offset+6])),
+		})
+		offset += 6
+	}
+	return endType, outcome, nil
 }
 
 func EncodeTextMessage(message string) ([]byte, error) {
@@ -787,15 +973,23 @@ func EncodeMove(move mines.Move) ([]byte, error) {
 }
 
 func DecodeMove(data []byte) (move *mines.Move, err error) {
-	_, err = checkAndDecodeLength(data, MoveCommand)
+	payloadLength, err := checkAndDecodeLength(data, MoveCommand)
 	if err != nil {
 		return nil, err
 	}
+	if payloadLength != 13 {
+		return nil, fmt.Errorf("Invalid move length %d: %w", payloadLength, ErrInvalidPayloadSize)
+	}
 	move = &mines.Move{}
 	payload := data[HeaderLength:]
 	move.Type = mines.MoveType(payload[0])
-	move.X = bytesToInt(payload[1:5])
-	move.Y = bytesToInt(payload[5:9])
+	switch move.Type {
+	case mines.Reveal, mines.Flag, mines.Chord:
+	default:
+		return nil, fmt.Errorf("Unknown move type: %d: %w", move.Type, ErrInvalidPayload)
+	}
+	move.X = bytesToCoordinate(payload[1:5])
+	move.Y = bytesToCoordinate(payload[5:9])
 	move.PlayerId = binary.BigEndian.Uint32(payload[9:13])
 	return move, nil
 }
@@ -804,6 +998,7 @@ const (
 	MineFlag     byte = 0b0001
 	RevealedFlag byte = 0b0010
 	FlaggedFlag  byte = 0b0100
+	QuestionFlag byte = 0b1000
 )
 
 func encodeCellFlags(cell *mines.Cell) byte {
@@ -817,6 +1012,9 @@ func encodeCellFlags(cell *mines.Cell) byte {
 	if cell.Flagged {
 		flags |= FlaggedFlag
 	}
+	if cell.Questioned {
+		flags |= QuestionFlag
+	}
 	return flags
 }
 
@@ -832,11 +1030,12 @@ func decodeCellFlags(flags byte, cell *mines.Cell) {
 	cell.Mine = (flags & MineFlag) != 0
 	cell.Revealed = (flags & RevealedFlag) != 0
 	cell.Flagged = (flags & FlaggedFlag) != 0
+	cell.Questioned = (flags & QuestionFlag) != 0
 }
 
 func decodeCell(data []byte) (*mines.Cell, error) {
 	if len(data) != CellByteLength {
-		return nil, fmt.Errorf("Invalid length to decode cell (%d)", len(data))
+		return nil, fmt.Errorf("Invalid length to decode cell (%d): %w", len(data), ErrInvalidPayloadSize)
 	}
 	cell := mines.Cell{}
 	cell.X = bytesToInt(data[0:4])
@@ -855,7 +1054,7 @@ func EncodeBoard(board *mines.Board) ([]byte, error) {
 	boardBuf.Write(intToBytes(board.Width))
 	for y := range board.Height {
 		for x := range board.Width {
-			boardBuf.Write(encodeCell(board.Cells[x][y]))
+			boardBuf.Write(encodeCell(board.Cell(x, y)))
 		}
 	}
 	err := writePayloadLength(&buf, boardBuf.Len())
@@ -875,36 +1074,37 @@ func DecodeBoard(data []byte) (*mines.Board, error) {
 	payload := data[HeaderLength:]
 
 	if len(payload) < 8 {
-		return nil, fmt.Errorf("payload too short to contain board dimensions")
+		return nil, fmt.Errorf("payload too short to contain board dimensions: %w", ErrInvalidPayloadSize)
 	}
 
-	board := &mines.Board{}
-	board.Height = bytesToInt(payload[0:4])
-	board.Width = bytesToInt(payload[4:8])
-	grid := make([][]*mines.Cell, board.Width)
-	for i := range grid {
-		grid[i] = make([]*mines.Cell, board.Height)
-	}
-	board.Cells = grid
+	height := bytesToInt(payload[0:4])
+	width := bytesToInt(payload[4:8])
 	cells := payload[8:]
 	if len(cells)%CellByteLength != 0 {
-		return nil, fmt.Errorf("Cells payload length mismatch")
+		return nil, fmt.Errorf("Cells payload length mismatch: %w", ErrInvalidPayloadSize)
+	}
+	// Compared in 64 bits so the product can't overflow
+	if uint64(len(cells)/CellByteLength) != uint64(height)*uint64(width) {
+		return nil, fmt.Errorf("Number of cells doesnt match board size: %w", ErrInvalidPayloadSize)
 	}
-	if len(cells)/CellByteLength != board.Height*board.Width {
-		return nil, fmt.Errorf("Number of cells doesnt match board size")
+	board, err := mines.CreateBoard(width, height, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPayload, err)
 	}
+	seen := make([]bool, width*height)
 	for i := 0; i < len(cells); i += CellByteLength {
 		cell, err := decodeCell(cells[i : i+CellByteLength])
 		if err != nil {
 			return nil, err
 		}
 		if cell.X < 0 || cell.X >= board.Width || cell.Y < 0 || cell.Y >= board.Height {
-			return nil, fmt.Errorf("Cell position out of bounds: (%d, %d)", cell.X, cell.Y)
+			return nil, fmt.Errorf("Cell position out of bounds: (%d, %d): %w", cell.X, cell.Y, ErrInvalidPayload)
 		}
-		if board.Cells[cell.X][cell.Y] != nil {
-			return nil, fmt.Errorf("Duplicate entry of a cell")
+		if seen[cell.X+cell.Y*width] {
+			return nil, fmt.Errorf("Duplicate entry of a cell: %w", ErrInvalidPayload)
 		}
-		board.Cells[cell.X][cell.Y] = cell
+		seen[cell.X+cell.Y*width] = true
+		board.SetCell(cell)
 	}
 
 	return board, nil
@@ -939,11 +1139,11 @@ func EncodeCellUpdates(cells []mines.UpdatedCell) ([]byte, error) {
 
 func decodeCellUpdate(data []byte) (*mines.UpdatedCell, error) {
 	if len(data) != UpdateCellByteLength {
-		return nil, fmt.Errorf("incorrect byte length to decode cell update (%d)", len(data))
+		return nil, fmt.Errorf("incorrect byte length to decode cell update (%d): %w", len(data), ErrInvalidPayloadSize)
 	}
 	cell := &mines.UpdatedCell{
-		X:     bytesToInt(data[0:4]),
-		Y:     bytesToInt(data[4:8]),
+		X:     bytesToCoordinate(data[0:4]),
+		Y:     bytesToCoordinate(data[4:8]),
 		Value: data[8]}
 	return cell, nil
 
@@ -957,7 +1157,7 @@ func DecodeCellUpdates(data []byte) ([]mines.UpdatedCell, error) {
 	}
 	payload := data[HeaderLength:]
 	if payloadLength%UpdateCellByteLength != 0 {
-		return nil, fmt.Errorf("update cells payload length mismatch %d", payloadLength)
+		return nil, fmt.Errorf("update cells payload length mismatch %d: %w", payloadLength, ErrInvalidPayloadSize)
 	}
 	cells := make([]mines.UpdatedCell, payloadLength/UpdateCellByteLength)
 	for i := range payloadLength / UpdateCellByteLength {
@@ -978,32 +1178,78 @@ func EncodeGamemodeInfo(info mines.GamemodeUpdateInfo) ([]byte, error) {
 			return nil, fmt.Errorf("Failed to cast to CoopInfoUpdate")
 		}
 		return EncodeCoopInfoUpdate(i)
+	case mines.ModeVersus:
+		i, ok := info.(*mines.VersusInfoUpdate)
+		if !ok {
+			return nil, fmt.Errorf("Failed to cast to VersusInfoUpdate")
+		}
+		return EncodeVersusInfoUpdate(i)
+	case mines.ModeTurnBased:
+		i, ok := info.(*mines.TurnBasedInfoUpdate)
+		if !ok {
+			return nil, fmt.Errorf("Failed to cast to TurnBasedInfoUpdate")
+		}
+		return EncodeTurnBasedInfoUpdate(i)
+	case mines.ModeFlags:
+		i, ok := info.(*mines.FlagsInfoUpdate)
+		if !ok {
+			return nil, fmt.Errorf("Failed to cast to FlagsInfoUpdate")
+		}
+		return EncodeFlagsInfoUpdate(i)
+	case mines.ModeLives:
+		i, ok := info.(*mines.LivesInfoUpdate)
+		if !ok {
+			return nil, fmt.Errorf("Failed to cast to LivesInfoUpdate")
+		}
+		return EncodeLivesInfoUpdate(i)
 	default:
 		return nil, fmt.Errorf("Gamemode info not implemented to decode")
 	}
 }
 
 func DecodeGamemodeInfo(data []byte) (mines.GamemodeUpdateInfo, error) {
-	_, err := checkAndDecodeLength(data, GamemodeInfo)
+	payloadLength, err := checkAndDecodeLength(data, GamemodeInfo)
 	if err != nil {
 		return nil, err
 	}
+	if payloadLength < 1 {
+		return nil, fmt.Errorf("Gamemode info without gamemodeId: %w", ErrInvalidPayloadSize)
+	}
 	gamemodeId := mines.GameModeId(data[HeaderLength])
 	switch gamemodeId {
 	case mines.ModeCoop:
 		return DecodeCoopInfoUpdate(data)
+	case mines.ModeVersus:
+		return DecodeVersusInfoUpdate(data)
+	case mines.ModeTurnBased:
+		return DecodeTurnBasedInfoUpdate(data)
+	case mines.ModeFlags:
+		return DecodeFlagsInfoUpdate(data)
+	case mines.ModeLives:
+		return DecodeLivesInfoUpdate(data)
 	default:
-		return nil, fmt.Errorf("Can't decode gamemode info gamemodeId: %d", gamemodeId)
+		return nil, fmt.Errorf("Can't decode gamemode info gamemodeId: %d: %w", gamemodeId, ErrInvalidPayload)
 	}
 
 }
 func DecodeCoopInfoUpdate(data []byte) (*mines.CoopInfoUpdate, error) {
+	payloadLength, err := checkAndDecodeLength(data, GamemodeInfo)
+	if err != nil {
+		return nil, err
+	}
+	if payloadLength < 1+2 {
+		return nil, fmt.Errorf("Coop info too short: %w", ErrInvalidPayloadSize)
+	}
 	if data[HeaderLength] != byte(mines.ModeCoop) {
-		return nil, fmt.Errorf("Wrong gamemodeId to decode Coop: %d", data[4])
+		return nil, fmt.Errorf("Wrong gamemodeId to decode Coop: %d: %w", data[HeaderLength], ErrInvalidPayload)
 	}
 	offset := HeaderLength + 1
 	scoreLength := int(binary.BigEndian.Uint16(data[offset : offset+2]))
 	offset += 2
+	marksLength := payloadLength - (1 + 2 + 8*scoreLength)
+	if marksLength < 0 || marksLength%12 != 0 {
+		return nil, fmt.Errorf("Invalid coop info length %d for %d players: %w", payloadLength, scoreLength, ErrInvalidPayloadSize)
+	}
 	playerScores := make(map[uint32]int)
 	for range scoreLength {
 		playerId := binary.BigEndian.Uint32(data[offset : offset+4])
@@ -1014,9 +1260,9 @@ func DecodeCoopInfoUpdate(data []byte) (*mines.CoopInfoUpdate, error) {
 	}
 	var marksChange []mines.PlayerMarkChange
 	for offset < len(data) {
-		X := bytesToInt(data[offset : offset+4])
+		X := bytesToCoordinate(data[offset : offset+4])
 		offset += 4
-		Y := bytesToInt(data[offset : offset+4])
+		Y := bytesToCoordinate(data[offset : offset+4])
 		offset += 4
 		playerId := binary.BigEndian.Uint32(data[offset : offset+4])
 		offset += 4
@@ -1049,12 +1295,400 @@ func EncodeCoopInfoUpdate(info *mines.CoopInfoUpdate) ([]byte, error) {
 	return buf.Bytes(), err
 }
 
+func EncodeVersusInfoUpdate(info *mines.VersusInfoUpdate) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(GamemodeInfo))
+	buf.WriteByte(byte(0x00))
+	// |gamemodeId|len(players)|playerId|progress|eliminated|...
+	payloadLength := 1 + 2 + VersusProgressByteLength*len(info.Players)
+	if err := writePayloadLength(&buf, payloadLength); err != nil {
+		return nil, err
+	}
+	buf.WriteByte(byte(mines.ModeVersus))
+	binary.Write(&buf, binary.BigEndian, uint16(len(info.Players)))
+	for _, player := range info.Players {
+		binary.Write(&buf, binary.BigEndian, player.PlayerId)
+		buf.WriteByte(byte(player.Progress))
+		if player.Eliminated {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func DecodeVersusInfoUpdate(data []byte) (*mines.VersusInfoUpdate, error) {
+	payloadLength, err := checkAndDecodeLength(data, GamemodeInfo)
+	if err != nil {
+		return nil, err
+	}
+	if payloadLength < 3 {
+		return nil, fmt.Errorf("Versus info too short: %w", ErrInvalidPayloadSize)
+	}
+	if data[HeaderLength] != byte(mines.ModeVersus) {
+		return nil, fmt.Errorf("Wrong gamemodeId to decode Versus: %d: %w", data[HeaderLength], ErrInvalidPayload)
+	}
+	offset := HeaderLength + 1
+	count := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	if payloadLength != 1+2+VersusProgressByteLength*count {
+		return nil, fmt.Errorf("Invalid versus info length %d for %d players: %w", payloadLength, count, ErrInvalidPayloadSize)
+	}
+	info := &mines.VersusInfoUpdate{Players: make([]mines.VersusPlayerProgress, count)}
+	for i := range count {
+		info.Players[i] = mines.VersusPlayerProgress{
+			PlayerId:   binary.BigEndian.Uint32(data[offset : offset+4]),
+			Progress:   int(data[offset+4]),
+			Eliminated: data[offset+5] != 0,
+		}
+		offset += VersusProgressByteLength
+	}
+	return info, nil
+}
+
+func EncodeTurnBasedInfoUpdate(info *mines.TurnBasedInfoUpdate) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(GamemodeInfo))
+	buf.WriteByte(byte(0x00))
+	// |gamemodeId|currentPlayer|turnTimeLeft|len(playerScores)|playerId|score|...
+	payloadLength := 1 + 4 + 4 + 2 + 8*len(info.PlayerScores)
+	if err := writePayloadLength(&buf, payloadLength); err != nil {
+		return nil, err
+	}
+	buf.WriteByte(byte(mines.ModeTurnBased))
+	binary.Write(&buf, binary.BigEndian, info.CurrentPlayer)
+	binary.Write(&buf, binary.BigEndian, uint32(info.TurnTimeLeft.Milliseconds()))
+	binary.Write(&buf, binary.BigEndian, uint16(len(info.PlayerScores)))
+	for playerId, score := range info.PlayerScores {
+		binary.Write(&buf, binary.BigEndian, playerId)
+		binary.Write(&buf, binary.BigEndian, int32(score))
+	}
+	return buf.Bytes(), nil
+}
+
+func DecodeTurnBasedInfoUpdate(data []byte) (*mines.TurnBasedInfoUpdate, error) {
+	payloadLength, err := checkAndDecodeLength(data, GamemodeInfo)
+	if err != nil {
+		return nil, err
+	}
+	if payloadLength < 1+4+4+2 {
+		return nil, fmt.Errorf("Turn based info too short: %w", ErrInvalidPayloadSize)
+	}
+	if data[HeaderLength] != byte(mines.ModeTurnBased) {
+		return nil, fmt.Errorf("Wrong gamemodeId to decode TurnBased: %d: %w", data[HeaderLength], ErrInvalidPayload)
+	}
+	offset := HeaderLength + 1
+	info := &mines.TurnBasedInfoUpdate{PlayerScores: make(map[uint32]int)}
+	info.CurrentPlayer = binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+	info.TurnTimeLeft = time.Duration(binary.BigEndian.Uint32(data[offset:offset+4])) * time.Millisecond
+	offset += 4
+	count := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	if payloadLength != 1+4+4+2+8*count {
+		return nil, fmt.Errorf("Invalid turn based info length %d for %d players: %w", payloadLength, count, ErrInvalidPayloadSize)
+	}
+	for range count {
+		playerId := binary.BigEndian.Uint32(data[offset : offset+4])
+		info.PlayerScores[playerId] = int(int32(binary.BigEndian.Uint32(data[offset+4 : offset+8])))
+		offset += 8
+	}
+	return info, nil
+}
+
+func EncodeFlagsInfoUpdate(info *mines.FlagsInfoUpdate) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(GamemodeInfo))
+	buf.WriteByte(byte(0x00))
+	// |gamemodeId|currentPlayer|winner|len(claimedMines)|playerId|claimed|...|x|y|playerId|...
+	payloadLength := 1 + 4 + 4 + 2 + 8*len(info.ClaimedMines) + 12*len(info.MarksChange)
+	if err := writePayloadLength(&buf, payloadLength); err != nil {
+		return nil, err
+	}
+	buf.WriteByte(byte(mines.ModeFlags))
+	binary.Write(&buf, binary.BigEndian, info.CurrentPlayer)
+	binary.Write(&buf, binary.BigEndian, info.Winner)
+	binary.Write(&buf, binary.BigEndian, uint16(len(info.ClaimedMines)))
+	for playerId, claimed := range info.ClaimedMines {
+		binary.Write(&buf, binary.BigEndian, playerId)
+		binary.Write(&buf, binary.BigEndian, uint32(claimed))
+	}
+	for _, cellInfo := range info.MarksChange {
+		binary.Write(&buf, binary.BigEndian, uint32(cellInfo.X))
+		binary.Write(&buf, binary.BigEndian, uint32(cellInfo.Y))
+		binary.Write(&buf, binary.BigEndian, cellInfo.PlayerId)
+	}
+	return buf.Bytes(), nil
+}
+
+func DecodeFlagsInfoUpdate(data []byte) (*mines.FlagsInfoUpdate, error) {
+	payloadLength, err := checkAndDecodeLength(data, GamemodeInfo)
+	if err != nil {
+		return nil, err
+	}
+	if payloadLength < 1+4+4+2 {
+		return nil, fmt.Errorf("Flags info too short: %w", ErrInvalidPayloadSize)
+	}
+	if data[HeaderLength] != byte(mines.ModeFlags) {
+		return nil, fmt.Errorf("Wrong gamemodeId to decode Flags: %d: %w", data[HeaderLength], ErrInvalidPayload)
+	}
+	offset := HeaderLength + 1
+	info := &mines.FlagsInfoUpdate{ClaimedMines: make(map[uint32]int)}
+	info.CurrentPlayer = binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+	info.Winner = binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+	count := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	marksLength := payloadLength - (1 + 4 + 4 + 2 + 8*count)
+	if marksLength < 0 || marksLength%12 != 0 {
+		return nil, fmt.Errorf("Invalid flags info length %d for %d players: %w", payloadLength, count, ErrInvalidPayloadSize)
+	}
+	for range count {
+		playerId := binary.BigEndian.Uint32(data[offset : offset+4])
+		info.ClaimedMines[playerId] = bytesToInt(data[offset+4 : offset+8])
+		offset += 8
+	}
+	for offset < len(data) {
+		X := bytesToInt(data[offset : offset+4])
+		Y := bytesToInt(data[offset+4 : offset+8])
+		playerId := binary.BigEndian.Uint32(data[offset+8 : offset+12])
+		info.MarksChange = append(info.MarksChange, mines.PlayerMarkChange{X: X, Y: Y, PlayerId: playerId})
+		offset += 12
+	}
+	return info, nil
+}
+
+func EncodeLivesInfoUpdate(info *mines.LivesInfoUpdate) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(GamemodeInfo))
+	buf.WriteByte(byte(0x00))
+	// |gamemodeId|teamLives|len(playerLives)|playerId|lives|...|x|y|playerId|...
+	payloadLength := 1 + 2 + 2 + 6*len(info.PlayerLives) + 12*len(info.ExplodedMines)
+	if err := writePayloadLength(&buf, payloadLength); err != nil {
+		return nil, err
+	}
+	buf.WriteByte(byte(mines.ModeLives))
+	binary.Write(&buf, binary.BigEndian, uint16(info.TeamLives))
+	binary.Write(&buf, binary.BigEndian, uint16(len(info.PlayerLives)))
+	for playerId, lives := range info.PlayerLives {
+		binary.Write(&buf, binary.BigEndian, playerId)
+		binary.Write(&buf, binary.BigEndian, uint16(lives))
+	}
+	for _, cellInfo := range info.ExplodedMines {
+		binary.Write(&buf, binary.BigEndian, uint32(cellInfo.X))
+		binary.Write(&buf, binary.BigEndian, uint32(cellInfo.Y))
+		binary.Write(&buf, binary.BigEndian, cellInfo.PlayerId)
+	}
+	return buf.Bytes(), nil
+}
+
+func DecodeLivesInfoUpdate(data []byte) (*mines.LivesInfoUpdate, error) {
+	payloadLength, err := checkAndDecodeLength(data, GamemodeInfo)
+	if err != nil {
+		return nil, err
+	}
+	if payloadLength < 1+2+2 {
+		return nil, fmt.Errorf("Lives info too short: %w", ErrInvalidPayloadSize)
+	}
+	if data[HeaderLength] != byte(mines.ModeLives) {
+		return nil, fmt.Errorf("Wrong gamemodeId to decode Lives: %d: %w", data[HeaderLength], ErrInvalidPayload)
+	}
+	offset := HeaderLength + 1
+	info := &mines.LivesInfoUpdate{PlayerLives: make(map[uint32]int)}
+	info.TeamLives = int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	count := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	minesLength := payloadLength - (1 + 2 + 2 + 6*count)
+	if minesLength < 0 || minesLength%12 != 0 {
+		return nil, fmt.Errorf("Invalid lives info length %d for %d players: %w", payloadLength, count, ErrInvalidPayloadSize)
+	}
+	for range count {
+		playerId := binary.BigEndian.Uint32(data[offset : offset+4])
+		info.PlayerLives[playerId] = int(binary.BigEndian.Uint16(data[offset+4 : offset+6]))
+		offset += 6
+	}
+	for offset < len(data) {
+		X := bytesToInt(data[offset : offset+4])
+		Y := bytesToInt(data[offset+4 : offset+8])
+		playerId := binary.BigEndian.Uint32(data[offset+8 : offset+12])
+		info.ExplodedMines = append(info.ExplodedMines, mines.PlayerMarkChange{X: X, Y: Y, PlayerId: playerId})
+		offset += 12
+	}
+	return info, nil
+}
+
+func writeGameOption(buf *bytes.Buffer, id gameOptionId, value []byte) error {
+	buf.WriteByte(byte(id))
+	if err := binary.Write(buf, binary.BigEndian, uint16(len(value))); err != nil {
+		return err
+	}
+	_, err := buf.Write(value)
+	return err
+}
+
+func encodeGameOptions(params mines.GameParams) ([]byte, error) {
+	var buf bytes.Buffer
+	if params.FirstMoveSafe {
+		if err := writeGameOption(&buf, optionFirstMoveSafe, nil); err != nil {
+			return nil, err
+		}
+	}
+	if params.NoGuess {
+		if err := writeGameOption(&buf, optionNoGuess, nil); err != nil {
+			return nil, err
+		}
+	}
+	if params.QuestionMarks {
+		if err := writeGameOption(&buf, optionQuestionMarks, nil); err != nil {
+			return nil, err
+		}
+	}
+	if params.Lives > 0 {
+		value := binary.BigEndian.AppendUint16(nil, uint16(params.Lives))
+		if err := writeGameOption(&buf, optionLives, value); err != nil {
+			return nil, err
+		}
+	}
+	if params.LivesPerPlayer {
+		if err := writeGameOption(&buf, optionLivesPerPlayer, nil); err != nil {
+			return nil, err
+		}
+	}
+	if params.Topology != mines.TopologySquare {
+		if err := writeGameOption(&buf, optionTopology, []byte{byte(params.Topology)}); err != nil {
+			return nil, err
+		}
+	}
+	if params.Mask != nil {
+		value := params.Mask.Bytes()
+		if len(value) > math.MaxUint16 {
+			return nil, fmt.Errorf("mask of size (%d, %d) is too large to send", params.Mask.Width, params.Mask.Height)
+		}
+		if err := writeGameOption(&buf, optionMask, value); err != nil {
+			return nil, err
+		}
+	}
+	if params.Layout != nil {
+		value := mines.EncodeBoard(params.Layout)
+		if len(value) > math.MaxUint16 {
+			return nil, fmt.Errorf("layout of size (%d, %d) is too large to send", params.Layout.Width, params.Layout.Height)
+		}
+		if err := writeGameOption(&buf, optionLayout, value); err != nil {
+			return nil, err
+		}
+	}
+	if params.Infinite {
+		if err := writeGameOption(&buf, optionInfinite, nil); err != nil {
+			return nil, err
+		}
+	}
+	if params.AllowUndo {
+		if err := writeGameOption(&buf, optionAllowUndo, nil); err != nil {
+			return nil, err
+		}
+	}
+	if params.TimeLimit > 0 {
+		value := binary.BigEndian.AppendUint32(nil, uint32(params.TimeLimit.Milliseconds()))
+		if err := writeGameOption(&buf, optionTimeLimit, value); err != nil {
+			return nil, err
+		}
+	}
+	if params.TurnTime > 0 {
+		value := binary.BigEndian.AppendUint32(nil, uint32(params.TurnTime.Milliseconds()))
+		if err := writeGameOption(&buf, optionTurnTime, value); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeGameOptions(data []byte, params *mines.GameParams) error {
+	offset := 0
+	for offset < len(data) {
+		if len(data) < offset+3 {
+			return fmt.Errorf("game option header too short: %w", ErrInvalidPayloadSize)
+		}
+		id := gameOptionId(data[offset])
+		length := int(binary.BigEndian.Uint16(data[offset+1 : offset+3]))
+		offset += 3
+		if len(data) < offset+length {
+			return fmt.Errorf("game option %d value too short: %w", id, ErrInvalidPayloadSize)
+		}
+		switch id {
+		case optionFirstMoveSafe:
+			params.FirstMoveSafe = true
+		case optionNoGuess:
+			params.NoGuess = true
+		case optionQuestionMarks:
+			params.QuestionMarks = true
+		case optionTurnTime:
+			if length != 4 {
+				return fmt.Errorf("turn time option has length %d: %w", length, ErrInvalidPayloadSize)
+			}
+			milliseconds := binary.BigEndian.Uint32(data[offset : offset+4])
+			params.TurnTime = time.Duration(milliseconds) * time.Millisecond
+		case optionLives:
+			if length != 2 {
+				return fmt.Errorf("lives option has length %d: %w", length, ErrInvalidPayloadSize)
+			}
+			params.Lives = int(binary.BigEndian.Uint16(data[offset : offset+2]))
+		case optionLivesPerPlayer:
+			params.LivesPerPlayer = true
+		case optionTopology:
+			if length != 1 {
+				return fmt.Errorf("topology option has length %d: %w", length, ErrInvalidPayloadSize)
+			}
+			params.Topology = mines.TopologyId(data[offset])
+		case optionMask:
+			if uint64(length) != (uint64(params.Width)*uint64(params.Height)+7)/8 {
+				return fmt.Errorf("mask option of %d bytes for board (%d, %d): %w", length, params.Width, params.Height, ErrInvalidPayloadSize)
+			}
+			mask, err := mines.MaskFromBytes(params.Width, params.Height, data[offset:offset+length])
+			if err != nil {
+				return fmt.Errorf("mask option: %w: %w", ErrInvalidPayload, err)
+			}
+			params.Mask = mask
+		case optionLayout:
+			layout, err := mines.DecodeBoard(data[offset : offset+length])
+			if err != nil {
+				return fmt.Errorf("layout option: %w: %w", ErrInvalidPayload, err)
+			}
+			params.Layout = layout
+		case optionInfinite:
+			params.Infinite = true
+		case optionAllowUndo:
+			params.AllowUndo = true
+		case optionTimeLimit:
+			if length != 4 {
+				return fmt.Errorf("time limit option has length %d: %w", length, ErrInvalidPayloadSize)
+			}
+			milliseconds := binary.BigEndian.Uint32(data[offset : offset+4])
+			params.TimeLimit = time.Duration(milliseconds) * time.Millisecond
+		default:
+			// Unknown options are skipped so newer servers can talk to older clients
+		}
+		offset += length
+	}
+	return nil
+}
+
 func EncodeGameStart(params mines.GameParams) ([]byte, error) {
-	payloadLength := 3*4 + 1
+	options, err := encodeGameOptions(params)
+	if err != nil {
+		return nil, err
+	}
+	var flags byte = 0x00
+	if len(options) > 0 {
+		flags |= HasOptionsFlag
+	}
+	payloadLength := GameStartByteLength
 	var buf bytes.Buffer
 	buf.WriteByte(byte(StartGame))
-	buf.WriteByte(byte(0x00))
-	err := writePayloadLength(&buf, payloadLength)
+	buf.WriteByte(flags)
+	err = writePayloadLength(&buf, payloadLength+len(options))
 	if err != nil {
 		return nil, err
 	}
@@ -1063,7 +1697,9 @@ func EncodeGameStart(params mines.GameParams) ([]byte, error) {
 	copy(payload[4:8], intToBytes(params.Height))
 	copy(payload[8:12], intToBytes(params.Mines))
 	payload[12] = byte(params.GameMode)
+	binary.BigEndian.PutUint64(payload[13:21], uint64(params.Seed))
 	buf.Write(payload)
+	buf.Write(options)
 	return buf.Bytes(), nil
 
 }
@@ -1073,14 +1709,359 @@ func DecodeGameStart(data []byte) (*mines.GameParams, error) {
 	if err != nil {
 		return nil, err
 	}
-	if payloadLength != 3*4+1 {
-		return nil, fmt.Errorf("decode game starte payload incorrect length (%d)", payloadLength)
+	hasOptions := data[1]&HasOptionsFlag != 0
+	if payloadLength != GameStartByteLength && !(hasOptions && payloadLength > GameStartByteLength) {
+		return nil, fmt.Errorf("decode game starte payload incorrect length (%d): %w", payloadLength, ErrInvalidPayloadSize)
 	}
 	payload := data[HeaderLength:]
 	params := &mines.GameParams{Width: bytesToInt(payload[0:4]),
 		Height:   bytesToInt(payload[4:8]),
 		Mines:    bytesToInt(payload[8:12]),
 		GameMode: mines.GameModeId(payload[12]),
+		Seed:     int64(binary.BigEndian.Uint64(payload[13:21])),
+	}
+	if hasOptions {
+		if err := decodeGameOptions(payload[GameStartByteLength:], params); err != nil {
+			return nil, err
+		}
 	}
 	return params, nil
 }
+
+// Encodes the time elapsed since the first move of the game
+func EncodeGameTime(elapsed time.Duration) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(GameTime))
+	buf.WriteByte(byte(0x00))
+	if err := writePayloadLength(&buf, 4); err != nil {
+		return nil, err
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(elapsed.Milliseconds()))
+	return buf.Bytes(), nil
+}
+
+func DecodeGameTime(data []byte) (time.Duration, error) {
+	payloadLength, err := checkAndDecodeLength(data, GameTime)
+	if err != nil {
+		return 0, err
+	}
+	if payloadLength != 4 {
+		return 0, fmt.Errorf("Invalid game time length %d: %w", payloadLength, ErrInvalidPayloadSize)
+	}
+	milliseconds := binary.BigEndian.Uint32(data[HeaderLength : HeaderLength+4])
+	return time.Duration(milliseconds) * time.Millisecond, nil
+}
+
+func EncodeSubscribeChunks(chunks []mines.ChunkPos) ([]byte, error) {
+	return encodeChunks(chunks, SubscribeChunks)
+}
+
+func DecodeSubscribeChunks(data []byte) ([]mines.ChunkPos, error) {
+	return decodeChunks(data, SubscribeChunks)
+}
+
+func EncodeUnsubscribeChunks(chunks []mines.ChunkPos) ([]byte, error) {
+	return encodeChunks(chunks, UnsubscribeChunks)
+}
+
+func DecodeUnsubscribeChunks(data []byte) ([]mines.ChunkPos, error) {
+	return decodeChunks(data, UnsubscribeChunks)
+}
+
+// |len(chunks)|chunkX|chunkY|...
+func encodeChunks(chunks []mines.ChunkPos, tp MessageType) ([]byte, error) {
+	if len(chunks) > math.MaxUint16 {
+		return nil, fmt.Errorf("Too many chunks to send (%d)", len(chunks))
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(byte(tp))
+	buf.WriteByte(byte(0x00))
+	if err := writePayloadLength(&buf, 2+ChunkByteLength*len(chunks)); err != nil {
+		return nil, err
+	}
+	binary.Write(&buf, binary.BigEndian, uint16(len(chunks)))
+	for _, chunk := range chunks {
+		buf.Write(intToBytes(chunk.X))
+		buf.Write(intToBytes(chunk.Y))
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeChunks(data []byte, tp MessageType) ([]mines.ChunkPos, error) {
+	payloadLength, err := checkAndDecodeLength(data, tp)
+	if err != nil {
+		return nil, err
+	}
+	if payloadLength < 2 {
+		return nil, ErrInvalidPayloadSize
+	}
+	payload := data[HeaderLength:]
+	count := int(binary.BigEndian.Uint16(payload[0:2]))
+	if payloadLength != 2+ChunkByteLength*count {
+		return nil, fmt.Errorf("Invalid chunks length %d for %d chunks: %w", payloadLength, count, ErrInvalidPayloadSize)
+	}
+	chunks := make([]mines.ChunkPos, count)
+	for i := range chunks {
+		offset := 2 + i*ChunkByteLength
+		chunks[i] = mines.ChunkPos{X: bytesToCoordinate(payload[offset : offset+4]), Y: bytesToCoordinate(payload[offset+4 : offset+8])}
+	}
+	return chunks, nil
+}
+
+// Encodes updates of cells in one chunk of an infinite board. The cells are
+// sent relative to the origin of the chunk so they fit in a byte each.
+// |chunkX|chunkY|x|y|value|...
+func EncodeChunkCellUpdates(chunk mines.ChunkPos, cells []mines.UpdatedCell) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(ChunkCellUpdate))
+	buf.WriteByte(byte(0x00))
+	if err := writePayloadLength(&buf, ChunkByteLength+ChunkCellUpdateByteLength*len(cells)); err != nil {
+		return nil, err
+	}
+	buf.Write(intToBytes(chunk.X))
+	buf.Write(intToBytes(chunk.Y))
+	origin := chunk.Origin()
+	for _, cell := range cells {
+		if mines.ChunkOf(cell.X, cell.Y) != chunk {
+			return nil, fmt.Errorf("Cell (%d, %d) is not in chunk (%d, %d)", cell.X, cell.Y, chunk.X, chunk.Y)
+		}
+		buf.WriteByte(byte(cell.X - origin.X))
+		buf.WriteByte(byte(cell.Y - origin.Y))
+		buf.WriteByte(cell.Value)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decodes the chunk and its updated cells with coordinates on the board
+func DecodeChunkCellUpdates(data []byte) (mines.ChunkPos, []mines.UpdatedCell, error) {
+	payloadLength, err := checkAndDecodeLength(data, ChunkCellUpdate)
+	if err != nil {
+		return mines.ChunkPos{}, nil, err
+	}
+	if payloadLength < ChunkByteLength || (payloadLength-ChunkByteLength)%ChunkCellUpdateByteLength != 0 {
+		return mines.ChunkPos{}, nil, fmt.Errorf("chunk cell updates payload length mismatch %d: %w", payloadLength, ErrInvalidPayloadSize)
+	}
+	payload := data[HeaderLength:]
+	chunk := mines.ChunkPos{X: bytesToCoordinate(payload[0:4]), Y: bytesToCoordinate(payload[4:8])}
+	origin := chunk.Origin()
+	cells := make([]mines.UpdatedCell, (payloadLength-ChunkByteLength)/ChunkCellUpdateByteLength)
+	for i := range cells {
+		offset := ChunkByteLength + i*ChunkCellUpdateByteLength
+		x, y := int(payload[offset]), int(payload[offset+1])
+		if x >= mines.ChunkSize || y >= mines.ChunkSize {
+			return mines.ChunkPos{}, nil, fmt.Errorf("Cell (%d, %d) is outside of the chunk: %w", x, y, ErrInvalidPayload)
+		}
+		cells[i] = mines.UpdatedCell{X: origin.X + x, Y: origin.Y + y, Value: payload[offset+2]}
+	}
+	return chunk, cells, nil
+}
+
+// Asks the server to take back the last moves. Only executed for the player
+// who started the game or once most players asked for it.
+func EncodeUndoRequest(moves int) ([]byte, error) {
+	return encodeUndoMoves(moves, UndoRequest)
+}
+
+func DecodeUndoRequest(data []byte) (int, error) {
+	return decodeUndoMoves(data, UndoRequest)
+}
+
+// Lets the players know moves were taken back. The reverted cells are sent
+// as cell updates before it.
+func EncodeMovesUndone(moves int) ([]byte, error) {
+	return encodeUndoMoves(moves, MovesUndone)
+}
+
+func DecodeMovesUndone(data []byte) (int, error) {
+	return decodeUndoMoves(data, MovesUndone)
+}
+
+// |moves - uint16|
+func encodeUndoMoves(moves int, tp MessageType) ([]byte, error) {
+	if moves <= 0 || moves > math.MaxUint16 {
+		return nil, fmt.Errorf("Invalid number of moves to undo %d", moves)
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(byte(tp))
+	buf.WriteByte(byte(0x00))
+	if err := writePayloadLength(&buf, 2); err != nil {
+		return nil, err
+	}
+	binary.Write(&buf, binary.BigEndian, uint16(moves))
+	return buf.Bytes(), nil
+}
+
+func decodeUndoMoves(data []byte, tp MessageType) (int, error) {
+	payloadLength, err := checkAndDecodeLength(data, tp)
+	if err != nil {
+		return 0, err
+	}
+	if payloadLength != 2 {
+		return 0, fmt.Errorf("Invalid undo length %d: %w", payloadLength, ErrInvalidPayloadSize)
+	}
+	return int(binary.BigEndian.Uint16(data[HeaderLength : HeaderLength+2])), nil
+}
+
+// Encodes the payload behind the request id when there is one
+func encodeRequestMessage(tp MessageType, payload []byte, requestId *uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(tp))
+	var flag byte = 0x00
+	offset := 0
+	if requestId != nil {
+		flag |= HasIdFlag
+		offset += 4
+	}
+	buf.WriteByte(flag)
+	if err := writePayloadLength(&buf, len(payload)+offset); err != nil {
+		return nil, err
+	}
+	if requestId != nil {
+		if err := binary.Write(&buf, binary.BigEndian, *requestId); err != nil {
+			return nil, err
+		}
+	}
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
+
+// Returns the payload behind the request id when there is one
+func decodeRequestMessage(data []byte, tp MessageType, requestId *uint32) ([]byte, error) {
+	if _, err := checkAndDecodeLength(data, tp); err != nil {
+		return nil, err
+	}
+	offset := HeaderLength
+	if requestId != nil {
+		if err := GetRequestId(data, requestId); err != nil {
+			return nil, err
+		}
+		offset += 4
+	}
+	return data[offset:], nil
+}
+
+// Hands the replay of a finished match to the matchmaking server which stores
+// it. The replay is in the format of replay.Encode.
+func EncodeSaveReplay(replay []byte, requestId *uint32) ([]byte, error) {
+	return encodeRequestMessage(SaveReplay, replay, requestId)
+}
+
+func DecodeSaveReplay(data []byte, requestId *uint32) ([]byte, error) {
+	return decodeRequestMessage(data, SaveReplay, requestId)
+}
+
+// Answers SaveReplay with the id of the match the replay was stored as
+// |matchId - uint32|
+func EncodeReplaySaved(matchId uint32, requestId *uint32) ([]byte, error) {
+	return encodeRequestMessage(ReplaySaved, binary.BigEndian.AppendUint32(nil, matchId), requestId)
+}
+
+func DecodeReplaySaved(data []byte, requestId *uint32) (uint32, error) {
+	payload, err := decodeRequestMessage(data, ReplaySaved, requestId)
+	if err != nil {
+		return 0, err
+	}
+	if len(payload) != 4 {
+		return 0, ErrInvalidPayloadSize
+	}
+	return binary.BigEndian.Uint32(payload), nil
+}
+
+// Asks the matchmaking server for the replay of a match
+// |matchId - uint32|
+func EncodeReplayRequest(matchId uint32) ([]byte, error) {
+	return encodeRequestMessage(ReplayRequest, binary.BigEndian.AppendUint32(nil, matchId), nil)
+}
+
+func DecodeReplayRequest(data []byte) (uint32, error) {
+	payload, err := decodeRequestMessage(data, ReplayRequest, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(payload) != 4 {
+		return 0, ErrInvalidPayloadSize
+	}
+	return binary.BigEndian.Uint32(payload), nil
+}
+
+// |matchId - uint32|replay| The replay is empty when the match has none.
+func EncodeReplayResponse(matchId uint32, replay []byte) ([]byte, error) {
+	payload := binary.BigEndian.AppendUint32(nil, matchId)
+	return encodeRequestMessage(ReplayResponse, append(payload, replay...), nil)
+}
+
+func DecodeReplayResponse(data []byte) (uint32, []byte, error) {
+	payload, err := decodeRequestMessage(data, ReplayResponse, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(payload) < 4 {
+		return 0, nil, ErrInvalidPayloadSize
+	}
+	return binary.BigEndian.Uint32(payload), payload[4:], nil
+}
+
+// Asks the game server to only watch the game. Sent right after connecting.
+func EncodeSpectateRequest() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(SpectateRequest))
+	buf.WriteByte(byte(0x00))
+	if err := writePayloadLength(&buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func DecodeSpectateRequest(data []byte) error {
+	_, err := checkAndDecodeLength(data, SpectateRequest)
+	return err
+}
+
+func EncodeHintRequest() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(HintRequest))
+	buf.WriteByte(byte(0x00))
+	if err := writePayloadLength(&buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func DecodeHintRequest(data []byte) error {
+	_, err := checkAndDecodeLength(data, HintRequest)
+	return err
+}
+
+func EncodeHint(hint *solver.Hint) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(HintResponse))
+	buf.WriteByte(byte(0x00))
+	if err := writePayloadLength(&buf, HintByteLength); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, HintByteLength)
+	copy(payload[0:4], intToBytes(hint.X))
+	copy(payload[4:8], intToBytes(hint.Y))
+	payload[8] = byte(hint.Type)
+	// Probability is sent as a fraction of the maximal uint16
+	binary.BigEndian.PutUint16(payload[9:11], uint16(math.Round(hint.Probability*math.MaxUint16)))
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
+
+func DecodeHint(data []byte) (*solver.Hint, error) {
+	payloadLength, err := checkAndDecodeLength(data, HintResponse)
+	if err != nil {
+		return nil, err
+	}
+	if payloadLength != HintByteLength {
+		return nil, fmt.Errorf("hint payload incorrect length (%d): %w", payloadLength, ErrInvalidPayloadSize)
+	}
+	payload := data[HeaderLength:]
+	return &solver.Hint{
+		X:           bytesToInt(payload[0:4]),
+		Y:           bytesToInt(payload[4:8]),
+		Type:        mines.MoveType(payload[8]),
+		Probability: float64(binary.BigEndian.Uint16(payload[9:11])) / math.MaxUint16,
+	}, nil
+}
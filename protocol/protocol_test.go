@@ -2,15 +2,21 @@ package protocol_test
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/tomasstrnad1997/mines/mines"
+	"github.com/tomasstrnad1997/mines/mines/solver"
 	"github.com/tomasstrnad1997/mines/players"
 	"github.com/tomasstrnad1997/mines/protocol"
 )
 
 func TestServerInfoEncoding(t *testing.T) {
-	info := &protocol.GameServerInfo{"Game server 69", "127.0.0.1", 42069, 3}
+	info := &protocol.GameServerInfo{"Game server 69", "127.0.0.1", 42069, 3, 2}
 	encoded, err := protocol.EncodeGameServer(info)
 	if err != nil {
 		t.Fatalf("Failed to encode game info: %v", err)
@@ -28,9 +34,9 @@ func TestServerInfoEncoding(t *testing.T) {
 
 func TestServerInfoMessageEncoding(t *testing.T) {
 	servers := []*protocol.GameServerInfo{
-		{"Game server 69", "127.0.0.1", 42069, 3},
-		{"GS Rest", "192.168.0.1", 11111, 7},
-		{"FD Free", "10.0.0.5", 429, 0},
+		{"Game server 69", "127.0.0.1", 42069, 3, 0},
+		{"GS Rest", "192.168.0.1", 11111, 7, 12},
+		{"FD Free", "10.0.0.5", 429, 0, 1},
 	}
 
 	encoded, err := protocol.EncodeSendGameServers(servers, nil)
@@ -60,6 +66,7 @@ func TestGameConnectionResponse(t *testing.T) {
 			0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0xf7, 0x18,
 			0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0xf0,
 		},
+		Spectator: true,
 	}
 	gsInfo := protocol.GameServerConnectInfo{
 		Host: "test.com",
@@ -106,3 +113,930 @@ func TestGameConnectionResponseDeny(t *testing.T) {
 		t.Fatalf("success doesn't match")
 	}
 }
+
+func TestGameStartEncoding(t *testing.T) {
+	params := mines.GameParams{Width: 30, Height: 16, Mines: 99, GameMode: mines.ModeCoop, Seed: -8231945}
+	encoded, err := protocol.EncodeGameStart(params)
+	if err != nil {
+		t.Fatalf("Failed to encode game start: %v", err)
+	}
+	decoded, err := protocol.DecodeGameStart(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode game start: %v", err)
+	}
+	if *decoded != params {
+		t.Fatalf("Decoded game params do not match original")
+	}
+}
+
+func TestGameStartOptionsEncoding(t *testing.T) {
+	params := mines.GameParams{Width: 9, Height: 9, Mines: 10, Seed: 5, FirstMoveSafe: true, NoGuess: true, QuestionMarks: true, TurnTime: 15 * time.Second, Lives: 5, LivesPerPlayer: true, TimeLimit: 2 * time.Minute, Topology: mines.TopologyHex}
+	encoded, err := protocol.EncodeGameStart(params)
+	if err != nil {
+		t.Fatalf("Failed to encode game start: %v", err)
+	}
+	if encoded[1]&protocol.HasOptionsFlag == 0 {
+		t.Fatalf("Options flag not set for game with options")
+	}
+	decoded, err := protocol.DecodeGameStart(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode game start: %v", err)
+	}
+	if *decoded != params {
+		t.Fatalf("Decoded game params do not match original")
+	}
+
+	params.FirstMoveSafe = false
+	params.NoGuess = false
+	params.QuestionMarks = false
+	params.TurnTime = 0
+	params.Lives = 0
+	params.LivesPerPlayer = false
+	params.TimeLimit = 0
+	params.Topology = mines.TopologySquare
+	encoded, err = protocol.EncodeGameStart(params)
+	if err != nil {
+		t.Fatalf("Failed to encode game start: %v", err)
+	}
+	if encoded[1] != 0x00 || len(encoded) != protocol.HeaderLength+protocol.GameStartByteLength {
+		t.Fatalf("Game start without options does not use the plain layout")
+	}
+}
+
+func TestGameStartMaskEncoding(t *testing.T) {
+	mask, err := mines.ParseMask([]string{
+		".##.##.",
+		"#######",
+		"#######",
+		".#####.",
+		"..###..",
+		"...#...",
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse mask: %v", err)
+	}
+	params := mines.GameParams{Width: 7, Height: 6, Mines: 5, Seed: 3, Mask: mask}
+	encoded, err := protocol.EncodeGameStart(params)
+	if err != nil {
+		t.Fatalf("Failed to encode game start: %v", err)
+	}
+	// One bit per cell after the option header
+	if len(encoded) != protocol.HeaderLength+protocol.GameStartByteLength+3+6 {
+		t.Fatalf("Mask is not packed into bits, message length %d", len(encoded))
+	}
+	decoded, err := protocol.DecodeGameStart(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode game start: %v", err)
+	}
+	if !decoded.Mask.Equal(mask) {
+		t.Fatalf("Decoded mask\n%s\ndoes not match original\n%s", decoded.Mask, mask)
+	}
+	decoded.Mask = nil
+	params.Mask = nil
+	if *decoded != params {
+		t.Fatalf("Decoded game params do not match original")
+	}
+}
+
+func TestGameStartLayoutEncoding(t *testing.T) {
+	layout, err := mines.ParseBoard("..#O\n.-##\n#O##\n")
+	if err != nil {
+		t.Fatalf("Failed to parse board: %v", err)
+	}
+	encoded, err := protocol.EncodeGameStart(mines.GameParams{Width: 4, Height: 3, Mines: 2, Layout: layout})
+	if err != nil {
+		t.Fatalf("Failed to encode game start: %v", err)
+	}
+	decoded, err := protocol.DecodeGameStart(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode game start: %v", err)
+	}
+	if decoded.Layout == nil || mines.FormatBoard(decoded.Layout) != mines.FormatBoard(layout) {
+		t.Fatalf("Decoded layout does not match original")
+	}
+}
+
+func TestHintEncoding(t *testing.T) {
+	hint := &solver.Hint{X: 12, Y: 7, Type: mines.Reveal, Probability: 0.25}
+	encoded, err := protocol.EncodeHint(hint)
+	if err != nil {
+		t.Fatalf("Failed to encode hint: %v", err)
+	}
+	decoded, err := protocol.DecodeHint(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode hint: %v", err)
+	}
+	if decoded.X != hint.X || decoded.Y != hint.Y || decoded.Type != hint.Type {
+		t.Fatalf("Decoded hint does not match original")
+	}
+	if math.Abs(decoded.Probability-hint.Probability) > 1e-4 {
+		t.Fatalf("Decoded hint probability %f differs from %f", decoded.Probability, hint.Probability)
+	}
+}
+
+func TestMoveEncoding(t *testing.T) {
+	move := mines.Move{X: 3, Y: 14, Type: mines.Chord, PlayerId: 77}
+	encoded, err := protocol.EncodeMove(move)
+	if err != nil {
+		t.Fatalf("Failed to encode move: %v", err)
+	}
+	decoded, err := protocol.DecodeMove(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode move: %v", err)
+	}
+	if *decoded != move {
+		t.Fatalf("Decoded move does not match original")
+	}
+	move.Type = 0x7F
+	encoded, err = protocol.EncodeMove(move)
+	if err != nil {
+		t.Fatalf("Failed to encode move: %v", err)
+	}
+	if _, err = protocol.DecodeMove(encoded); err == nil {
+		t.Fatalf("Decoded move with unknown type")
+	}
+}
+
+func TestVersusInfoEncoding(t *testing.T) {
+	info := &mines.VersusInfoUpdate{Players: []mines.VersusPlayerProgress{
+		{PlayerId: 1, Progress: 42},
+		{PlayerId: 7, Progress: 3, Eliminated: true},
+	}}
+	encoded, err := protocol.EncodeGamemodeInfo(info)
+	if err != nil {
+		t.Fatalf("Failed to encode versus info: %v", err)
+	}
+	decoded, err := protocol.DecodeGamemodeInfo(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode versus info: %v", err)
+	}
+	versus, ok := decoded.(*mines.VersusInfoUpdate)
+	if !ok {
+		t.Fatalf("Decoded info is not a versus info")
+	}
+	if len(versus.Players) != len(info.Players) {
+		t.Fatalf("Decoded %d players instead of %d", len(versus.Players), len(info.Players))
+	}
+	for i, player := range info.Players {
+		if versus.Players[i] != player {
+			t.Fatalf("Decoded player %v does not match %v", versus.Players[i], player)
+		}
+	}
+}
+
+func TestTurnBasedInfoEncoding(t *testing.T) {
+	info := &mines.TurnBasedInfoUpdate{
+		CurrentPlayer: 2,
+		PlayerScores:  map[uint32]int{1: 12, 2: -7},
+		TurnTimeLeft:  4500 * time.Millisecond,
+	}
+	encoded, err := protocol.EncodeGamemodeInfo(info)
+	if err != nil {
+		t.Fatalf("Failed to encode turn based info: %v", err)
+	}
+	decoded, err := protocol.DecodeGamemodeInfo(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode turn based info: %v", err)
+	}
+	turnBased, ok := decoded.(*mines.TurnBasedInfoUpdate)
+	if !ok {
+		t.Fatalf("Decoded info is not a turn based info")
+	}
+	if turnBased.CurrentPlayer != info.CurrentPlayer || turnBased.TurnTimeLeft != info.TurnTimeLeft {
+		t.Fatalf("Decoded turn %d (%v) does not match %d (%v)", turnBased.CurrentPlayer, turnBased.TurnTimeLeft, info.CurrentPlayer, info.TurnTimeLeft)
+	}
+	if len(turnBased.PlayerScores) != len(info.PlayerScores) {
+		t.Fatalf("Decoded %d scores instead of %d", len(turnBased.PlayerScores), len(info.PlayerScores))
+	}
+	for playerId, score := range info.PlayerScores {
+		if turnBased.PlayerScores[playerId] != score {
+			t.Fatalf("Decoded score %d of player %d instead of %d", turnBased.PlayerScores[playerId], playerId, score)
+		}
+	}
+}
+
+func TestFlagsInfoEncoding(t *testing.T) {
+	info := &mines.FlagsInfoUpdate{
+		CurrentPlayer: 1,
+		ClaimedMines:  map[uint32]int{1: 6, 2: 3},
+		Winner:        1,
+		MarksChange:   []mines.PlayerMarkChange{{X: 4, Y: 2, PlayerId: 1}},
+	}
+	encoded, err := protocol.EncodeGamemodeInfo(info)
+	if err != nil {
+		t.Fatalf("Failed to encode flags info: %v", err)
+	}
+	decoded, err := protocol.DecodeGamemodeInfo(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode flags info: %v", err)
+	}
+	flags, ok := decoded.(*mines.FlagsInfoUpdate)
+	if !ok {
+		t.Fatalf("Decoded info is not a flags info")
+	}
+	if flags.CurrentPlayer != info.CurrentPlayer || flags.Winner != info.Winner {
+		t.Fatalf("Decoded turn or winner does not match")
+	}
+	for playerId, claimed := range info.ClaimedMines {
+		if flags.ClaimedMines[playerId] != claimed {
+			t.Fatalf("Decoded %d claimed mines of player %d instead of %d", flags.ClaimedMines[playerId], playerId, claimed)
+		}
+	}
+	if len(flags.MarksChange) != 1 || flags.MarksChange[0] != info.MarksChange[0] {
+		t.Fatalf("Decoded marks %v do not match %v", flags.MarksChange, info.MarksChange)
+	}
+}
+
+func TestLivesInfoEncoding(t *testing.T) {
+	info := &mines.LivesInfoUpdate{
+		TeamLives:     2,
+		PlayerLives:   map[uint32]int{3: 1},
+		ExplodedMines: []mines.PlayerMarkChange{{X: 1, Y: 8, PlayerId: 3}},
+	}
+	encoded, err := protocol.EncodeGamemodeInfo(info)
+	if err != nil {
+		t.Fatalf("Failed to encode lives info: %v", err)
+	}
+	decoded, err := protocol.DecodeGamemodeInfo(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode lives info: %v", err)
+	}
+	lives, ok := decoded.(*mines.LivesInfoUpdate)
+	if !ok {
+		t.Fatalf("Decoded info is not a lives info")
+	}
+	if lives.TeamLives != info.TeamLives || lives.PlayerLives[3] != 1 || len(lives.PlayerLives) != 1 {
+		t.Fatalf("Decoded lives do not match original")
+	}
+	if len(lives.ExplodedMines) != 1 || lives.ExplodedMines[0] != info.ExplodedMines[0] {
+		t.Fatalf("Decoded exploded mines %v do not match %v", lives.ExplodedMines, info.ExplodedMines)
+	}
+}
+
+func TestGameEndEncoding(t *testing.T) {
+	outcome := &mines.GameOutcome{
+		Reason:   mines.EndScoreReached,
+		PlayerId: 2,
+		Elapsed:  83250 * time.Millisecond,
+		Winners:  []uint32{2},
+		Placements: []mines.PlayerPlacement{
+			{PlayerId: 2, Place: 1},
+			{PlayerId: 1, Place: 2},
+			{PlayerId: 3, Place: 2},
+		},
+	}
+	encoded, err := protocol.EncodeGameEnd(protocol.Win, outcome)
+	if err != nil {
+		t.Fatalf("Failed to encode game end: %v", err)
+	}
+	endType, decoded, err := protocol.DecodeGameEnd(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode game end: %v", err)
+	}
+	if endType != protocol.Win || decoded.Reason != outcome.Reason || decoded.PlayerId != outcome.PlayerId || decoded.Elapsed != outcome.Elapsed {
+		t.Fatalf("Decoded game end does not match original")
+	}
+	if len(decoded.Winners) != 1 || decoded.Winners[0] != 2 {
+		t.Fatalf("Decoded winners %v do not match %v", decoded.Winners, outcome.Winners)
+	}
+	if len(decoded.Placements) != len(outcome.Placements) {
+		t.Fatalf("Decoded %d placements instead of %d", len(decoded.Placements), len(outcome.Placements))
+	}
+	for i, placement := range outcome.Placements {
+		if decoded.Placements[i] != placement {
+			t.Fatalf("Decoded placement %v does not match %v", decoded.Placements[i], placement)
+		}
+	}
+
+	encoded, err = protocol.EncodeGameEnd(protocol.Aborted, nil)
+	if err != nil {
+		t.Fatalf("Failed to encode game end: %v", err)
+	}
+	endType, decoded, err = protocol.DecodeGameEnd(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode game end: %v", err)
+	}
+	if endType != protocol.Aborted || decoded != nil {
+		t.Fatalf("Game end without outcome decoded as %d %v", endType, decoded)
+	}
+}
+
+func TestGameTimeEncoding(t *testing.T) {
+	elapsed := 95*time.Second + 120*time.Millisecond
+	encoded, err := protocol.EncodeGameTime(elapsed)
+	if err != nil {
+		t.Fatalf("Failed to encode game time: %v", err)
+	}
+	decoded, err := protocol.DecodeGameTime(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode game time: %v", err)
+	}
+	if decoded != elapsed {
+		t.Fatalf("Decoded game time %v does not match %v", decoded, elapsed)
+	}
+}
+
+func TestChunkSubscriptionEncoding(t *testing.T) {
+	chunks := []mines.ChunkPos{{X: 0, Y: 0}, {X: -3, Y: 7}, {X: 12, Y: -1}}
+	encoded, err := protocol.EncodeSubscribeChunks(chunks)
+	if err != nil {
+		t.Fatalf("Failed to encode subscription: %v", err)
+	}
+	decoded, err := protocol.DecodeSubscribeChunks(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode subscription: %v", err)
+	}
+	if len(decoded) != len(chunks) {
+		t.Fatalf("Decoded %d chunks instead of %d", len(decoded), len(chunks))
+	}
+	for i, chunk := range chunks {
+		if decoded[i] != chunk {
+			t.Fatalf("Decoded chunk %v does not match %v", decoded[i], chunk)
+		}
+	}
+	if _, err := protocol.DecodeUnsubscribeChunks(encoded); err == nil {
+		t.Fatalf("Decoded a subscription as an unsubscription")
+	}
+}
+
+func TestChunkCellUpdateEncoding(t *testing.T) {
+	chunk := mines.ChunkOf(-20, 5)
+	cells := []mines.UpdatedCell{{X: -20, Y: 5, Value: 3}, {X: -17, Y: 15, Value: mines.ShowFlag}}
+	encoded, err := protocol.EncodeChunkCellUpdates(chunk, cells)
+	if err != nil {
+		t.Fatalf("Failed to encode chunk updates: %v", err)
+	}
+	decodedChunk, decoded, err := protocol.DecodeChunkCellUpdates(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode chunk updates: %v", err)
+	}
+	if decodedChunk != chunk || len(decoded) != len(cells) {
+		t.Fatalf("Decoded chunk %v with %d cells", decodedChunk, len(decoded))
+	}
+	for i, cell := range cells {
+		if decoded[i] != cell {
+			t.Fatalf("Decoded cell %v does not match %v", decoded[i], cell)
+		}
+	}
+	if _, err := protocol.EncodeChunkCellUpdates(chunk, []mines.UpdatedCell{{X: 0, Y: 0}}); err == nil {
+		t.Fatalf("Encoded a cell outside of the chunk")
+	}
+	params := mines.GameParams{Mines: 40, GameMode: mines.ModeCoop, Seed: 8, Infinite: true}
+	encoded, err = protocol.EncodeGameStart(params)
+	if err != nil {
+		t.Fatalf("Failed to encode game start: %v", err)
+	}
+	decodedParams, err := protocol.DecodeGameStart(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode game start: %v", err)
+	}
+	if *decodedParams != params {
+		t.Fatalf("Decoded params %v do not match %v", decodedParams, params)
+	}
+}
+
+// Messages the server sends a player joining a running game on a huge board
+func BenchmarkInitialMessages(b *testing.B) {
+	game, err := mines.CreateGame(mines.GameParams{Width: 3000, Height: 3000, Mines: 3000 * 3000 / 5, GameMode: mines.ModeCoop, FirstMoveSafe: true})
+	if err != nil {
+		b.Fatalf("Failed to create game: %v", err)
+	}
+	if _, _, err := game.MakeMove(mines.Move{X: 1500, Y: 1500, Type: mines.Reveal, PlayerId: 1}); err != nil {
+		b.Fatalf("Failed to reveal: %v", err)
+	}
+	b.ResetTimer()
+	for range b.N {
+		if _, err := protocol.EncodeGameStart(game.Params); err != nil {
+			b.Fatalf("Failed to encode game start: %v", err)
+		}
+		cellUpdates, err := game.GetPlayerChangedCellUpdates(2)
+		if err != nil {
+			b.Fatalf("Failed to get changed cells: %v", err)
+		}
+		if _, err := protocol.EncodeCellUpdates(cellUpdates); err != nil {
+			b.Fatalf("Failed to encode cell updates: %v", err)
+		}
+	}
+}
+
+func TestUndoEncoding(t *testing.T) {
+	encoded, err := protocol.EncodeUndoRequest(3)
+	if err != nil {
+		t.Fatalf("Failed to encode undo request: %v", err)
+	}
+	moves, err := protocol.DecodeUndoRequest(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode undo request: %v", err)
+	}
+	if moves != 3 {
+		t.Fatalf("Decoded %d moves instead of 3", moves)
+	}
+	if _, err := protocol.DecodeMovesUndone(encoded); err == nil {
+		t.Fatalf("Decoded an undo request as undone moves")
+	}
+	if _, err := protocol.EncodeUndoRequest(0); err == nil {
+		t.Fatalf("Encoded an undo of no moves")
+	}
+	params := mines.GameParams{Width: 4, Height: 4, Mines: 2, GameMode: mines.ModeCoop, AllowUndo: true}
+	encoded, err = protocol.EncodeGameStart(params)
+	if err != nil {
+		t.Fatalf("Failed to encode game start: %v", err)
+	}
+	decoded, err := protocol.DecodeGameStart(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode game start: %v", err)
+	}
+	if !decoded.AllowUndo {
+		t.Fatalf("Undo option was lost")
+	}
+}
+
+func TestReplayEncoding(t *testing.T) {
+	replay := []byte("MR replay")
+	requestId := uint32(9)
+	encoded, err := protocol.EncodeSaveReplay(replay, &requestId)
+	if err != nil {
+		t.Fatalf("Failed to encode replay: %v", err)
+	}
+	var decodedId uint32
+	decoded, err := protocol.DecodeSaveReplay(encoded, &decodedId)
+	if err != nil {
+		t.Fatalf("Failed to decode replay: %v", err)
+	}
+	if decodedId != requestId || !bytes.Equal(decoded, replay) {
+		t.Fatalf("Decoded replay %q of request %d", decoded, decodedId)
+	}
+	encoded, err = protocol.EncodeReplaySaved(42, &requestId)
+	if err != nil {
+		t.Fatalf("Failed to encode saved replay: %v", err)
+	}
+	if matchId, err := protocol.DecodeReplaySaved(encoded, &decodedId); err != nil || matchId != 42 {
+		t.Fatalf("Decoded match %d: %v", matchId, err)
+	}
+	encoded, err = protocol.EncodeReplayResponse(42, nil)
+	if err != nil {
+		t.Fatalf("Failed to encode replay response: %v", err)
+	}
+	matchId, decoded, err := protocol.DecodeReplayResponse(encoded)
+	if err != nil || matchId != 42 || len(decoded) != 0 {
+		t.Fatalf("Decoded missing replay of match %d as %q: %v", matchId, decoded, err)
+	}
+	encoded, err = protocol.EncodeReplayRequest(42)
+	if err != nil {
+		t.Fatalf("Failed to encode replay request: %v", err)
+	}
+	if _, _, err := protocol.DecodeReplayResponse(encoded); err == nil {
+		t.Fatalf("Decoded a replay request as a response")
+	}
+}
+
+func TestSpectateRequestEncoding(t *testing.T) {
+	encoded, err := protocol.EncodeSpectateRequest()
+	if err != nil {
+		t.Fatalf("Failed to encode spectate request: %v", err)
+	}
+	if err := protocol.DecodeSpectateRequest(encoded); err != nil {
+		t.Fatalf("Failed to decode spectate request: %v", err)
+	}
+	if err := protocol.DecodeHintRequest(encoded); err == nil {
+		t.Fatalf("Decoded a spectate request as a hint request")
+	}
+}
+
+func TestHandshakeEncoding(t *testing.T) {
+	info := protocol.HandshakeInfo{Version: 7, Features: protocol.FeatureChunks | protocol.FeatureReplays}
+	encoded, err := protocol.EncodeHandshake(info)
+	if err != nil {
+		t.Fatalf("Failed to encode handshake: %v", err)
+	}
+	decoded, err := protocol.DecodeHandshake(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode handshake: %v", err)
+	}
+	if decoded != info {
+		t.Fatalf("Decoded handshake %+v does not match %+v", decoded, info)
+	}
+	if !decoded.Supports(protocol.FeatureChunks) || decoded.Supports(protocol.FeatureChunks|protocol.FeatureUndo) {
+		t.Fatalf("Handshake supports wrong features %b", decoded.Features)
+	}
+	encoded, err = protocol.EncodeHandshakeRejected(3, "Too old")
+	if err != nil {
+		t.Fatalf("Failed to encode handshake rejection: %v", err)
+	}
+	version, reason, err := protocol.DecodeHandshakeRejected(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode handshake rejection: %v", err)
+	}
+	if version != 3 || reason != "Too old" {
+		t.Fatalf("Decoded rejection of version %d: %q", version, reason)
+	}
+}
+
+// Accepts a single connection with a controller configured by setup and
+// reads from it until the connection is lost
+func acceptController(t *testing.T, setup func(*protocol.ConnectionController)) (uint16, <-chan *protocol.ConnectionController, <-chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	accepted := make(chan *protocol.ConnectionController, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		controller := protocol.CreateConnectionController()
+		setup(controller)
+		controller.SetConnection(conn)
+		accepted <- controller
+		readErr <- controller.ReadServerResponse()
+	}()
+	return uint16(listener.Addr().(*net.TCPAddr).Port), accepted, readErr
+}
+
+func TestControllerHandshake(t *testing.T) {
+	features := protocol.FeatureChunks | protocol.FeatureUndo
+	port, accepted, _ := acceptController(t, func(controller *protocol.ConnectionController) {
+		controller.Features = features
+	})
+	dialer := protocol.CreateConnectionController()
+	texts := make(chan string, 1)
+	dialer.RegisterHandler(protocol.TextMessage, func(data []byte) error {
+		msg, err := protocol.DecodeTextMessage(data)
+		texts <- msg
+		return err
+	})
+	if err := dialer.Connect("localhost", port); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go dialer.ReadServerResponse()
+	acceptor := <-accepted
+	// Queued before the handshake is done, it gets written after the answer
+	encoded, err := protocol.EncodeTextMessage("Hello")
+	if err != nil {
+		t.Fatalf("Failed to encode text message: %v", err)
+	}
+	if err := acceptor.SendMessage(encoded); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	select {
+	case msg := <-texts:
+		if msg != "Hello" {
+			t.Fatalf("Received %q instead of the sent message", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Message was not received")
+	}
+	want := protocol.HandshakeInfo{Version: protocol.ProtocolVersion, Features: features}
+	if dialer.Peer() != want || acceptor.Peer() != want {
+		t.Fatalf("Peers agreed on %+v and %+v instead of %+v", dialer.Peer(), acceptor.Peer(), want)
+	}
+}
+
+func TestControllerFeatureFallback(t *testing.T) {
+	port, accepted, _ := acceptController(t, func(controller *protocol.ConnectionController) {})
+	dialer := protocol.CreateConnectionController()
+	dialer.Features = protocol.FeatureChunks
+	texts := make(chan string, 3)
+	dialer.RegisterHandler(protocol.TextMessage, func(data []byte) error {
+		msg, err := protocol.DecodeTextMessage(data)
+		texts <- msg
+		return err
+	})
+	if err := dialer.Connect("localhost", port); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go dialer.ReadServerResponse()
+	acceptor := <-accepted
+	send := func(features protocol.Features, text string, fallback string) {
+		encoded, err := protocol.EncodeTextMessage(text)
+		if err != nil {
+			t.Fatalf("Failed to encode text message: %v", err)
+		}
+		var encodedFallback []byte
+		if fallback != "" {
+			if encodedFallback, err = protocol.EncodeTextMessage(fallback); err != nil {
+				t.Fatalf("Failed to encode text message: %v", err)
+			}
+		}
+		if err := acceptor.SendFeatureMessage(features, encoded, encodedFallback); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+	send(protocol.FeatureReplays, "Replay", "")
+	send(protocol.FeatureUndo, "Undo", "No undo")
+	send(protocol.FeatureChunks, "Chunks", "No chunks")
+	for _, want := range []string{"No undo", "Chunks"} {
+		select {
+		case msg := <-texts:
+			if msg != want {
+				t.Fatalf("Received %q instead of %q", msg, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Message %q was not received", want)
+		}
+	}
+}
+
+func TestControllerRejectsOldPeers(t *testing.T) {
+	port, _, acceptErr := acceptController(t, func(controller *protocol.ConnectionController) {
+		controller.MinVersion = protocol.ProtocolVersion + 1
+	})
+	dialer := protocol.CreateConnectionController()
+	if err := dialer.Connect("localhost", port); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	var mismatch *protocol.VersionMismatchError
+	if err := dialer.ReadServerResponse(); !errors.As(err, &mismatch) {
+		t.Fatalf("Expected a version mismatch, got: %v", err)
+	}
+	if mismatch.Remote != protocol.ProtocolVersion || mismatch.Reason == "" {
+		t.Fatalf("Rejection %+v does not describe the server", mismatch)
+	}
+	if err := <-acceptErr; !errors.As(err, &mismatch) {
+		t.Fatalf("Expected the server to fail with a version mismatch, got: %v", err)
+	}
+}
+
+// Decodes a message of any type with the decoder of its type byte
+func decodeMessage(data []byte) error {
+	if len(data) < 2 {
+		_, err := protocol.DecodeMove(data)
+		return err
+	}
+	var requestId *uint32
+	if data[1]&protocol.HasIdFlag != 0 {
+		requestId = new(uint32)
+	}
+	var err error
+	switch protocol.MessageType(data[0]) {
+	case protocol.MoveCommand:
+		_, err = protocol.DecodeMove(data)
+	case protocol.TextMessage:
+		_, err = protocol.DecodeTextMessage(data)
+	case protocol.Board:
+		_, err = protocol.DecodeBoard(data)
+	case protocol.StartGame:
+		_, err = protocol.DecodeGameStart(data)
+	case protocol.CellUpdate:
+		_, err = protocol.DecodeCellUpdates(data)
+	case protocol.GameEnd:
+		_, _, err = protocol.DecodeGameEnd(data)
+	case protocol.GamemodeInfo:
+		_, err = protocol.DecodeGamemodeInfo(data)
+	case protocol.HintRequest:
+		err = protocol.DecodeHintRequest(data)
+	case protocol.HintResponse:
+		_, err = protocol.DecodeHint(data)
+	case protocol.GameTime:
+		_, err = protocol.DecodeGameTime(data)
+	case protocol.SubscribeChunks:
+		_, err = protocol.DecodeSubscribeChunks(data)
+	case protocol.UnsubscribeChunks:
+		_, err = protocol.DecodeUnsubscribeChunks(data)
+	case protocol.ChunkCellUpdate:
+		_, _, err = protocol.DecodeChunkCellUpdates(data)
+	case protocol.UndoRequest:
+		_, err = protocol.DecodeUndoRequest(data)
+	case protocol.MovesUndone:
+		_, err = protocol.DecodeMovesUndone(data)
+	case protocol.SpectateRequest:
+		err = protocol.DecodeSpectateRequest(data)
+	case protocol.SpawnServerRequest:
+		_, err = protocol.DecodeSpawnServerRequest(data, requestId)
+	case protocol.SendGameServers:
+		_, err = protocol.DecodeSendGameServers(data, requestId)
+	case protocol.GetGameServers:
+		err = protocol.DecodeGetGameServers(data, requestId)
+	case protocol.ServerSpawned:
+		_, err = protocol.DecodeServerSpawned(data, requestId)
+	case protocol.SaveReplay:
+		_, err = protocol.DecodeSaveReplay(data, requestId)
+	case protocol.ReplaySaved:
+		_, err = protocol.DecodeReplaySaved(data, requestId)
+	case protocol.RegisterPlayerRequest:
+		_, err = protocol.DecodeRegisterPlayerRequest(data)
+	case protocol.RegisterPlayerResponse:
+		_, err = protocol.DecodeRegisterPlayerResponse(data)
+	case protocol.AuthRequest:
+		_, err = protocol.DecodeAuthRequest(data)
+	case protocol.AuthResponseMessage:
+		_, err = protocol.DecodeAuthResponse(data)
+	case protocol.ConnectToGameRequest:
+		_, err = protocol.DecodeConnectToGameRequest(data)
+	case protocol.ConnectToGameResponse:
+		_, err = protocol.DecodeConnectToGameResponse(data)
+	case protocol.AuthWithMMToken:
+		_, err = protocol.DecodeAuthWithMMToken(data)
+	case protocol.ReplayRequest:
+		_, err = protocol.DecodeReplayRequest(data)
+	case protocol.ReplayResponse:
+		_, _, err = protocol.DecodeReplayResponse(data)
+	case protocol.Handshake:
+		_, err = protocol.DecodeHandshake(data)
+	case protocol.HandshakeRejected:
+		_, _, err = protocol.DecodeHandshakeRejected(data)
+	default:
+		_, err = protocol.DecodeMove(data)
+	}
+	return err
+}
+
+// Malformed messages have to fail with one of the errors of the protocol
+func isDecodeError(err error) bool {
+	return errors.Is(err, protocol.ErrInvalidPayloadSize) ||
+		errors.Is(err, protocol.ErrMessageTooShort) ||
+		errors.Is(err, protocol.ErrUnexpectedMessageType) ||
+		errors.Is(err, protocol.ErrInvalidPayload)
+}
+
+// A valid message of every type that has a decoder
+func encodedMessages(tb testing.TB) [][]byte {
+	tb.Helper()
+	requestId := uint32(42)
+	token := players.AuthToken{PlayerID: 5, ServerID: 3, Expiry: 1700000000, Spectator: true}
+	server := &protocol.GameServerInfo{"Game server 69", "127.0.0.1", 42069, 3, 1}
+	board, err := mines.CreateBoard(3, 2, 2, 4)
+	if err != nil {
+		tb.Fatalf("Failed to create board: %v", err)
+	}
+	mask, err := mines.ParseMask([]string{"#.#", "###"})
+	if err != nil {
+		tb.Fatalf("Failed to parse mask: %v", err)
+	}
+	layout, err := mines.ParseBoard("..#O\n.-##\n#O##\n")
+	if err != nil {
+		tb.Fatalf("Failed to parse board: %v", err)
+	}
+	outcome := &mines.GameOutcome{Reason: mines.EndScoreReached, PlayerId: 2, Elapsed: time.Second, Winners: []uint32{2}, Placements: []mines.PlayerPlacement{{PlayerId: 2, Place: 1}}}
+	marks := []mines.PlayerMarkChange{{X: -1, Y: 4, PlayerId: 2}}
+	encoders := []func() ([]byte, error){
+		func() ([]byte, error) {
+			return protocol.EncodeMove(mines.Move{X: -3, Y: 14, Type: mines.Flag, PlayerId: 7})
+		},
+		func() ([]byte, error) { return protocol.EncodeTextMessage("Hello") },
+		func() ([]byte, error) { return protocol.EncodeBoard(board) },
+		func() ([]byte, error) {
+			return protocol.EncodeGameStart(mines.GameParams{Width: 3, Height: 2, Mines: 1, FirstMoveSafe: true, Lives: 3, TurnTime: time.Second, Mask: mask})
+		},
+		func() ([]byte, error) {
+			return protocol.EncodeGameStart(mines.GameParams{Width: 4, Height: 3, Mines: 2, Layout: layout})
+		},
+		func() ([]byte, error) {
+			return protocol.EncodeCellUpdates([]mines.UpdatedCell{{X: 1, Y: -2, Value: 3}})
+		},
+		func() ([]byte, error) { return protocol.EncodeGameEnd(protocol.Win, outcome) },
+		func() ([]byte, error) {
+			return protocol.EncodeCoopInfoUpdate(&mines.CoopInfoUpdate{MarksChange: marks, PlayerScores: map[uint32]int{2: 5}})
+		},
+		func() ([]byte, error) {
+			return protocol.EncodeVersusInfoUpdate(&mines.VersusInfoUpdate{Players: []mines.VersusPlayerProgress{{PlayerId: 1, Progress: 40}}})
+		},
+		func() ([]byte, error) {
+			return protocol.EncodeTurnBasedInfoUpdate(&mines.TurnBasedInfoUpdate{CurrentPlayer: 1, PlayerScores: map[uint32]int{1: -2}})
+		},
+		func() ([]byte, error) {
+			return protocol.EncodeFlagsInfoUpdate(&mines.FlagsInfoUpdate{CurrentPlayer: 1, ClaimedMines: map[uint32]int{1: 3}, MarksChange: marks})
+		},
+		func() ([]byte, error) {
+			return protocol.EncodeLivesInfoUpdate(&mines.LivesInfoUpdate{TeamLives: 2, PlayerLives: map[uint32]int{1: 1}, ExplodedMines: marks})
+		},
+		protocol.EncodeHintRequest,
+		func() ([]byte, error) {
+			return protocol.EncodeHint(&solver.Hint{X: 1, Y: 2, Type: mines.Reveal, Probability: 0.5})
+		},
+		func() ([]byte, error) { return protocol.EncodeGameTime(time.Minute) },
+		func() ([]byte, error) { return protocol.EncodeSubscribeChunks([]mines.ChunkPos{{X: -1, Y: 2}}) },
+		func() ([]byte, error) { return protocol.EncodeUnsubscribeChunks([]mines.ChunkPos{{X: 0, Y: 0}}) },
+		func() ([]byte, error) {
+			return protocol.EncodeChunkCellUpdates(mines.ChunkPos{X: 0, Y: -1}, []mines.UpdatedCell{{X: 1, Y: -1, Value: 2}})
+		},
+		func() ([]byte, error) { return protocol.EncodeUndoRequest(2) },
+		func() ([]byte, error) { return protocol.EncodeMovesUndone(1) },
+		protocol.EncodeSpectateRequest,
+		func() ([]byte, error) { return protocol.EncodeSpawnServerRequest("Game", &requestId) },
+		func() ([]byte, error) {
+			return protocol.EncodeSendGameServers([]*protocol.GameServerInfo{server}, &requestId)
+		},
+		func() ([]byte, error) { return protocol.EncodeGetGameServers(&requestId) },
+		func() ([]byte, error) { return protocol.EncodeServerSpawned(server, &requestId) },
+		func() ([]byte, error) { return protocol.EncodeSaveReplay([]byte{1, 2, 3}, &requestId) },
+		func() ([]byte, error) { return protocol.EncodeReplaySaved(9, &requestId) },
+		func() ([]byte, error) {
+			return protocol.EncodeRegisterPlayerRequest(protocol.AuthPlayerParams{Name: "Player", Password: "secret"})
+		},
+		func() ([]byte, error) { return protocol.EncodeRegisterPlayerResponse(true) },
+		func() ([]byte, error) {
+			return protocol.EncodeAuthRequest(protocol.AuthPlayerParams{Name: "Player", Password: "secret"})
+		},
+		func() ([]byte, error) {
+			return protocol.EncodeAuthResponse(protocol.AuthResponse{Success: true, Player: &players.PlayerInfo{ID: 5, Name: "Player"}})
+		},
+		func() ([]byte, error) { return protocol.EncodeConnectToGameRequest(3) },
+		func() ([]byte, error) {
+			return protocol.EncodeConnectToGameResponse(protocol.GameConnectionResponse{Success: true, Token: &token, GameInfo: &protocol.GameServerConnectInfo{Host: "127.0.0.1", Port: 42069}})
+		},
+		func() ([]byte, error) { return protocol.EncodeAuthWithMMToken(token) },
+		func() ([]byte, error) { return protocol.EncodeReplayRequest(9) },
+		func() ([]byte, error) { return protocol.EncodeReplayResponse(9, []byte{1, 2, 3}) },
+		func() ([]byte, error) {
+			return protocol.EncodeHandshake(protocol.HandshakeInfo{Version: protocol.ProtocolVersion, Features: protocol.SupportedFeatures})
+		},
+		func() ([]byte, error) { return protocol.EncodeHandshakeRejected(protocol.ProtocolVersion, "Too old") },
+	}
+	messages := make([][]byte, len(encoders))
+	for i, encode := range encoders {
+		encoded, err := encode()
+		if err != nil {
+			tb.Fatalf("Failed to encode message %d: %v", i, err)
+		}
+		if err := decodeMessage(encoded); err != nil {
+			tb.Fatalf("Failed to decode message 0x%X: %v", encoded[0], err)
+		}
+		messages[i] = encoded
+	}
+	return messages
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, message := range encodedMessages(f) {
+		f.Add(message)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := decodeMessage(data); err != nil && !isDecodeError(err) {
+			t.Fatalf("Decoding 0x%X failed with an untyped error: %v", data, err)
+		}
+	})
+}
+
+func FuzzDecodeGameServer(f *testing.F) {
+	encoded, err := protocol.EncodeGameServer(&protocol.GameServerInfo{"Game server 69", "127.0.0.1", 42069, 3, 1})
+	if err != nil {
+		f.Fatalf("Failed to encode game info: %v", err)
+	}
+	f.Add(encoded)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := protocol.DecodeGameServer(bytes.NewReader(data)); err != nil && !isDecodeError(err) {
+			t.Fatalf("Decoding game info failed with an untyped error: %v", err)
+		}
+	})
+}
+
+// Every message cut short, with the length in its header fixed up to match
+func TestDecodeTruncatedMessages(t *testing.T) {
+	for _, message := range encodedMessages(t) {
+		for length := range len(message) {
+			cut := bytes.Clone(message[:length])
+			if length >= protocol.HeaderLength {
+				binary.BigEndian.PutUint32(cut[2:protocol.HeaderLength], uint32(length-protocol.HeaderLength))
+			}
+			if err := decodeMessage(cut); err != nil && !isDecodeError(err) {
+				t.Fatalf("Decoding 0x%X cut to %d bytes failed with an untyped error: %v", message[0], length, err)
+			}
+		}
+	}
+}
+
+// Lengths inside the payload pointing past its end
+func TestDecodeInvalidLengths(t *testing.T) {
+	header := func(tp protocol.MessageType, payload []byte) []byte {
+		data := []byte{byte(tp), 0x00}
+		data = binary.BigEndian.AppendUint32(data, uint32(len(payload)))
+		return append(data, payload...)
+	}
+	if _, err := protocol.DecodeMove(header(protocol.MoveCommand, []byte{byte(mines.Reveal)})); !errors.Is(err, protocol.ErrInvalidPayloadSize) {
+		t.Fatalf("Expected short move to fail with invalid payload size, got: %v", err)
+	}
+	params := binary.BigEndian.AppendUint32(nil, math.MaxInt32)
+	if _, err := protocol.DecodeAuthRequest(header(protocol.AuthRequest, append(params, "Player"...))); !errors.Is(err, protocol.ErrInvalidPayloadSize) {
+		t.Fatalf("Expected name past the payload to fail with invalid payload size, got: %v", err)
+	}
+	coop := []byte{byte(mines.ModeCoop), 0x00, 0x03}
+	if _, err := protocol.DecodeGamemodeInfo(header(protocol.GamemodeInfo, coop)); !errors.Is(err, protocol.ErrInvalidPayloadSize) {
+		t.Fatalf("Expected coop scores past the payload to fail with invalid payload size, got: %v", err)
+	}
+	// A string claiming 2GB must not allocate it
+	server := binary.BigEndian.AppendUint32(nil, math.MaxInt32)
+	if _, err := protocol.DecodeGameServer(bytes.NewReader(append(server, "Game"...))); !errors.Is(err, protocol.ErrInvalidPayloadSize) {
+		t.Fatalf("Expected string past the payload to fail with invalid payload size, got: %v", err)
+	}
+	start := make([]byte, protocol.GameStartByteLength)
+	binary.BigEndian.PutUint32(start[0:4], math.MaxInt32)
+	binary.BigEndian.PutUint32(start[4:8], math.MaxInt32)
+	start = append(start, 0x09, 0x00, 0x01, 0xFF)
+	message := header(protocol.StartGame, start)
+	message[1] = protocol.HasOptionsFlag
+	if _, err := protocol.DecodeGameStart(message); !errors.Is(err, protocol.ErrInvalidPayloadSize) {
+		t.Fatalf("Expected mask of a huge board to fail with invalid payload size, got: %v", err)
+	}
+	var requestId uint32
+	if err := protocol.GetRequestId(nil, &requestId); !errors.Is(err, protocol.ErrInvalidPayloadSize) {
+		t.Fatalf("Expected request id of an empty message to fail with invalid payload size, got: %v", err)
+	}
+	if err := protocol.CreateConnectionController().HandleMessage(nil); !errors.Is(err, protocol.ErrMessageTooShort) {
+		t.Fatalf("Expected empty message to fail as too short, got: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/tomasstrnad1997/mines/mines"
+	"github.com/tomasstrnad1997/mines/mines/replay"
+)
+
+// Checkpoint format:
+// |magic "MC"|game snapshot|replay|
+// Both are prefixed by their length as uvarints. The replay is empty when the
+// match isn't recorded. Checkpoints of older servers hold only the snapshot.
+var checkpointMagic = []byte("MC")
+
+func encodeCheckpoint(snapshot []byte, recorded *replay.Replay) []byte {
+	var encoded []byte
+	if recorded != nil {
+		encoded = replay.Encode(recorded)
+	}
+	data := bytes.Clone(checkpointMagic)
+	data = binary.AppendUvarint(data, uint64(len(snapshot)))
+	data = append(data, snapshot...)
+	data = binary.AppendUvarint(data, uint64(len(encoded)))
+	return append(data, encoded...)
+}
+
+// Returns the game snapshot and the replay of the checkpoint, nil when the
+// match wasn't recorded
+func decodeCheckpoint(data []byte) ([]byte, *replay.Replay, error) {
+	if !bytes.HasPrefix(data, checkpointMagic) {
+		return data, nil, nil
+	}
+	r := mines.NewReader("Checkpoint", data[len(checkpointMagic):])
+	snapshot := r.Raw(r.UCount(1))
+	encoded := r.Raw(r.UCount(1))
+	if err := r.Close(); err != nil {
+		return nil, nil, err
+	}
+	if len(encoded) == 0 {
+		return snapshot, nil, nil
+	}
+	recorded, err := replay.Decode(encoded)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, recorded, nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tomasstrnad1997/mines/protocol"
+)
+
+// Holds back the messages of a connection and sends them once they are older
+// than the delay, e.g. so spectators of a live match can't help its players.
+// Messages keep their order, ones that aren't delayed still wait for the
+// messages queued before them.
+type delayedQueue struct {
+	controller *protocol.ConnectionController
+	delay      time.Duration
+	mux        sync.Mutex
+	messages   []delayedMessage
+	// A goroutine is sending the queued messages. It stops when the queue
+	// empties. The message being sent stays queued until it is written.
+	sending bool
+}
+
+type delayedMessage struct {
+	outgoing
+	// When the message is due to be sent
+	at time.Time
+}
+
+func newDelayedQueue(controller *protocol.ConnectionController, delay time.Duration) *delayedQueue {
+	return &delayedQueue{controller: controller, delay: delay}
+}
+
+// Queues the message, delayed ones are sent once they are older than the
+// delay. Reports whether the message was queued, messages that aren't delayed
+// and have nothing to wait for are left to the caller to send right away.
+func (queue *delayedQueue) push(message outgoing, now time.Time, delayed bool) bool {
+	queue.mux.Lock()
+	defer queue.mux.Unlock()
+	if !delayed && len(queue.messages) == 0 {
+		return false
+	}
+	at := now
+	if delayed {
+		at = now.Add(queue.delay)
+	}
+	queue.messages = append(queue.messages, delayedMessage{outgoing: message, at: at})
+	if !queue.sending {
+		queue.sending = true
+		go queue.send()
+	}
+	return true
+}
+
+func (queue *delayedQueue) send() {
+	for {
+		queue.mux.Lock()
+		if len(queue.messages) == 0 {
+			queue.sending = false
+			queue.mux.Unlock()
+			return
+		}
+		message := queue.messages[0]
+		queue.mux.Unlock()
+		time.Sleep(time.Until(message.at))
+		if queue.controller.Connected {
+			message.send(queue.controller)
+		}
+		queue.mux.Lock()
+		queue.messages = queue.messages[1:]
+		queue.mux.Unlock()
+	}
+}
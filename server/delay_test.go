@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tomasstrnad1997/mines/protocol"
+)
+
+type receivedMessage struct {
+	text string
+	at   time.Time
+}
+
+// Connects a queue to a client that reports every text message it receives
+func connectQueue(t *testing.T, delay time.Duration) (*delayedQueue, <-chan receivedMessage) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	received := make(chan receivedMessage, 16)
+	client := protocol.CreateConnectionController()
+	client.RegisterHandler(protocol.TextMessage, func(data []byte) error {
+		text, err := protocol.DecodeTextMessage(data)
+		if err != nil {
+			return err
+		}
+		received <- receivedMessage{text: text, at: time.Now()}
+		return nil
+	})
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	if err := client.Connect("127.0.0.1", port); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	conn, ok := <-accepted
+	if !ok {
+		t.Fatalf("Failed to accept the connection")
+	}
+	t.Cleanup(func() { conn.Close() })
+	server := protocol.CreateConnectionController()
+	server.SetConnection(conn)
+	go server.ReadServerResponse()
+	go client.ReadServerResponse()
+	return newDelayedQueue(server, delay), received
+}
+
+func pushText(t *testing.T, queue *delayedQueue, text string, now time.Time, delayed bool) {
+	t.Helper()
+	encoded, err := protocol.EncodeTextMessage(text)
+	if err != nil {
+		t.Fatalf("Failed to encode message: %v", err)
+	}
+	if !queue.push(outgoing{data: encoded}, now, delayed) {
+		queue.controller.SendMessage(encoded)
+	}
+}
+
+func receive(t *testing.T, received <-chan receivedMessage) receivedMessage {
+	t.Helper()
+	select {
+	case message := <-received:
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatalf("No message received")
+	}
+	return receivedMessage{}
+}
+
+func TestDelayedQueueDelay(t *testing.T) {
+	delay := 200 * time.Millisecond
+	queue, received := connectQueue(t, delay)
+	start := time.Now()
+	pushText(t, queue, "delayed", start, true)
+	message := receive(t, received)
+	if message.text != "delayed" {
+		t.Fatalf("Received %q instead of the delayed message", message.text)
+	}
+	if elapsed := message.at.Sub(start); elapsed < delay {
+		t.Fatalf("Message arrived after %v, before the delay of %v", elapsed, delay)
+	}
+}
+
+func TestDelayedQueueOrder(t *testing.T) {
+	delay := 100 * time.Millisecond
+	queue, received := connectQueue(t, delay)
+	now := time.Now()
+	pushText(t, queue, "first", now, true)
+	pushText(t, queue, "second", now, true)
+	// Not delayed but must not overtake the messages before it
+	pushText(t, queue, "third", now, false)
+	for _, expected := range []string{"first", "second", "third"} {
+		if message := receive(t, received); message.text != expected {
+			t.Fatalf("Received %q instead of %q", message.text, expected)
+		}
+	}
+}
+
+func TestDelayedQueueLiveSkipsEmptyQueue(t *testing.T) {
+	queue, received := connectQueue(t, 50*time.Millisecond)
+	encoded, err := protocol.EncodeTextMessage("live")
+	if err != nil {
+		t.Fatalf("Failed to encode message: %v", err)
+	}
+	if queue.push(outgoing{data: encoded}, time.Now(), false) {
+		t.Fatalf("Live message was queued although nothing was waiting")
+	}
+	pushText(t, queue, "delayed", time.Now(), true)
+	receive(t, received)
+	// Once the delayed message is sent live messages skip the queue again
+	time.Sleep(50 * time.Millisecond)
+	if queue.push(outgoing{data: encoded}, time.Now(), false) {
+		t.Fatalf("Live message was queued after the queue emptied")
+	}
+}
@@ -2,13 +2,18 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tomasstrnad1997/mines/mines"
+	"github.com/tomasstrnad1997/mines/mines/replay"
+	"github.com/tomasstrnad1997/mines/mines/solver"
 	"github.com/tomasstrnad1997/mines/players"
 	"github.com/tomasstrnad1997/mines/protocol"
 )
@@ -19,6 +24,33 @@ type Player struct {
 	info           *players.PlayerInfo
 	authenticated  bool
 	authResponseCh chan bool
+	// Chunks of an infinite board the player gets cell updates of. Guarded by
+	// the moveMux of the server.
+	chunks map[mines.ChunkPos]bool
+	// Only watches the game. Requested after connecting or granted by the
+	// token of the matchmaking server.
+	spectator bool
+	// Holds back the messages of the player when the server delays them. Nil
+	// when it doesn't.
+	delayed *delayedQueue
+	// Messages to the player go through the delayed queue, e.g. while they
+	// only watch
+	delay atomic.Bool
+	// Made a move in the current game, such players can't become spectators.
+	// Guarded by the moveMux of the server.
+	played bool
+}
+
+// Most chunks a player can be subscribed to at once
+const maxSubscribedChunks = 1024
+
+// Id the moves of the player are made with. Players that did not authenticate
+// have no player info so their local id is used instead.
+func (player *Player) id() uint32 {
+	if player.info != nil {
+		return player.info.ID
+	}
+	return uint32(player.localID)
 }
 
 type MessageHandler func(data []byte, source int) error
@@ -42,12 +74,51 @@ type Server struct {
 	moveMux        sync.Mutex
 	requiresAuth   bool
 	authSecret     []byte
+	// Player who started the game, their undo requests need no vote
+	host *Player
+	// Moves every player voted to undo
+	undoVotes map[*Player]int
+	// Ids of the players added to the game. Players join when the game starts
+	// or when they connect to a running game. Guarded by the moveMux.
+	joined map[uint32]bool
+	// Bumped whenever the clock of the game is (re)started so stale clocks stop
+	clockRun int
+	// File the game is saved to so it survives a restart of the server.
+	// Empty when checkpoints are disabled.
+	checkpointPath string
+	// The game changed since the last checkpoint. Guarded by the moveMux.
+	checkpointDirty bool
+	// Keeps checkpoints from being written at the same time
+	checkpointMux sync.Mutex
+	// Records the match being played. Guarded by the moveMux.
+	recorder *replay.Recorder
+	// Called with the replay of every match that ends, e.g. by the launcher
+	// to have it stored
+	OnMatchEnd func(*replay.Replay)
+	// How long everything sent to spectators is held back. Zero sends it
+	// right away.
+	SpectatorDelay time.Duration
+}
+
+// Implemented by gamemodes in which the players take turns
+type turnTaker interface {
+	CurrentPlayer() uint32
 }
 
 func (server *Server) GetNumberOfPlayers() int {
 	count := 0
 	for _, player := range server.players {
-		if player.controller.Connected {
+		if player.controller.Connected && !player.spectator {
+			count++
+		}
+	}
+	return count
+}
+
+func (server *Server) GetNumberOfSpectators() int {
+	count := 0
+	for _, player := range server.players {
+		if player.controller.Connected && player.spectator {
 			count++
 		}
 	}
@@ -55,28 +126,273 @@ func (server *Server) GetNumberOfPlayers() int {
 }
 
 func (server *Server) GetServerInfo() *protocol.GameServerInfo {
-	return &protocol.GameServerInfo{Name: server.Name, Host: "", Port: server.Port, PlayerCount: server.GetNumberOfPlayers()}
+	return &protocol.GameServerInfo{Name: server.Name, Host: "", Port: server.Port, PlayerCount: server.GetNumberOfPlayers(), SpectatorCount: server.GetNumberOfSpectators()}
 
 }
 
+// Adds the player to the game unless they already joined it. Expects the
+// moveMux to be locked.
+func (server *Server) join(player *Player) {
+	if server.joined[player.id()] {
+		return
+	}
+	server.joined[player.id()] = true
+	server.updateDelay(player)
+	server.game.AddPlayer(player.id())
+	server.recorder.Join(player.id(), time.Now())
+	server.checkpointDirty = true
+}
+
+// Takes the player out of the game once the connection is lost so the game
+// doesn't wait on them, e.g. for their turn
+func (server *Server) leave(player *Player) {
+	server.moveMux.Lock()
+	info := server.removeFromGame(player)
+	server.moveMux.Unlock()
+	if err := server.announceRemoval(info); err != nil {
+		println("Failed to announce the end of the game:", err.Error())
+	}
+}
+
+// Lets everyone know a player is out of the game, which ends it when the
+// players left are all done
+func (server *Server) announceRemoval(info mines.GamemodeUpdateInfo) error {
+	server.broadcastInfo(info)
+	if server.game != nil && server.game.IsOver() {
+		return server.broadcastGameEnd(server.game.Outcome())
+	}
+	return nil
+}
+
+// Removes the player from the game unless another connection still plays
+// under their id. Expects the moveMux to be locked.
+func (server *Server) removeFromGame(player *Player) mines.GamemodeUpdateInfo {
+	if server.game == nil || !server.joined[player.id()] || server.connected(player.id()) {
+		return nil
+	}
+	delete(server.joined, player.id())
+	server.recorder.Leave(player.id(), time.Now())
+	server.checkpointDirty = true
+	return server.game.RemovePlayer(player.id())
+}
+
+func (server *Server) broadcastInfo(info mines.GamemodeUpdateInfo) {
+	if info == nil {
+		return
+	}
+	encoded, err := protocol.EncodeGamemodeInfo(info)
+	if err != nil {
+		println("Failed to encode gamemode info:", err.Error())
+		return
+	}
+	server.broadcast(encoded)
+}
+
+// Reports whether any player with the id is still connected, authenticated
+// players can be connected more than once
+func (server *Server) connected(playerId uint32) bool {
+	for _, player := range server.players {
+		if player.controller.Connected && !player.spectator && player.id() == playerId {
+			return true
+		}
+	}
+	return false
+}
+
 func (server *Server) StartGame(params mines.GameParams) error {
 	game, err := mines.CreateGame(params)
 	if err != nil {
 		return err
 	}
+	server.moveMux.Lock()
+	// Games that allow undo can go on after their end until they get replaced
+	var finished *replay.Recorder
+	if server.game != nil && server.game.IsOver() {
+		finished = server.recorder
+	}
 	server.game = game
+	server.undoVotes = make(map[*Player]int)
+	server.joined = make(map[uint32]bool)
+	server.recorder = replay.NewRecorder(game, time.Now())
+	for _, player := range server.players {
+		// Chunks of the previous board mean nothing on the new one
+		player.chunks = make(map[mines.ChunkPos]bool)
+		player.played = false
+		if player.controller.Connected && !player.spectator {
+			server.join(player)
+		}
+		server.updateDelay(player)
+	}
+	server.moveMux.Unlock()
+	server.saveReplay(finished)
 	//server.broadcastTextMessage(fmt.Sprintf("Starting a new game...\nNumber of mines %d", params.Mines))
 
 	println("Starting a new game")
-	startMsg, err := protocol.EncodeGameStart(params)
+	// Broadcast the params of the created game so the clients get the seed that was used
+	startMsg, err := encodeGameStart(game.Params)
 	if err != nil {
 		return err
 	}
-	server.broadcast(startMsg)
+	server.broadcastFeature(startMsg)
+	// Handcrafted boards can start with revealed cells
+	for _, player := range server.players {
+		if !player.controller.Connected {
+			continue
+		}
+		cellUpdates, err := game.GetPlayerChangedCellUpdates(player.id())
+		if err != nil {
+			return err
+		}
+		if len(cellUpdates) == 0 {
+			continue
+		}
+		updateMsg, err := protocol.EncodeCellUpdates(cellUpdates)
+		if err != nil {
+			return err
+		}
+		sendMessage(updateMsg, player)
+	}
+	server.moveMux.Lock()
 	server.gameRunning = true
+	server.moveMux.Unlock()
+	server.markChanged()
+	server.saveCheckpoint()
+	server.startClock(game)
 	return nil
 }
 
+// Marks the game to be saved by the next checkpoint
+func (server *Server) markChanged() {
+	server.moveMux.Lock()
+	server.checkpointDirty = true
+	server.moveMux.Unlock()
+}
+
+// Saves the game when it changed since the last checkpoint. The file is
+// replaced at once so a crash while writing keeps the previous checkpoint.
+func (server *Server) saveCheckpoint() {
+	server.checkpointMux.Lock()
+	defer server.checkpointMux.Unlock()
+	server.moveMux.Lock()
+	if server.checkpointPath == "" || server.game == nil || !server.checkpointDirty {
+		server.moveMux.Unlock()
+		return
+	}
+	var recorded *replay.Replay
+	if server.recorder != nil {
+		recorded = server.recorder.Replay()
+	}
+	checkpoint := encodeCheckpoint(server.game.Snapshot(), recorded)
+	server.checkpointDirty = false
+	server.moveMux.Unlock()
+	temp := server.checkpointPath + ".tmp"
+	if err := os.WriteFile(temp, checkpoint, 0644); err != nil {
+		println("Failed to write checkpoint:", err.Error())
+		return
+	}
+	if err := os.Rename(temp, server.checkpointPath); err != nil {
+		println("Failed to write checkpoint:", err.Error())
+	}
+}
+
+// Continues the game of the checkpoint left by a previous run of the server
+func (server *Server) restoreCheckpoint() error {
+	if server.checkpointPath == "" {
+		return nil
+	}
+	checkpoint, err := os.ReadFile(server.checkpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	snapshot, recorded, err := decodeCheckpoint(checkpoint)
+	if err != nil {
+		return err
+	}
+	game, err := mines.RestoreGame(snapshot)
+	if err != nil {
+		return err
+	}
+	server.game = game
+	server.undoVotes = make(map[*Player]int)
+	server.joined = make(map[uint32]bool)
+	if recorded != nil {
+		server.recorder = replay.Resume(recorded, game)
+	}
+	println("Restored game from checkpoint")
+	if !game.IsOver() {
+		server.gameRunning = true
+		server.startClock(game)
+	}
+	return nil
+}
+
+func (server *Server) startClock(game *mines.Game) {
+	server.moveMux.Lock()
+	server.clockRun++
+	run := server.clockRun
+	server.moveMux.Unlock()
+	go server.tickGame(game, run)
+}
+
+// Runs the clock of the game. Every second the elapsed time and the changes of
+// timed gamemodes get broadcast until the game ends or gets replaced.
+func (server *Server) tickGame(game *mines.Game, run int) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		server.saveCheckpoint()
+		server.moveMux.Lock()
+		if server.game != game || !server.gameRunning || server.clockRun != run {
+			server.moveMux.Unlock()
+			return
+		}
+		var info mines.GamemodeUpdateInfo
+		if timed, ok := game.Mode.(mines.Ticker); ok {
+			turns, hasTurns := game.Mode.(turnTaker)
+			var current uint32
+			if hasTurns {
+				current = turns.CurrentPlayer()
+			}
+			info = timed.Tick(now)
+			if hasTurns && turns.CurrentPlayer() != current {
+				server.recorder.TurnTimeout(now)
+			}
+		}
+		outcome := game.CheckTimeLimit(now)
+		if outcome != nil {
+			server.recorder.TimeUp(now)
+		}
+		started := game.Started()
+		elapsed := game.Elapsed(now)
+		server.moveMux.Unlock()
+		if started {
+			encoded, err := protocol.EncodeGameTime(elapsed)
+			if err != nil {
+				println("Failed to encode game time:", err.Error())
+			} else {
+				server.broadcast(encoded)
+			}
+		}
+		if info != nil {
+			encoded, err := protocol.EncodeGamemodeInfo(info)
+			if err != nil {
+				println("Failed to encode gamemode info:", err.Error())
+			} else {
+				server.broadcast(encoded)
+			}
+		}
+		if outcome != nil {
+			if err := server.broadcastGameEnd(outcome); err != nil {
+				println("Failed to end the game:", err.Error())
+			}
+			return
+		}
+	}
+}
+
 func (server *Server) broadcastTextMessage(message string) {
 	encoded, err := protocol.EncodeTextMessage(message)
 	if err != nil {
@@ -87,34 +403,111 @@ func (server *Server) broadcastTextMessage(message string) {
 }
 
 func (server *Server) broadcast(data []byte) {
+	server.broadcastFeature(outgoing{data: data})
+}
+
+func (server *Server) broadcastFeature(message outgoing) {
 	for _, player := range server.players {
-		if player.controller.Connected {
-			player.controller.SendMessage(data)
-		}
+		sendFeatureMessage(message, player)
 	}
 }
+// Replies to the player. They tell nothing about the game so they aren't
+// delayed.
 func sendTextMessage(msg string, player *Player) {
 	encoded, err := protocol.EncodeTextMessage(msg)
 	if err != nil {
 		println("Failed to create a message")
 		return
 	}
-	sendMessage(encoded, player)
+	if player.controller.Connected {
+		player.controller.SendMessage(encoded)
+	}
 }
 
 func sendMessage(data []byte, player *Player) {
-	if player.controller.Connected {
-		player.controller.SendMessage(data)
+	sendFeatureMessage(outgoing{data: data}, player)
+}
+
+// Message for the clients that support its features, the others get the
+// fallback or nothing when it is nil
+type outgoing struct {
+	data     []byte
+	features protocol.Features
+	fallback []byte
+}
+
+func (message outgoing) send(controller *protocol.ConnectionController) {
+	controller.SendFeatureMessage(message.features, message.data, message.fallback)
+}
+
+func sendFeatureMessage(message outgoing, player *Player) {
+	if !player.controller.Connected {
+		return
+	}
+	if player.delayed != nil && player.delayed.push(message, time.Now(), player.delay.Load()) {
+		return
+	}
+	message.send(player.controller)
+}
+
+// Lets the player only watch the game. Expects the moveMux to be locked.
+func (server *Server) makeSpectator(player *Player) {
+	player.spectator = true
+	server.updateDelay(player)
+}
+
+// Delays the messages of spectators. In competitive games the messages of
+// every connection that didn't join are delayed too so nobody can watch the
+// match live without playing it. Expects the moveMux to be locked.
+func (server *Server) updateDelay(player *Player) {
+	if player.delayed == nil {
+		return
 	}
+	competitive := server.game != nil && server.game.Params.GameMode.Competitive()
+	player.delay.Store(player.spectator || (competitive && !server.joined[player.id()]))
+}
+
+// Params of the game that can be shown to players. The layout of a
+// handcrafted board would give away its mines.
+func publicParams(params mines.GameParams) mines.GameParams {
+	params.Layout = nil
+	return params
+}
+
+// Clients that don't know the game options only get the basic params
+func encodeGameStart(params mines.GameParams) (outgoing, error) {
+	encoded, err := protocol.EncodeGameStart(publicParams(params))
+	if err != nil {
+		return outgoing{}, err
+	}
+	basic := mines.GameParams{Width: params.Width, Height: params.Height, Mines: params.Mines, GameMode: params.GameMode, Seed: params.Seed}
+	fallback, err := protocol.EncodeGameStart(basic)
+	if err != nil {
+		return outgoing{}, err
+	}
+	return outgoing{data: encoded, features: protocol.FeatureGameOptions, fallback: fallback}, nil
 }
 
 func (server *Server) sendInitialMessages(player *Player) error {
-	startMsg, err := protocol.EncodeGameStart(server.game.Params)
+	startMsg, err := encodeGameStart(server.game.Params)
 	if err != nil {
 		return err
 	}
-	sendMessage(startMsg, player)
-	cellUpdates, err := server.game.GetChangedCellUpdates()
+	sendFeatureMessage(startMsg, player)
+	// Players of infinite boards subscribe to the chunks they want to see
+	if server.game.Params.Infinite {
+		return nil
+	}
+	server.moveMux.Lock()
+	// Players without a board of their own, e.g. spectators, see the board
+	// every player started with
+	var cellUpdates []mines.UpdatedCell
+	if !player.spectator && server.game.HasOwnBoard(player.id()) {
+		cellUpdates, err = server.game.GetPlayerChangedCellUpdates(player.id())
+	} else {
+		cellUpdates, err = server.game.GetChangedCellUpdates()
+	}
+	server.moveMux.Unlock()
 	if err != nil {
 		return err
 	}
@@ -127,6 +520,54 @@ func (server *Server) sendInitialMessages(player *Player) error {
 	return nil
 }
 
+// Finds a hint using only what the player can see of the board
+func (server *Server) findHint(player *Player) (*solver.Hint, error) {
+	params := server.game.Params
+	view, err := solver.NewView(params.Width, params.Height, params.Mines)
+	if err != nil {
+		return nil, err
+	}
+	if view.Topology, err = mines.GetTopologyById(params.Topology); err != nil {
+		return nil, err
+	}
+	if params.Mask != nil {
+		if err := view.ApplyMask(params.Mask); err != nil {
+			return nil, err
+		}
+	}
+	cellUpdates, err := server.game.GetPlayerChangedCellUpdates(player.id())
+	if err != nil {
+		return nil, err
+	}
+	if err := view.Apply(cellUpdates); err != nil {
+		return nil, err
+	}
+	return solver.BestMove(view)
+}
+
+// Sends the updated cells of an infinite board to the players subscribed to
+// their chunks
+func (server *Server) sendChunkUpdates(cells []mines.UpdatedCell) error {
+	for chunk, chunkCells := range mines.GroupByChunk(cells) {
+		encoded, err := protocol.EncodeChunkCellUpdates(chunk, chunkCells)
+		if err != nil {
+			return err
+		}
+		server.moveMux.Lock()
+		var subscribers []*Player
+		for _, player := range server.players {
+			if player.chunks[chunk] {
+				subscribers = append(subscribers, player)
+			}
+		}
+		server.moveMux.Unlock()
+		for _, player := range subscribers {
+			sendFeatureMessage(outgoing{data: encoded, features: protocol.FeatureChunks}, player)
+		}
+	}
+	return nil
+}
+
 func (player *Player) RegisterAuthHandlers(server *Server) {
 	player.controller.RegisterHandler(protocol.AuthWithMMToken, func(bytes []byte) error {
 		token, err := protocol.DecodeAuthWithMMToken(bytes)
@@ -134,6 +575,11 @@ func (player *Player) RegisterAuthHandlers(server *Server) {
 			return err
 		}
 		success, err := players.ValidateAuthToken(token, server.authSecret)
+		if success && token.Spectator {
+			server.moveMux.Lock()
+			server.makeSpectator(player)
+			server.moveMux.Unlock()
+		}
 		player.authResponseCh <- success
 		if err != nil {
 			return err
@@ -144,21 +590,162 @@ func (player *Player) RegisterAuthHandlers(server *Server) {
 }
 
 func RegisterHandlers(player *Player, server *Server) {
+	player.controller.RegisterHandler(protocol.SpectateRequest, func(bytes []byte) error {
+		if err := protocol.DecodeSpectateRequest(bytes); err != nil {
+			return err
+		}
+		server.moveMux.Lock()
+		if player.spectator {
+			server.moveMux.Unlock()
+			return nil
+		}
+		played := player.played
+		var info mines.GamemodeUpdateInfo
+		if !played {
+			// Players joined the game when they connected
+			server.makeSpectator(player)
+			info = server.removeFromGame(player)
+		}
+		server.moveMux.Unlock()
+		if played {
+			sendTextMessage("Players who made a move can't become spectators", player)
+			return nil
+		}
+		if err := server.announceRemoval(info); err != nil {
+			return err
+		}
+		sendTextMessage("You are spectating", player)
+		// The board they got as a player isn't the one spectators see
+		if server.gameRunning {
+			return server.sendInitialMessages(player)
+		}
+		return nil
+	})
 	player.controller.RegisterHandler(protocol.StartGame, func(bytes []byte) error {
 		params, err := protocol.DecodeGameStart(bytes)
 		if err != nil {
 			return err
 		}
+		if player.spectator {
+			sendTextMessage("Spectators can't start games", player)
+			return nil
+		}
+		// Checked before the running game gets aborted for a board that can't be created
+		if !params.Infinite {
+			if err := mines.CheckBoardSize(params.Width, params.Height); err != nil {
+				sendTextMessage(fmt.Sprintf("Board of size %dx%d is too large", params.Width, params.Height), player)
+				return nil
+			}
+		}
 		if server.gameRunning {
-			msg, err := protocol.EncodeGameEnd(protocol.Aborted)
+			msg, err := protocol.EncodeGameEnd(protocol.Aborted, &mines.GameOutcome{Reason: mines.EndAborted})
 			if err != nil {
 				return err
 			}
 			server.broadcast(msg)
 		}
 		//server.broadcastTextMessage(fmt.Sprintf("Player %d requested new game", player.id))
+		server.host = player
 		return server.StartGame(*params)
 	})
+	player.controller.RegisterHandler(protocol.HintRequest, func(bytes []byte) error {
+		if err := protocol.DecodeHintRequest(bytes); err != nil {
+			return err
+		}
+		// The solver needs the whole board which infinite boards don't have
+		if !server.gameRunning || server.game.Params.Infinite || player.spectator {
+			return nil
+		}
+		if server.game.Params.GameMode.Competitive() {
+			sendTextMessage("Hints are only available in casual games", player)
+			return nil
+		}
+		server.moveMux.Lock()
+		hint, err := server.findHint(player)
+		server.moveMux.Unlock()
+		if errors.Is(err, solver.ErrNoMoves) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		encoded, err := protocol.EncodeHint(hint)
+		if err != nil {
+			return err
+		}
+		sendMessage(encoded, player)
+		return nil
+	})
+	player.controller.RegisterHandler(protocol.UndoRequest, func(bytes []byte) error {
+		moves, err := protocol.DecodeUndoRequest(bytes)
+		if err != nil {
+			return err
+		}
+		if server.game == nil || !server.game.Params.AllowUndo {
+			sendTextMessage("Undo is not allowed in this game", player)
+			return nil
+		}
+		if player.spectator {
+			sendTextMessage("Spectators can't undo moves", player)
+			return nil
+		}
+		server.moveMux.Lock()
+		moves, voted, needed := server.voteUndo(player, moves)
+		server.moveMux.Unlock()
+		if voted < needed {
+			server.broadcastTextMessage(fmt.Sprintf("Player %d wants to undo %d moves (%d/%d votes)", player.id(), moves, voted, needed))
+			return nil
+		}
+		return server.undo(moves)
+	})
+	player.controller.RegisterHandler(protocol.SubscribeChunks, func(bytes []byte) error {
+		chunks, err := protocol.DecodeSubscribeChunks(bytes)
+		if err != nil {
+			return err
+		}
+		if !server.gameRunning || !server.game.Params.Infinite {
+			return nil
+		}
+		var messages [][]byte
+		server.moveMux.Lock()
+		for _, chunk := range chunks {
+			if len(player.chunks) >= maxSubscribedChunks {
+				break
+			}
+			player.chunks[chunk] = true
+			cells, err := server.game.ChunkCellUpdates(player.id(), chunk)
+			if err != nil {
+				server.moveMux.Unlock()
+				return err
+			}
+			if len(cells) == 0 {
+				continue
+			}
+			encoded, err := protocol.EncodeChunkCellUpdates(chunk, cells)
+			if err != nil {
+				server.moveMux.Unlock()
+				return err
+			}
+			messages = append(messages, encoded)
+		}
+		server.moveMux.Unlock()
+		for _, encoded := range messages {
+			sendFeatureMessage(outgoing{data: encoded, features: protocol.FeatureChunks}, player)
+		}
+		return nil
+	})
+	player.controller.RegisterHandler(protocol.UnsubscribeChunks, func(bytes []byte) error {
+		chunks, err := protocol.DecodeUnsubscribeChunks(bytes)
+		if err != nil {
+			return err
+		}
+		server.moveMux.Lock()
+		for _, chunk := range chunks {
+			delete(player.chunks, chunk)
+		}
+		server.moveMux.Unlock()
+		return nil
+	})
 	player.controller.RegisterHandler(protocol.MoveCommand, func(bytes []byte) error {
 		if !server.gameRunning {
 			//sendTextMessage("Game not running. Cant make moves.", player)
@@ -168,49 +755,228 @@ func RegisterHandlers(player *Player, server *Server) {
 		if err != nil {
 			return err
 		}
-		move.PlayerId = player.info.ID
+		if player.spectator {
+			sendTextMessage("Spectators can't make moves", player)
+			return nil
+		}
+		move.PlayerId = player.id()
 		server.moveMux.Lock()
+		fellBack := server.game.NoGuessFallback()
 		moveResult, gamemodeInfo, err := server.game.MakeMove(*move)
+		fellBack = !fellBack && server.game.NoGuessFallback()
+		if err == nil {
+			player.played = true
+			server.recorder.Move(*move, time.Now())
+		}
+		var cells []mines.UpdatedCell
+		if err == nil && len(moveResult.UpdatedCells) > 0 {
+			server.checkpointDirty = true
+			// Numbers of infinite boards are looked up in chunks other moves change
+			cells, err = server.game.CreatePlayerCellUpdates(player.id(), moveResult.UpdatedCells)
+		}
 		server.moveMux.Unlock()
+		if errors.Is(err, mines.ErrNotYourTurn) {
+			sendTextMessage("Not your turn", player)
+			return nil
+		}
+		if errors.Is(err, mines.ErrMoveNotAllowed) {
+			sendTextMessage("Move not allowed in this gamemode", player)
+			return nil
+		}
+		if errors.Is(err, mines.ErrEliminated) {
+			sendTextMessage("You have no lives left", player)
+			return nil
+		}
 		if err != nil {
 			return err
 		}
+		if fellBack {
+			server.broadcastTextMessage("No board without guessing was found, this one may need a guess")
+		}
 		if len(moveResult.UpdatedCells) > 0 {
-			cells, err := server.game.CreateCellUpdates(moveResult.UpdatedCells)
-			if err != nil {
-				return err
-			}
-			encoded, err := protocol.EncodeCellUpdates(cells)
-			if err != nil {
-				return err
+			if server.game.Params.Infinite {
+				if err := server.sendChunkUpdates(cells); err != nil {
+					return err
+				}
+			} else {
+				encoded, err := protocol.EncodeCellUpdates(cells)
+				if err != nil {
+					return err
+				}
+				// Other players can't see a separate board
+				if server.game.HasSeparateBoards() {
+					sendMessage(encoded, player)
+				} else {
+					server.broadcast(encoded)
+				}
 			}
-			server.broadcast(encoded)
 			if gamemodeInfo != nil {
-				encoded, err = protocol.EncodeGamemodeInfo(gamemodeInfo)
+				encoded, err := protocol.EncodeGamemodeInfo(gamemodeInfo)
 				if err != nil {
 					return err
 				}
 				server.broadcast(encoded)
 			}
 		}
-		var endMsg []byte
-		switch moveResult.Result {
-		case mines.MineBlown:
-			endMsg, err = protocol.EncodeGameEnd(protocol.Loss)
-		case mines.GameWon:
-			endMsg, err = protocol.EncodeGameEnd(protocol.Win)
-		default:
-			endMsg, err = nil, nil
+		// The gamemode decides whether the game is over, e.g. mines only cost a life
+		if server.game.IsOver() {
+			return server.broadcastGameEnd(server.game.Outcome())
+		}
+		// On separate boards a blown mine only ends the game of the player that blew it
+		if server.game.HasSeparateBoards() && moveResult.Result == mines.MineBlown {
+			return sendGameEnd(&mines.GameOutcome{Reason: mines.EndMineBlown, PlayerId: player.id()}, player)
+		}
+		return nil
+	})
+}
+
+// Counts the vote of the player to undo moves. The host decides alone, other
+// players need a majority. Returns the moves to undo, the number of votes and
+// the votes needed. Expects the moveMux to be locked.
+func (server *Server) voteUndo(player *Player, moves int) (int, int, int) {
+	if player == server.host {
+		return moves, 1, 1
+	}
+	server.undoVotes[player] = moves
+	connected := server.GetNumberOfPlayers()
+	voted := 0
+	for voter, voterMoves := range server.undoVotes {
+		if voter.controller.Connected {
+			voted++
+			// Everybody agreed to the fewest moves asked for
+			moves = min(moves, voterMoves)
+		}
+	}
+	return moves, voted, connected/2 + 1
+}
+
+// Takes back the moves and sends the reverted cells to the players. A game
+// ended by one of the moves goes on again.
+func (server *Server) undo(moves int) error {
+	server.moveMux.Lock()
+	wasRunning := server.gameRunning
+	result, err := server.game.Undo(moves)
+	if err == nil {
+		server.undoVotes = make(map[*Player]int)
+		server.gameRunning = true
+		server.checkpointDirty = true
+		server.recorder.Undo(result.Moves, time.Now())
+	}
+	elapsed := server.game.Elapsed(time.Now())
+	server.moveMux.Unlock()
+	if errors.Is(err, mines.ErrNothingToUndo) {
+		server.broadcastTextMessage("No moves to undo")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !wasRunning {
+		server.startClock(server.game)
+	}
+	// Hidden cells are sent as Unflag updates
+	if len(result.UpdatedCells) > 0 {
+		encoded, err := protocol.EncodeCellUpdates(result.UpdatedCells)
+		if err != nil {
+			return err
 		}
+		server.broadcast(encoded)
+	}
+	for _, info := range result.Infos {
+		encoded, err := protocol.EncodeGamemodeInfo(info)
 		if err != nil {
 			return err
 		}
-		if endMsg != nil {
-			server.broadcast(endMsg)
-			server.gameRunning = false
+		server.broadcast(encoded)
+	}
+	encoded, err := protocol.EncodeGameTime(elapsed)
+	if err != nil {
+		return err
+	}
+	server.broadcast(encoded)
+	encoded, err = protocol.EncodeMovesUndone(result.Moves)
+	if err != nil {
+		return err
+	}
+	server.broadcastFeature(outgoing{data: encoded, features: protocol.FeatureUndo})
+	return nil
+}
+
+// Sends the outcome to every player, each player gets their own win or loss.
+// Moves and the clock can both see the end, only the first one announces it.
+func (server *Server) broadcastGameEnd(outcome *mines.GameOutcome) error {
+	server.moveMux.Lock()
+	running := server.gameRunning
+	server.gameRunning = false
+	server.moveMux.Unlock()
+	if !running {
+		return nil
+	}
+	for _, player := range server.players {
+		if err := sendGameEnd(outcome, player); err != nil {
+			return err
 		}
+	}
+	if !server.game.Params.AllowUndo {
+		server.endRecording()
+	}
+	server.markChanged()
+	server.saveCheckpoint()
+	return nil
+}
+
+// Stops recording the match and saves its replay. Games that allow undo are
+// only finished once the next game replaces them, moves taken back after the
+// end are part of their replay.
+func (server *Server) endRecording() {
+	server.moveMux.Lock()
+	recorder := server.recorder
+	server.recorder = nil
+	server.moveMux.Unlock()
+	server.saveReplay(recorder)
+}
+
+// Hands the replay of a finished match to OnMatchEnd
+func (server *Server) saveReplay(recorder *replay.Recorder) {
+	if recorder != nil && server.OnMatchEnd != nil {
+		server.OnMatchEnd(recorder.Replay())
+	}
+}
+
+// Lets the players know under which match id the replay of their match can
+// be found
+func (server *Server) ReplaySaved(matchId uint32) {
+	encoded, err := protocol.EncodeTextMessage(fmt.Sprintf("Replay saved as match %d", matchId))
+	if err != nil {
+		println("Failed to create message")
+		return
+	}
+	// Clients without replays can't look the match up
+	server.broadcastFeature(outgoing{data: encoded, features: protocol.FeatureReplays})
+}
+
+func sendGameEnd(outcome *mines.GameOutcome, player *Player) error {
+	var endType protocol.GameEndType = protocol.Loss
+	if player.spectator {
+		endType = protocol.Watched
+	} else if outcome.Won(player.id()) {
+		endType = protocol.Win
+	}
+	endMsg, err := protocol.EncodeGameEnd(endType, outcome)
+	if err != nil {
+		return err
+	}
+	if endType != protocol.Watched {
+		sendMessage(endMsg, player)
 		return nil
-	})
+	}
+	// Spectators granted by a token may not know the end type of spectators
+	fallback, err := protocol.EncodeGameEnd(protocol.Aborted, outcome)
+	if err != nil {
+		return err
+	}
+	sendFeatureMessage(outgoing{data: endMsg, features: protocol.FeatureSpectators, fallback: fallback}, player)
+	return nil
 }
 
 func createServer(id int, name string, port uint16) (*Server, error) {
@@ -235,8 +1001,19 @@ func createServer(id int, name string, port uint16) (*Server, error) {
 		Port:           uint16(serverPort),
 		clients:        clients,
 		players:        players,
+		joined:         make(map[uint32]bool),
 		authSecret:     []byte(os.Getenv("AUTH_SECRET")),
 	}
+	if dir := os.Getenv("CHECKPOINT_DIR"); dir != "" {
+		server.checkpointPath = filepath.Join(dir, fmt.Sprintf("server-%d.checkpoint", id))
+	}
+	// e.g. "30s" to keep streams of a match half a minute behind its players
+	if delay := os.Getenv("SPECTATOR_DELAY"); delay != "" {
+		if server.SpectatorDelay, err = time.ParseDuration(delay); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("Invalid spectator delay %q: %v", delay, err)
+		}
+	}
 	return server, nil
 }
 
@@ -260,6 +1037,7 @@ func (player *Player) deleteAuthHandlers(){
 
 func (server *Server) handleNewConnection(conn net.Conn, localId int) {
 	controller := protocol.CreateConnectionController()
+	controller.MinVersion = protocol.OldestServedVersion
 	if err := controller.SetConnection(conn); err != nil {
 		println(err)
 		return
@@ -268,13 +1046,20 @@ func (server *Server) handleNewConnection(conn net.Conn, localId int) {
 		localID:        localId,
 		controller:     controller,
 		authResponseCh: make(chan bool),
+		chunks:         make(map[mines.ChunkPos]bool),
 	}
+	if server.SpectatorDelay > 0 {
+		player.delayed = newDelayedQueue(controller, server.SpectatorDelay)
+	}
+	server.moveMux.Lock()
+	server.updateDelay(player)
+	server.moveMux.Unlock()
 	server.players[player.localID] = player
 	if server.requiresAuth {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 		defer cancel()
 		player.RegisterAuthHandlers(server)
-		go controller.ReadServerResponse()
+		go server.readPlayer(player)
 		select {
 		case <-ctx.Done():
 			// TODO: close connection
@@ -295,18 +1080,35 @@ func (server *Server) handleNewConnection(conn net.Conn, localId int) {
 	} else {
 		player.authenticated = true
 		RegisterHandlers(player, server)
-		go controller.ReadServerResponse()
+		go server.readPlayer(player)
+	}
+	// Spectators granted by the token are known by now, everyone else plays
+	server.moveMux.Lock()
+	running := server.gameRunning
+	if running && !player.spectator {
+		server.join(player)
 	}
-	if server.gameRunning {
+	server.moveMux.Unlock()
+	if running {
 		server.sendInitialMessages(player)
 	}
 }
 
+// Reads the messages of the player until the connection is lost
+func (server *Server) readPlayer(player *Player) {
+	player.controller.ReadServerResponse()
+	server.leave(player)
+}
+
 func SpawnServer(id int, name string, port uint16) (*Server, error) {
 	server, err := createServer(id, name, port)
 	if err != nil {
 		return nil, err
 	}
+	// A broken checkpoint shouldn't keep the server from starting a new game
+	if err := server.restoreCheckpoint(); err != nil {
+		println("Failed to restore checkpoint:", err.Error())
+	}
 	go playerAcceptLoop(server)
 	return server, nil
 }
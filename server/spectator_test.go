@@ -0,0 +1,128 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tomasstrnad1997/mines/mines"
+	"github.com/tomasstrnad1997/mines/protocol"
+)
+
+// Connects to the server and reports the text messages it receives. Every
+// other message is ignored.
+func connectClient(t *testing.T, server *Server) (*protocol.ConnectionController, <-chan string) {
+	t.Helper()
+	client := protocol.CreateConnectionController()
+	for msgType := range 256 {
+		client.RegisterHandler(protocol.MessageType(msgType), func([]byte) error { return nil })
+	}
+	texts := make(chan string, 16)
+	client.RegisterHandler(protocol.TextMessage, func(data []byte) error {
+		text, err := protocol.DecodeTextMessage(data)
+		if err != nil {
+			return err
+		}
+		texts <- text
+		return nil
+	})
+	if err := client.Connect("127.0.0.1", server.Port); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go client.ReadServerResponse()
+	return client, texts
+}
+
+func send(t *testing.T, client *protocol.ConnectionController, encoded []byte, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Failed to encode message: %v", err)
+	}
+	if err := client.SendMessage(encoded); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+}
+
+func expectText(t *testing.T, texts <-chan string, expected string) {
+	t.Helper()
+	select {
+	case text := <-texts:
+		if text != expected {
+			t.Fatalf("Received %q instead of %q", text, expected)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Did not receive %q", expected)
+	}
+}
+
+func TestSpectatorCantPlay(t *testing.T) {
+	server, err := SpawnServer(1, "Spectated", 0)
+	if err != nil {
+		t.Fatalf("Failed to spawn server: %v", err)
+	}
+	t.Cleanup(func() { server.server.Close() })
+	player, _ := connectClient(t, server)
+	params := mines.GameParams{Width: 5, Height: 5, Mines: 3, Seed: 1, GameMode: mines.ModeCoop, AllowUndo: true}
+	encoded, err := protocol.EncodeGameStart(params)
+	send(t, player, encoded, err)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		server.moveMux.Lock()
+		started := server.game != nil
+		server.moveMux.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Game was not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	server.moveMux.Lock()
+	game := server.game
+	server.moveMux.Unlock()
+
+	spectator, texts := connectClient(t, server)
+	encoded, err = protocol.EncodeSpectateRequest()
+	send(t, spectator, encoded, err)
+	expectText(t, texts, "You are spectating")
+
+	encoded, err = protocol.EncodeMove(mines.Move{X: 2, Y: 2, Type: mines.Reveal})
+	send(t, spectator, encoded, err)
+	expectText(t, texts, "Spectators can't make moves")
+	encoded, err = protocol.EncodeGameStart(params)
+	send(t, spectator, encoded, err)
+	expectText(t, texts, "Spectators can't start games")
+	encoded, err = protocol.EncodeUndoRequest(1)
+	send(t, spectator, encoded, err)
+	expectText(t, texts, "Spectators can't undo moves")
+
+	server.moveMux.Lock()
+	defer server.moveMux.Unlock()
+	if server.game != game {
+		t.Fatalf("Spectator replaced the game")
+	}
+	if updates, err := game.GetChangedCellUpdates(); err != nil || len(updates) != 0 {
+		t.Fatalf("Spectator changed %d cells of the board (%v)", len(updates), err)
+	}
+	info := server.GetServerInfo()
+	if info.PlayerCount != 1 || info.SpectatorCount != 1 {
+		t.Fatalf("Server info counts %d players and %d spectators instead of 1 and 1", info.PlayerCount, info.SpectatorCount)
+	}
+}
+
+func TestStartTooLargeBoard(t *testing.T) {
+	server, err := SpawnServer(1, "Large", 0)
+	if err != nil {
+		t.Fatalf("Failed to spawn server: %v", err)
+	}
+	t.Cleanup(func() { server.server.Close() })
+	player, texts := connectClient(t, server)
+	encoded, err := protocol.EncodeGameStart(mines.GameParams{Width: 65535, Height: 65535, Mines: 10, GameMode: mines.ModeCoop})
+	send(t, player, encoded, err)
+	expectText(t, texts, "Board of size 65535x65535 is too large")
+	server.moveMux.Lock()
+	defer server.moveMux.Unlock()
+	if server.game != nil {
+		t.Fatalf("Started a game on a board that is too large")
+	}
+}